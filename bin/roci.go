@@ -2,24 +2,37 @@ package main
 
 import (
 	"archive/tar"
+	"bufio"
 	"bytes"
+	"cmp"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log"
+	"maps"
 	"os"
 	"path"
 	"path/filepath"
+	"runtime"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/containers/buildah"
 	"github.com/containers/buildah/define"
 	"github.com/containers/buildah/imagebuildah"
+	"github.com/containers/buildah/pkg/parse"
 	"github.com/google/rpmpack"
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/urfave/cli/v3"
+	"go.podman.io/common/libimage"
+	podmanConfig "go.podman.io/common/pkg/config"
 	"go.podman.io/image/v5/docker/reference"
+	"go.podman.io/image/v5/manifest"
 	"go.podman.io/image/v5/pkg/blobinfocache/none"
 	"go.podman.io/image/v5/pkg/compression"
 	imgStorage "go.podman.io/image/v5/storage"
@@ -45,26 +58,90 @@ func main() {
 				Usage:     "Build RPM package from OCI image",
 				ArgsUsage: "dist-git-dir",
 				Action:    buildCommand,
+				Flags: append(buildFlags(), &cli.BoolFlag{
+					Name:  "show-config",
+					Usage: "print the effective yaml config for the release instead of building",
+				}),
+			},
+			{
+				Name:      "rebuild",
+				Usage:     "Rebuild the RPM packages from a source RPM",
+				ArgsUsage: "source-rpm",
+				Action:    rebuildCommand,
+				Flags: append(buildFlags(), &cli.StringFlag{
+					Name:    "output-dir",
+					Aliases: []string{"o"},
+					Value:   ".",
+					Usage:   "directory into which the RPM packages are written",
+				}),
+			},
+			{
+				Name:      "lint",
+				Usage:     "Check the yaml config file for problems",
+				ArgsUsage: "dist-git-dir",
+				Action:    lintCommand,
 				Flags: []cli.Flag{
-					&cli.StringFlag{
-						Name:    "file",
-						Aliases: []string{"f"},
-						Value:   "Containerfile",
-						Usage:   "filename of to the Containerfile/Dockerfile, defaults to Containerfile",
-					},
 					&cli.StringFlag{
 						Name:    "yaml-file",
 						Aliases: []string{"c"},
 						Usage:   "name of the yaml config file",
 					},
+				},
+			},
+			{
+				Name:      "export-spec",
+				Usage:     "Write a spec file and the payload of the packages, so that rpmbuild can build them",
+				ArgsUsage: "dist-git-dir",
+				Action:    exportSpecCommand,
+				Flags: append(buildFlags(), &cli.StringFlag{
+					Name:    "output-dir",
+					Aliases: []string{"o"},
+					Value:   ".",
+					Usage:   "directory into which the spec file and the payload tarballs are written",
+				}),
+			},
+			{
+				Name:      "import-spec",
+				Usage:     "Convert a spec file into a yaml config file and a Containerfile",
+				ArgsUsage: "spec-file",
+				Action:    importSpecCommand,
+				Flags: []cli.Flag{
 					&cli.StringFlag{
-						Name:    "release",
-						Aliases: []string{"r"},
-						Value:   "",
-						Usage:   "Distribution release to target",
+						Name:    "output-dir",
+						Aliases: []string{"o"},
+						Value:   ".",
+						Usage:   "directory into which the yaml config file and the Containerfile are written",
+					},
+				},
+			},
+			{
+				Name:   "schema",
+				Usage:  "Print the JSON schema of the yaml config file",
+				Action: schemaCommand,
+			},
+			{
+				Name:  "buildroot",
+				Usage: "Manage the buildroot images of the distributions",
+				Commands: []*cli.Command{
+					{
+						Name:   "create",
+						Usage:  "Create the buildroot image of a release from its distribution profile",
+						Action: buildrootCreateCommand,
+						Flags: append(distroFlags(), &cli.StringFlag{
+							Name:    "tag",
+							Aliases: []string{"t"},
+							Usage:   "name of the buildroot image, defaults to the Buildroot of the distribution profile",
+						}),
 					},
 				},
 			},
+			{
+				Name:      "verify-reproducible",
+				Usage:     "Build the RPM packages twice in isolated storage and compare the results",
+				ArgsUsage: "dist-git-dir",
+				Action:    verifyReproducibleCommand,
+				Flags:     buildFlags(),
+			},
 		},
 	}
 
@@ -75,96 +152,324 @@ func main() {
 	}
 }
 
+// distroFlags returns the flags that select the distribution profile
+func distroFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:    "release",
+			Aliases: []string{"r"},
+			Value:   "",
+			Usage:   "Distribution release to target, e.g. f41, el9 or tw",
+		},
+		&cli.StringFlag{
+			Name:    "distro-profiles",
+			Usage:   "yaml file with additional distribution profiles, defaults to roci/distros.yaml in the user's config directory",
+			Sources: cli.EnvVars("ROCI_DISTRO_PROFILES"),
+		},
+	}
+}
+
+// buildFlags returns the flags of all commands that build the packages
+func buildFlags() []cli.Flag {
+	return append(distroFlags(), []cli.Flag{
+		&cli.StringFlag{
+			Name:    "file",
+			Aliases: []string{"f"},
+			Value:   "Containerfile",
+			Usage:   "filename of to the Containerfile/Dockerfile, defaults to Containerfile",
+		},
+		&cli.StringFlag{
+			Name:    "yaml-file",
+			Aliases: []string{"c"},
+			Usage:   "name of the yaml config file",
+		},
+		&cli.StringSliceFlag{
+			Name:  "with",
+			Usage: "enable the conditional from the yaml config file",
+		},
+		&cli.StringSliceFlag{
+			Name:  "without",
+			Usage: "disable the conditional from the yaml config file",
+		},
+		&cli.BoolFlag{
+			Name:  "strict",
+			Usage: "fail on every problem that `roci lint` reports in the yaml config file",
+		},
+		&cli.Int64Flag{
+			Name:    "source-date-epoch",
+			Usage:   "unix timestamp used as the build time and to clamp the file modification times, defaults to the time of the last commit in the dist-git directory",
+			Sources: cli.EnvVars("SOURCE_DATE_EPOCH"),
+		},
+	}...)
+}
+
 type Build struct {
 	store       storage.Store
 	config      roci.Config
+	configFile  string
 	distGit     string
 	buildRecipe string
-	distTag     string
 	ctx         context.Context
+
+	// distro is the profile of the release, nil if no release is targeted
+	distro *roci.DistroProfile
+	// buildrootLabels are the labels of the buildroot image, see
+	// inspectBuildroot
+	buildrootLabels map[string]string
+
+	// strict, with, without and autoRelease are the options of the
+	// config, see loadConfig
+	strict        bool
+	with, without []string
+	autoRelease   *roci.AutoRelease
+
+	// outputDir is the directory into which the rpms are written
+	outputDir string
+	// sourceDateEpoch is nil if the build is not reproducible
+	sourceDateEpoch *time.Time
+	// changelog of all packages, derived from the git history
+	changelog []roci.ChangelogEntry
+	// conditionals are the config's conditionals and whether they are
+	// enabled
+	conditionals map[string]bool
+}
+
+// distroProfile returns the profile of the release from the command line
+// arguments of `cmd`, nil if no release is given
+func distroProfile(cmd *cli.Command) (*roci.DistroProfile, error) {
+	release := cmd.String("release")
+	if release == "" {
+		return nil, nil
+	}
+
+	var userFiles []string
+	if f := cmd.String("distro-profiles"); f != "" {
+		userFiles = append(userFiles, f)
+	} else if dir, err := os.UserConfigDir(); err == nil {
+		f := filepath.Join(dir, "roci", "distros.yaml")
+		if _, err := os.Stat(f); err == nil {
+			userFiles = append(userFiles, f)
+		}
+	}
+
+	registry, err := roci.NewDistroRegistry(userFiles...)
+	if err != nil {
+		return nil, err
+	}
+	return registry.Lookup(release)
 }
 
-func releaseToDistTag(release string) string {
-	switch {
-	case strings.HasPrefix(release, "f"):
-		return release[1:]
-	case strings.HasPrefix(release, "el"):
-		return release[2:]
-	default:
-		// TODO openSUSE, SLES, mandriva
-		return ""
+// configFile returns the path of the config file `yamlFile` in the dist-git
+// directory `distGitDir`. If it is empty, then the first yaml file in the
+// directory is used.
+func configFile(distGitDir, yamlFile string) (string, error) {
+	if yamlFile != "" {
+		return filepath.Join(distGitDir, yamlFile), nil
+	}
+
+	// no yaml file provided? => glob it and pick first match
+	matches, err := filepath.Glob(path.Join(distGitDir, "*.yaml"))
+	if err != nil {
+		return "", err
+	}
+	if len(matches) == 0 {
+		return "", errors.New("No yaml file in dist-git dir")
 	}
+	return matches[0], nil
 }
 
-// NewBuild creates a new Build from the
-func NewBuild(ctx context.Context, cmd *cli.Command) (*Build, error) {
+// NewBuild creates a new Build of the dist-git directory `distGit` from the
+// command line arguments of `cmd` that uses the container storage configured by
+// `storeOptions`.
+// `autoRelease` is determined from the git history of `distGit` if it is nil.
+func NewBuild(ctx context.Context, cmd *cli.Command, distGit string, storeOptions storage.StoreOptions, autoRelease *roci.AutoRelease) (*Build, error) {
 	// we must store the dist-git dir as the absolute path, as we will be
 	// using it as build context in the user namespace. There we loose the
 	// current working directory and a relative path will resolve wrongly
-	distGitDir, err := filepath.Abs(cmd.Args().First())
+	distGitDir, err := filepath.Abs(distGit)
 	if err != nil {
 		return nil, err
 	}
 
-	yamlFileName := cmd.String("yaml-file")
-	// no yaml file provided? => glob it and pick first match
-	if yamlFileName == "" {
-		matches, err := filepath.Glob(path.Join(distGitDir, "*.yaml"))
+	yamlFileName, err := configFile(distGitDir, cmd.String("yaml-file"))
+	if err != nil {
+		return nil, err
+	}
+	distro, err := distroProfile(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	if autoRelease == nil {
+		autoRelease, err = roci.AutoReleaseFromGit(distGitDir, yamlFileName)
 		if err != nil {
-			return nil, err
-		}
-		if matches == nil || len(matches) == 0 {
-			return nil, errors.New("No yaml file in dist-git dir")
+			log.Printf("Cannot determine the automatic release, AUTORELEASE is undefined: %v", err)
 		}
+	}
 
-		yamlFileName = matches[0]
+	var sourceDateEpoch *time.Time
+	if cmd.IsSet("source-date-epoch") {
+		t := time.Unix(cmd.Int64("source-date-epoch"), 0).UTC()
+		sourceDateEpoch = &t
+	} else if t, err := roci.LastCommitTime(distGitDir); err == nil {
+		sourceDateEpoch = &t
 	} else {
-		yamlFileName = filepath.Join(distGitDir, yamlFileName)
+		log.Printf("Cannot determine the source date epoch, the build will not be reproducible: %v", err)
 	}
 
+	b := &Build{
+		configFile:      yamlFileName,
+		distGit:         distGitDir,
+		outputDir:       distGitDir,
+		buildRecipe:     cmd.String("file"),
+		distro:          distro,
+		ctx:             ctx,
+		strict:          cmd.Bool("strict"),
+		with:            cmd.StringSlice("with"),
+		without:         cmd.StringSlice("without"),
+		autoRelease:     autoRelease,
+		sourceDateEpoch: sourceDateEpoch,
+	}
 	// actual config load
-	config, err := roci.LoadConfig(yamlFileName)
-	if err != nil {
+	if err := b.loadConfig(); err != nil {
 		return nil, err
 	}
 
 	// container image store
-	storeOptions, err := storage.DefaultStoreOptions()
-	if err != nil {
+	if b.store, err = storage.GetStore(storeOptions); err != nil {
 		return nil, err
 	}
-	store, err := storage.GetStore(storeOptions)
+	return b, nil
+}
+
+// loadConfig loads the config file and applies the overrides of the release,
+// the conditionals and the variables to it. The macros of the distribution
+// and of the buildroot's labels are the defaults of the variables.
+// It runs again after inspectBuildroot, as the buildroot can change the macros
+// and the dist tag.
+func (b *Build) loadConfig() error {
+	loadConfig := roci.LoadConfig
+	if b.strict {
+		loadConfig = roci.LoadConfigStrict
+	}
+	config, err := loadConfig(b.configFile)
 	if err != nil {
-		return nil, err
+		return err
+	}
+
+	var overrideNames []string
+	var distTag, dist string
+	if b.distro != nil {
+		overrideNames = b.distro.Names()
+		distTag, dist = b.distro.DistTag, b.distro.Dist
+
+		// the macros of the distribution are the defaults of the
+		// variables
+		macros := maps.Clone(b.distro.Macros)
+		if macros == nil {
+			macros = make(map[string]string)
+		}
+		maps.Copy(macros, roci.BuildrootMacros(b.buildrootLabels))
+		for name, value := range macros {
+			if _, ok := config.Variables[name]; !ok {
+				if config.Variables == nil {
+					config.Variables = make(map[string]string)
+				}
+				config.Variables[name] = value
+			}
+		}
+	}
+	if err := config.ApplyOverrides(overrideNames...); err != nil {
+		return fmt.Errorf("%s: %w", b.configFile, err)
+	}
+	config.InheritPreamble()
+
+	var autoReleaseValue string
+	var changelog []roci.ChangelogEntry
+	if b.autoRelease != nil {
+		autoReleaseValue = strconv.Itoa(b.autoRelease.Release) + distTag
+		changelog = b.autoRelease.Changelog
+	}
+
+	// the conditionals define variables, so they must be known before the
+	// expansion
+	conditionals, err := config.ApplyConditionals(b.with, b.without)
+	if err != nil {
+		return fmt.Errorf("%s: %w", b.configFile, err)
+	}
+
+	if err := config.Expand(map[string]string{
+		"DIST":        dist,
+		"ARCH":        roci.RpmArch(runtime.GOARCH),
+		"AUTORELEASE": autoReleaseValue,
+	}); err != nil {
+		return fmt.Errorf("%s: %w", b.configFile, err)
+	}
+
+	// an explicit changelog replaces the one from the git history
+	if config.Changelog != "" {
+		changelog, err = roci.ParseChangelog(config.Changelog)
+		if err != nil {
+			return fmt.Errorf("%s: %w", b.configFile, err)
+		}
 	}
 
-	return &Build{
-		store:       store,
-		config:      *config,
-		distGit:     distGitDir,
-		buildRecipe: cmd.String("file"),
-		distTag:     releaseToDistTag(cmd.String("release")),
-		ctx:         ctx,
-	}, nil
+	b.config = *config
+	b.changelog = changelog
+	b.conditionals = conditionals
+	return nil
 }
 
 // commonBuildArgs returns a map of the build arguments added to the build:
-// DIST: disttag
+// DIST: Dist of the distribution profile, e.g. 41 for f41
+// BUILDROOT: buildroot image of the distribution profile
 // NAME: package name
 // RELEASE: release
 // VERSION: package version
+// SOURCE_DATE_EPOCH: timestamp for reproducible builds
+// WITH_<CONDITIONAL>: 1 if the conditional is enabled, 0 otherwise
+// _libdir, optflags, …: the macros of the buildroot image or the distribution
+// profile
 // only build arguments with values != "" are added
 func (b *Build) commonBuildArgs() map[string]string {
+	sourceDateEpoch := ""
+	if b.sourceDateEpoch != nil {
+		sourceDateEpoch = strconv.FormatInt(b.sourceDateEpoch.Unix(), 10)
+	}
+
+	var dist, buildroot string
+	if b.distro != nil {
+		dist, buildroot = b.distro.Dist, b.distro.Buildroot
+	}
+
 	data := []struct {
 		Name  string
 		Value string
 	}{
-		{"DIST", b.distTag},
+		{"DIST", dist},
+		{"BUILDROOT", buildroot},
 		{"VERSION", b.config.Version},
 		{"NAME", b.config.Name},
 		{"RELEASE", b.config.Release},
+		{"SOURCE_DATE_EPOCH", sourceDateEpoch},
+	}
+	for name, enabled := range b.conditionals {
+		value := "0"
+		if enabled {
+			value = "1"
+		}
+		data = append(data, struct {
+			Name  string
+			Value string
+		}{roci.ConditionalBuildArg(name), value})
 	}
+
+	// the built-in arguments win over macros of the same name
 	args := make(map[string]string)
+	if b.distro != nil {
+		maps.Copy(args, b.distro.Macros)
+	}
+	maps.Copy(args, roci.BuildrootMacros(b.buildrootLabels))
 	for _, d := range data {
 		if d.Value != "" {
 			args[d.Name] = d.Value
@@ -173,20 +478,69 @@ func (b *Build) commonBuildArgs() map[string]string {
 	return args
 }
 
+// inspectBuildroot reads the labels of the buildroot image, the base image of
+// the buildrequires stage, before the stages are built. The image is pulled if
+// it does not exist yet. The labels must agree with the release, they replace
+// its dist tag, vendor and distribution and the config is loaded again with
+// them.
+// Without a release the buildroot is only inspected if the Containerfile names
+// it itself, as it is usually derived from the release.
+func (b *Build) inspectBuildroot() error {
+	name, err := roci.StageBaseImage(b.containerfile(), "buildrequires", b.commonBuildArgs())
+	if err != nil {
+		return err
+	}
+	if _, err := reference.ParseNormalizedNamed(name); err != nil {
+		if b.distro == nil {
+			return nil
+		}
+		return fmt.Errorf("the buildroot %q of the release %s is no valid image: %w", name, b.distro.Release, err)
+	}
+
+	rt, err := libimage.RuntimeFromStore(b.store, nil)
+	if err != nil {
+		return err
+	}
+	pullOptions := &libimage.PullOptions{}
+	pullOptions.Writer = os.Stderr
+	images, err := rt.Pull(b.ctx, name, podmanConfig.PullPolicyMissing, pullOptions)
+	if err != nil {
+		return fmt.Errorf("failed to pull the buildroot %s: %w", name, err)
+	}
+	labels, err := images[0].Labels(b.ctx)
+	if err != nil {
+		return err
+	}
+
+	distro, err := roci.BuildrootDistro(labels, b.distro)
+	if err != nil {
+		return err
+	}
+	b.buildrootLabels, b.distro = labels, distro
+	return b.loadConfig()
+}
+
 func (b *Build) buildStage(targetStage string, outputTag string, withNetwork bool) (string, reference.Canonical, error) {
-	// FIXME: need to set sourcedateepoch here
 	buildOptions := define.BuildOptions{
 		Target: targetStage,
 		Output: outputTag,
 
 		Args:             b.commonBuildArgs(),
 		ContextDirectory: b.distGit,
+		// the base image is recorded in the annotations of OCI images
+		// only, which we need to calculate the contents of each stage
+		OutputFormat: define.OCIv1ImageManifest,
 
 		// these two must be false so that the layers on top of the base
 		// image are squashed
 		NoCache: false,
 		Layers:  false,
 
+		// the image creation times and the timestamps of the files in
+		// the layers must not depend on when the build ran
+		SourceDateEpoch:  b.sourceDateEpoch,
+		RewriteTimestamp: b.sourceDateEpoch != nil,
+
 		// emit useful output
 		Out: os.Stdout,
 		Err: os.Stderr,
@@ -225,74 +579,6 @@ func (b *Build) ImageFromId(id string) (types.ImageCloser, error) {
 	return ref, nil
 }
 
-// AddRpmDependenciesFromConfig extracts the string representation of dependency
-// information (like requires, provides) from the package `rpmPkg` and appends
-// them to the existing metadata of `m`. The modified rpm metadata are returned
-// on success.
-// If any of the dependencies cannot be converted into a proper relation, then
-// an error is returned.
-func AddRpmDependenciesFromConfig(m rpmpack.RPMMetaData, rpmPkg roci.RpmPackage) (rpmpack.RPMMetaData, error) {
-	strToRelations := func(deps []string) ([]*rpmpack.Relation, error) {
-		rels := make([]*rpmpack.Relation, len(deps))
-		for i, d := range deps {
-			r, err := rpmpack.NewRelation(d)
-			if err != nil {
-				return nil, err
-			}
-			rels[i] = r
-		}
-		return rels, nil
-	}
-
-	provides, err := strToRelations(rpmPkg.Provides)
-	if err != nil {
-		return rpmpack.RPMMetaData{}, err
-	}
-
-	var allRequires []string
-	allRequires = append(allRequires, rpmPkg.Requires...)
-	allRequires = append(allRequires, rpmPkg.RequiresPre...)
-	allRequires = append(allRequires, rpmPkg.RequiresPost...)
-	allRequires = append(allRequires, rpmPkg.RequiresPreUn...)
-	allRequires = append(allRequires, rpmPkg.RequiresPostUn...)
-	allRequires = append(allRequires, rpmPkg.RequiresPreTrans...)
-	allRequires = append(allRequires, rpmPkg.RequiresPostTrans...)
-	allRequires = append(allRequires, rpmPkg.RequiresVerify...)
-	allRequires = append(allRequires, rpmPkg.RequiresInterp...)
-	allRequires = append(allRequires, rpmPkg.RequiresMeta...)
-
-	requires, err := strToRelations(allRequires)
-	if err != nil {
-		return rpmpack.RPMMetaData{}, err
-	}
-
-	obsoletes, err := strToRelations(rpmPkg.Obsoletes)
-	if err != nil {
-		return rpmpack.RPMMetaData{}, err
-	}
-	conflicts, err := strToRelations(rpmPkg.Conflicts)
-	if err != nil {
-		return rpmpack.RPMMetaData{}, err
-	}
-	recommends, err := strToRelations(rpmPkg.Recommends)
-	if err != nil {
-		return rpmpack.RPMMetaData{}, err
-	}
-	suggests, err := strToRelations(rpmPkg.Suggests)
-	if err != nil {
-		return rpmpack.RPMMetaData{}, err
-	}
-
-	m.Provides = append(m.Provides, provides...)
-	m.Requires = append(m.Requires, requires...)
-	m.Obsoletes = append(m.Obsoletes, obsoletes...)
-	m.Conflicts = append(m.Conflicts, conflicts...)
-	m.Recommends = append(m.Recommends, recommends...)
-	m.Suggests = append(m.Suggests, suggests...)
-
-	return m, nil
-}
-
 // AddRpmMetadataFromImageLabels extracts the labels from the supplied image and
 // sets the version, URL, title, description, license, name, release and epoch
 // fields from image labels.
@@ -339,135 +625,379 @@ func (b *Build) AddRpmMetadataFromImageLabels(m rpmpack.RPMMetaData, img types.I
 	return m, nil
 }
 
-// WalkTopLayerTree decompresses the top layer of the supplied image `img` and
-// invokes `callback` on each node in the layer.
+// BaseImage returns the image from which the stage image `img` was built.
+// The caller *must* call close on the returned imageCloser if error is non-nil
 //
-// `callback` can abort walking the tree by returning an error, then this
-// function immediately returns said error.
-func (b *Build) WalkTopLayerTree(img types.Image, callback func(path string, hdr *tar.Header, contents []byte) error) error {
-	blobInfo, err := img.LayerInfosForCopy(b.ctx)
+// buildah records the digest of the `FROM` image in the annotations of each
+// image that it builds.
+func (b *Build) BaseImage(img types.Image) (types.ImageCloser, error) {
+	rawManifest, mimeType, err := img.Manifest(b.ctx)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	src, err := img.Reference().NewImageSource(b.ctx, nil)
+	if mimeType != imgspecv1.MediaTypeImageManifest {
+		return nil, fmt.Errorf("cannot determine the base image from a manifest of type %s", mimeType)
+	}
+	m, err := manifest.OCI1FromManifest(rawManifest)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer src.Close()
 
-	// blobInfo is ordered from the root layer to the top most layer
-	// in theory there should be just two layer though
-	topLayerBlob := blobInfo[len(blobInfo)-1]
-	blob, _, err := src.GetBlob(b.ctx, topLayerBlob, none.NoCache)
+	baseDigest, ok := m.Annotations[imgspecv1.AnnotationBaseImageDigest]
+	if !ok {
+		return nil, errors.New("image has no base image annotation")
+	}
+	d, err := digest.Parse(baseDigest)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	images, err := b.store.ImagesByDigest(d)
+	if err != nil {
+		return nil, err
+	}
+	if len(images) == 0 {
+		return nil, fmt.Errorf("base image %s not found", d)
+	}
+
+	return b.ImageFromId(images[0].ID)
+}
+
+// imageLayers returns the layers of `img` ordered from the root layer to the
+// top most layer
+func (b *Build) imageLayers(img types.Image) ([]types.BlobInfo, error) {
+	layers, err := img.LayerInfosForCopy(b.ctx)
+	if err != nil {
+		return nil, err
+	}
+	// nil means that the layers are not modified when copying
+	if layers == nil {
+		layers = img.LayerInfos()
+	}
+	return layers, nil
+}
+
+// openLayer returns the decompressed tar stream of the layer `layer` from the
+// image source `src`. The caller must close the returned stream.
+func (b *Build) openLayer(src types.ImageSource, layer types.BlobInfo) (io.ReadCloser, error) {
+	blob, _, err := src.GetBlob(b.ctx, layer, none.NoCache)
+	if err != nil {
+		return nil, err
 	}
-	defer blob.Close()
 
 	decompressedStream, _, err := compression.AutoDecompress(blob)
 	if err != nil {
-		return err
+		blob.Close()
+		return nil, err
 	}
-	defer decompressedStream.Close()
 
-	tarRdr := tar.NewReader(decompressedStream)
-	for {
-		hdr, err := tarRdr.Next()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return err
+	return struct {
+		io.Reader
+		io.Closer
+	}{decompressedStream, closers{decompressedStream, blob}}, nil
+}
+
+// closers closes all its elements and returns the first error
+type closers []io.Closer
+
+func (c closers) Close() error {
+	var firstErr error
+	for _, cl := range c {
+		if err := cl.Close(); err != nil && firstErr == nil {
+			firstErr = err
 		}
+	}
+	return firstErr
+}
 
-		path, err := filepath.Abs(filepath.Join("/", hdr.Name))
+// applyLayers applies the layers `layers` from `src` onto `tree`
+func (b *Build) applyLayers(tree *roci.LayerTree, src types.ImageSource, layers []types.BlobInfo, hash bool) error {
+	for _, layer := range layers {
+		rdr, err := b.openLayer(src, layer)
 		if err != nil {
 			return err
 		}
-
-		switch hdr.Typeflag {
-		case tar.TypeLink | tar.TypeSymlink | tar.TypeChar | tar.TypeBlock | tar.TypeDir | tar.TypeFifo:
-			err = callback(path, hdr, nil)
-		case tar.TypeReg:
-			b := make([]byte, hdr.Size)
-			_, err = tarRdr.Read(b)
-			if err != io.EOF {
-				return err
-			}
-			err = callback(path, hdr, b)
-		}
-
+		err = tree.ApplyLayer(layer.Digest.String(), rdr, hash)
+		rdr.Close()
 		if err != nil {
 			return err
 		}
-
 	}
-
 	return nil
 }
 
-// AutoReqProv calculates the automated Requires, Provides, etc of the files in
-// `filelist` and returns them in the RPMMetaData struct
-func (b *Build) AutoReqProv(imgId string, filelist []string) (rpmpack.RPMMetaData, error) {
-	builderOpts := buildah.BuilderOptions{
-		FromImage: imgId,
-	}
-	builder, err := buildah.NewBuilder(b.ctx, b.store, builderOpts)
+// StageTree are the nodes that a stage image added or modified compared to its
+// base image
+type StageTree struct {
+	b   *Build
+	ref types.ImageReference
+	// layers of the stage image, ordered from the root layer
+	layers []types.BlobInfo
+	// sorted paths of the changed nodes by the digest of the layer that
+	// last modified them
+	pathsPerLayer map[string][]string
+	// detached are the hardlinks whose target is not part of the stage by
+	// the path of the target, they are packaged as regular files
+	detached map[string][]string
+	// headers of the detached hardlinks
+	linkHeaders map[string]*tar.Header
+}
+
+// StageTree calculates the difference between the filesystem of the stage
+// image `img` and its base image (the buildroot) across all layers.
+//
+// Files of the base image that were deleted in the stage cannot be expressed in
+// a rpm and result in an error.
+func (b *Build) StageTree(img types.Image) (*StageTree, error) {
+	baseImg, err := b.BaseImage(img)
 	if err != nil {
-		return rpmpack.RPMMetaData{}, err
+		return nil, fmt.Errorf("failed to find the base image: %w", err)
 	}
-	defer builder.Delete() // Clean up the working container when done
+	defer baseImg.Close()
 
-	buff := bytes.Buffer{}
-	runOptions := buildah.RunOptions{
-		Stdout: &buff,
-		Stderr: os.Stderr,
-		// we must set RPM_BUILD_ROOT as otherwise ELF libraries cannot
-		// be processed by rpmdeps
-		// FIXME: this is actually not entirely correct, we should define this as a build ARG somewhere
-		Env:      []string{"RPM_BUILD_ROOT=/"},
-		Terminal: buildah.WithoutTerminal,
+	layers, err := b.imageLayers(img)
+	if err != nil {
+		return nil, err
 	}
-
-	cmd := append([]string{"/usr/lib/rpm/rpmdeps", "--alldeps"}, filelist...)
-	err = builder.Run(cmd, runOptions)
+	baseLayers, err := b.imageLayers(baseImg)
 	if err != nil {
-		return rpmpack.RPMMetaData{}, err
+		return nil, err
 	}
 
-	// don't commit the result! we just want the contents of buff to
-	// calculate the dependencies
-	return roci.ParseRpmdepsOutput(buff.String())
-}
-
-func (b *Build) RpmFromLayer(id string, rpmPkg roci.RpmPackage) (*rpmpack.RPM, error) {
-	img, err := b.ImageFromId(id)
+	src, err := img.Reference().NewImageSource(b.ctx, nil)
 	if err != nil {
 		return nil, err
 	}
-	defer img.Close()
-
-	metaData := rpmpack.RPMMetaData{
-		// what else?
-		OS: "linux",
-		// TODO: buildhost?
-
-		// TODO: should be configurable
-		Compressor: "zstd",
-
-		// unlikely to be set, but will certainly not be overridden
-		// below
-		Group:    rpmPkg.Group,
-		Packager: rpmPkg.Packager,
-		Vendor:   rpmPkg.Vendor,
+	defer src.Close()
+	baseSrc, err := baseImg.Reference().NewImageSource(b.ctx, nil)
+	if err != nil {
+		return nil, err
 	}
+	defer baseSrc.Close()
 
-	metaData, err = b.AddRpmMetadataFromImageLabels(metaData, img)
-	if err != nil {
+	// the layers shared by both images are identical and only need to be
+	// read once
+	common := 0
+	for common < len(layers) && common < len(baseLayers) && layers[common].Digest == baseLayers[common].Digest {
+		common++
+	}
+	sharedTree := roci.NewLayerTree()
+	if err := b.applyLayers(sharedTree, src, layers[:common], false); err != nil {
 		return nil, err
 	}
 
-	// now get the remaining metadata from the rpmPkg struct
+	baseTree := sharedTree.Clone()
+	if err := b.applyLayers(baseTree, baseSrc, baseLayers[common:], true); err != nil {
+		return nil, err
+	}
+	tree := sharedTree
+	if err := b.applyLayers(tree, src, layers[common:], true); err != nil {
+		return nil, err
+	}
+
+	changed, removed := tree.Diff(baseTree)
+	if len(removed) > 0 {
+		return nil, fmt.Errorf("the stage removes files from its base image: %s", strings.Join(removed, ", "))
+	}
+
+	// the contents of hardlinks to files of the base image are read from
+	// the layer of their target instead of the link itself
+	detached := tree.DetachedHardlinks(changed)
+	linkHeaders := make(map[string]*tar.Header)
+	for _, links := range detached {
+		for _, link := range links {
+			linkHeaders[link] = tree.Entry(link).Header
+		}
+	}
+	paths := slices.DeleteFunc(slices.Clone(changed), func(p string) bool { return linkHeaders[p] != nil })
+	paths = append(paths, slices.Collect(maps.Keys(detached))...)
+	slices.Sort(paths)
+
+	// an entry is only taken from the layer that last modified it
+	pathsPerLayer := make(map[string][]string)
+	for _, p := range paths {
+		layer := tree.Entry(p).Layer
+		pathsPerLayer[layer] = append(pathsPerLayer[layer], p)
+	}
+
+	return &StageTree{b: b, ref: img.Reference(), layers: layers, pathsPerLayer: pathsPerLayer, detached: detached, linkHeaders: linkHeaders}, nil
+}
+
+// Walk invokes `callback` on each node that the stage added or modified. The
+// contents of regular files are streamed from the layers and must be consumed
+// by `callback` before it returns, other nodes have no contents.
+//
+// `callback` can abort walking the tree by returning an error, then this
+// function immediately returns said error.
+func (t *StageTree) Walk(callback func(path string, hdr *tar.Header, contents io.Reader) error) error {
+	src, err := t.ref.NewImageSource(t.b.ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	for _, layer := range t.layers {
+		paths := t.pathsPerLayer[layer.Digest.String()]
+		if len(paths) == 0 {
+			continue
+		}
+		if err := t.b.walkLayer(src, layer, paths, t.detachLinks(callback)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// detachLinks wraps `callback`, so that the targets of detached hardlinks are
+// passed as their links instead. The first link gets the contents, the others
+// become hardlinks to it.
+func (t *StageTree) detachLinks(callback func(path string, hdr *tar.Header, contents io.Reader) error) func(path string, hdr *tar.Header, contents io.Reader) error {
+	return func(path string, hdr *tar.Header, contents io.Reader) error {
+		links, ok := t.detached[path]
+		if !ok {
+			return callback(path, hdr, contents)
+		}
+		for i, link := range links {
+			linkHdr := *t.linkHeaders[link]
+			if i == 0 {
+				linkHdr.Typeflag = tar.TypeReg
+				linkHdr.Linkname = ""
+				linkHdr.Size = hdr.Size
+				if err := callback(link, &linkHdr, contents); err != nil {
+					return err
+				}
+				continue
+			}
+			linkHdr.Linkname = links[0]
+			if err := callback(link, &linkHdr, nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// walkLayer invokes `callback` on each node of `layer` whose path is in the
+// sorted list `paths`
+func (b *Build) walkLayer(src types.ImageSource, layer types.BlobInfo, paths []string, callback func(path string, hdr *tar.Header, contents io.Reader) error) error {
+	decompressedStream, err := b.openLayer(src, layer)
+	if err != nil {
+		return err
+	}
+	defer decompressedStream.Close()
+
+	tarRdr := tar.NewReader(decompressedStream)
+	for {
+		hdr, err := tarRdr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		path := roci.EntryPath(hdr.Name)
+		if _, found := slices.BinarySearch(paths, path); !found {
+			continue
+		}
+
+		// only regular files have contents, all other types are fully
+		// described by their header
+		var contents io.Reader
+		if hdr.Typeflag == tar.TypeReg {
+			contents = tarRdr
+		}
+		if err := callback(path, hdr, contents); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// AutoReqProv calculates the automated Requires, Provides, etc of the files in
+// `filelist` and returns them
+func (b *Build) AutoReqProv(imgId string, filelist []string) (roci.RpmDependencies, error) {
+	builderOpts := buildah.BuilderOptions{
+		FromImage: imgId,
+	}
+	builder, err := buildah.NewBuilder(b.ctx, b.store, builderOpts)
+	if err != nil {
+		return roci.RpmDependencies{}, err
+	}
+	defer builder.Delete() // Clean up the working container when done
+
+	buff := bytes.Buffer{}
+	runOptions := buildah.RunOptions{
+		Stdout: &buff,
+		Stderr: os.Stderr,
+		// we must set RPM_BUILD_ROOT as otherwise ELF libraries cannot
+		// be processed by rpmdeps
+		// FIXME: this is actually not entirely correct, we should define this as a build ARG somewhere
+		Env:      []string{"RPM_BUILD_ROOT=/"},
+		Terminal: buildah.WithoutTerminal,
+	}
+
+	cmd := append([]string{"/usr/lib/rpm/rpmdeps", "--alldeps"}, filelist...)
+	err = builder.Run(cmd, runOptions)
+	if err != nil {
+		return roci.RpmDependencies{}, err
+	}
+
+	// don't commit the result! we just want the contents of buff to
+	// calculate the dependencies
+	return roci.ParseRpmdepsOutput(buff.String())
+}
+
+// SourceRpmName returns the file name of the source rpm from which the package
+// with the metadata `m` is built
+func SourceRpmName(m rpmpack.RPMMetaData) string {
+	if m.Release != "" {
+		return fmt.Sprintf("%s-%s-%s.src.rpm", m.Name, m.Version, m.Release)
+	}
+	return fmt.Sprintf("%s-%s.src.rpm", m.Name, m.Version)
+}
+
+// RpmFromLayer assembles the rpm package `rpmPkg` from the image with the id
+// `id`.
+//
+// If `mainPkg` is not nil, then the package is a subpackage of `mainPkg` and
+// inherits its build time, version, release and source rpm.
+func (b *Build) RpmFromLayer(id string, rpmPkg roci.RpmPackage, mainPkg *rpmpack.RPMMetaData) (*roci.Rpm, error) {
+	img, err := b.ImageFromId(id)
+	if err != nil {
+		return nil, err
+	}
+	defer img.Close()
+
+	metaData := rpmpack.RPMMetaData{
+		// what else?
+		OS: "linux",
+		// TODO: buildhost?
+
+		Compressor: "zstd",
+
+		// unlikely to be set, but will certainly not be overridden
+		// below
+		Group:    rpmPkg.Group,
+		Packager: rpmPkg.Packager,
+		Vendor:   rpmPkg.Vendor,
+	}
+
+	if b.distro != nil {
+		metaData.Compressor = cmp.Or(b.distro.Compressor, metaData.Compressor)
+		metaData.Vendor = cmp.Or(metaData.Vendor, b.distro.Vendor)
+	}
+
+	metaData, err = b.AddRpmMetadataFromImageLabels(metaData, img)
+	if err != nil {
+		return nil, err
+	}
+	if b.sourceDateEpoch != nil {
+		metaData.BuildTime = *b.sourceDateEpoch
+	}
+
+	// now get the remaining metadata from the rpmPkg struct
 
 	// yes, the following code makes my eyes bleed, but the only way to
 	// do this in a controlled loop is with reflection, and that makes
@@ -501,30 +1031,69 @@ func (b *Build) RpmFromLayer(id string, rpmPkg roci.RpmPackage) (*rpmpack.RPM, e
 		metaData.Epoch = uint32(rpmPkg.Epoch)
 	}
 
-	m, err := AddRpmDependenciesFromConfig(metaData, rpmPkg)
+	// subpackages are built from the same source rpm as the main package
+	// and must therefore match it
+	sourceRpm := SourceRpmName(metaData)
+	if mainPkg != nil {
+		metaData.Version = mainPkg.Version
+		metaData.Release = mainPkg.Release
+		metaData.BuildTime = mainPkg.BuildTime
+		sourceRpm = SourceRpmName(*mainPkg)
+
+		// the config inherits the preamble of the main package, this
+		// covers fields that the main package got from its labels
+		for _, f := range []struct {
+			field *string
+			value string
+		}{
+			{&metaData.Licence, mainPkg.Licence},
+			{&metaData.URL, mainPkg.URL},
+			{&metaData.Vendor, mainPkg.Vendor},
+			{&metaData.Packager, mainPkg.Packager},
+			{&metaData.Group, mainPkg.Group},
+		} {
+			if *f.field == "" {
+				*f.field = f.value
+			}
+		}
+	}
+
+	deps, err := roci.RpmDependenciesFromConfig(rpmPkg)
 	if err != nil {
 		return nil, err
 	}
 
 	// Assembly time!!
-	rpm, err := rpmpack.NewRPM(m)
+	rpm, err := roci.NewRpm(metaData)
+	if err != nil {
+		return nil, err
+	}
+	rpm.SourceRpm = sourceRpm
+	rpm.Changelog = b.changelog
+	if distribution := b.distribution(rpmPkg); distribution != "" {
+		rpm.SetTag(roci.TagDistribution, roci.EntryString(distribution))
+	}
+
+	if err := roci.AddScriptlets(rpm, rpmPkg); err != nil {
+		return nil, err
+	}
+
+	stage, err := b.StageTree(img)
 	if err != nil {
 		return nil, err
 	}
 
 	filelist := make([]string, 0)
-	err = b.WalkTopLayerTree(img, func(path string, hdr *tar.Header, body []byte) error {
+	err = stage.Walk(func(path string, hdr *tar.Header, contents io.Reader) error {
 		filelist = append(filelist, path)
 
-		f := rpmpack.RPMFile{
-			Name:  path,
-			Mode:  uint(hdr.Mode),
-			Owner: hdr.Uname,
-			Group: hdr.Gname,
-			MTime: uint32(hdr.ModTime.Unix()),
-			Body:  body,
-			// FIXME: this is generally wrong
-			Type: rpmpack.GenericFile,
+		f, err := roci.RpmFileFromTar(path, hdr, contents)
+		if err != nil {
+			return err
+		}
+		// like rpm's %clamp_mtime_to_source_date_epoch
+		if b.sourceDateEpoch != nil {
+			f.MTime = min(f.MTime, uint32(b.sourceDateEpoch.Unix()))
 		}
 		rpm.AddFile(f)
 		return nil
@@ -533,64 +1102,701 @@ func (b *Build) RpmFromLayer(id string, rpmPkg roci.RpmPackage) (*rpmpack.RPM, e
 		return nil, err
 	}
 
-	autoMetadata, err := b.AutoReqProv(id, filelist)
+	// the contents are read from the layers a second time when writing
+	// the rpm, so that they never have to be held in memory
+	rpm.Contents = func(fn func(name string, contents io.Reader) error) error {
+		return stage.Walk(func(path string, hdr *tar.Header, contents io.Reader) error {
+			if contents == nil {
+				return nil
+			}
+			return fn(path, contents)
+		})
+	}
+
+	autoDeps, err := b.AutoReqProv(id, filelist)
 	if err != nil {
 		return nil, err
 	}
 
-	rpm.Provides = append(rpm.Provides, autoMetadata.Provides...)
-	rpm.Requires = append(rpm.Requires, autoMetadata.Requires...)
-	rpm.Recommends = append(rpm.Recommends, autoMetadata.Recommends...)
-	rpm.Obsoletes = append(rpm.Obsoletes, autoMetadata.Obsoletes...)
-	rpm.Suggests = append(rpm.Suggests, autoMetadata.Suggests...)
-	rpm.Conflicts = append(rpm.Conflicts, autoMetadata.Conflicts...)
+	rpm.Dependencies.Append(deps)
+	rpm.Dependencies.Append(autoDeps)
 
 	return rpm, nil
 }
 
+// distribution returns the Distribution header of the package `rpmPkg`, the
+// config wins over the distribution profile
+func (b *Build) distribution(rpmPkg roci.RpmPackage) string {
+	if b.distro != nil {
+		return cmp.Or(rpmPkg.Distribution, b.distro.Distribution)
+	}
+	return rpmPkg.Distribution
+}
+
+// assembleRpm builds the stage `stage` and assembles the rpm package `rpmPkg`
+// from it
+func (b *Build) assembleRpm(stage string, rpmPkg roci.RpmPackage, mainPkg *rpmpack.RPMMetaData) (*roci.Rpm, error) {
+	id, _, err := b.buildStage(stage, stage, false)
+	if err != nil {
+		return nil, err
+	}
+	return b.RpmFromLayer(id, rpmPkg, mainPkg)
+}
+
+// buildRpm builds the stage `stage` and writes the rpm package `rpmPkg`
+// assembled from it into the dist-git directory.
+// The metadata of the package are returned on success.
+func (b *Build) buildRpm(stage string, rpmPkg roci.RpmPackage, mainPkg *rpmpack.RPMMetaData) (*rpmpack.RPMMetaData, error) {
+	rpm, err := b.assembleRpm(stage, rpmPkg, mainPkg)
+	if err != nil {
+		return nil, err
+	}
+
+	rpmPath := filepath.Join(b.outputDir, rpm.Name+".rpm")
+	f, err := os.Create(rpmPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if err := rpm.Write(f); err != nil {
+		return nil, err
+	}
+
+	return &rpm.RPMMetaData, f.Close()
+}
+
+// containerfile returns the path to the Containerfile
+func (b *Build) containerfile() string {
+	if filepath.IsAbs(b.buildRecipe) {
+		return b.buildRecipe
+	}
+	return filepath.Join(b.distGit, b.buildRecipe)
+}
+
+// buildSourceRpm writes the source rpm of the main package `mainPkg` into the
+// output directory. It contains the Containerfile, the config file and all
+// files that the buildrequires stage copies from the dist-git directory.
+func (b *Build) buildSourceRpm(mainPkg *rpmpack.RPMMetaData) error {
+	// the same files as in the build context are ignored, as well as
+	// the packages of earlier builds
+	excludes, _, err := parse.ContainerIgnoreFile(b.distGit, "", []string{b.containerfile()})
+	if err != nil {
+		return err
+	}
+	if rel, err := filepath.Rel(b.distGit, b.outputDir); err == nil && rel == "." {
+		excludes = append(excludes, "*.rpm")
+	} else if err == nil && !strings.HasPrefix(rel, "..") {
+		excludes = append(excludes, filepath.ToSlash(rel))
+	}
+
+	sources, err := roci.StageSources(b.containerfile(), b.distGit, "buildrequires", b.commonBuildArgs(), excludes)
+	if err != nil {
+		return fmt.Errorf("failed to determine the sources: %w", err)
+	}
+
+	m := *mainPkg
+	if b.config.SourceLicense != "" {
+		m.Licence = b.config.SourceLicense
+	}
+	rpm, err := roci.NewRpm(m)
+	if err != nil {
+		return err
+	}
+	rpm.SourcePackage = true
+	rpm.Changelog = b.changelog
+	if distribution := b.distribution(b.config.RpmPackage); distribution != "" {
+		rpm.SetTag(roci.TagDistribution, roci.EntryString(distribution))
+	}
+	rpm.SetTag(roci.TagSource, roci.EntryStringSlice(sources))
+
+	// the files are stored relative to the dist-git directory
+	paths := make(map[string]string)
+	for _, src := range sources {
+		paths[src] = filepath.Join(b.distGit, filepath.FromSlash(src))
+	}
+	var configName string
+	for _, p := range []string{b.containerfile(), b.configFile} {
+		name, err := filepath.Rel(b.distGit, p)
+		if err != nil || strings.HasPrefix(name, "..") {
+			name = filepath.Base(p)
+		}
+		if p == b.configFile {
+			configName = filepath.ToSlash(name)
+		}
+		paths[filepath.ToSlash(name)] = p
+	}
+	// the changelog file must stay next to the config file
+	changelogFile := filepath.Join(filepath.Dir(b.configFile), roci.ChangelogFileName)
+	if _, err := os.Stat(changelogFile); err == nil {
+		paths[path.Join(path.Dir(configName), roci.ChangelogFileName)] = changelogFile
+	}
+
+	for name, p := range paths {
+		f, err := roci.RpmFileFromDisk(name, p)
+		if err != nil {
+			return err
+		}
+		if b.sourceDateEpoch != nil {
+			f.MTime = min(f.MTime, uint32(b.sourceDateEpoch.Unix()))
+		}
+		// rpm refuses to install source rpms without a spec file,
+		// the config file is the closest equivalent
+		if p == b.configFile {
+			f.Flags |= rpmpack.SpecFile
+		}
+		rpm.AddFile(f)
+	}
+	rpm.Contents = roci.FileContents(paths)
+
+	f, err := os.Create(filepath.Join(b.outputDir, SourceRpmName(m)))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := rpm.Write(f); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// Run builds all stages and writes the main package and all subpackages into
+// the output directory
+func (b *Build) Run() error {
+	if err := b.inspectBuildroot(); err != nil {
+		return err
+	}
+	if _, _, err := b.executeBuildRequires(); err != nil {
+		return err
+	}
+
+	if _, _, err := b.executeBuild(); err != nil {
+		return err
+	}
+
+	mainPkg, err := b.buildRpm(b.config.Name, b.config.RpmPackage, nil)
+	if err != nil {
+		return err
+	}
+	if err := b.buildSourceRpm(mainPkg); err != nil {
+		return err
+	}
+
+	// subpackages are stages named after their key in the package map,
+	// build them in a stable order
+	stages := slices.Sorted(maps.Keys(b.config.Package))
+	for _, stage := range stages {
+		if _, err := b.buildRpm(stage, b.config.Package[stage], mainPkg); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ExportSpec builds all stages and writes a spec file together with the payload
+// tarball of each package into the output directory, so that rpmbuild can
+// build the same packages
+func (b *Build) ExportSpec() error {
+	if err := b.inspectBuildroot(); err != nil {
+		return err
+	}
+	if _, _, err := b.executeBuildRequires(); err != nil {
+		return err
+	}
+	if _, _, err := b.executeBuild(); err != nil {
+		return err
+	}
+
+	mainRpm, err := b.assembleRpm(b.config.Name, b.config.RpmPackage, nil)
+	if err != nil {
+		return err
+	}
+	pkgs := []roci.SpecPackage{{Config: b.config.RpmPackage, Rpm: mainRpm}}
+	for _, stage := range slices.Sorted(maps.Keys(b.config.Package)) {
+		rpm, err := b.assembleRpm(stage, b.config.Package[stage], &mainRpm.RPMMetaData)
+		if err != nil {
+			return err
+		}
+		pkgs = append(pkgs, roci.SpecPackage{Config: b.config.Package[stage], Rpm: rpm})
+	}
+
+	for i := range pkgs {
+		pkgs[i].Payload = pkgs[i].Rpm.Name + "-payload.tar.gz"
+		f, err := os.Create(filepath.Join(b.outputDir, pkgs[i].Payload))
+		if err != nil {
+			return err
+		}
+		if err := pkgs[i].Rpm.WritePayloadTarball(f); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+	}
+
+	f, err := os.Create(filepath.Join(b.outputDir, b.config.Name+".spec"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := roci.ExportSpec(f, pkgs); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
 func buildCommand(ctx context.Context, cmd *cli.Command) error {
 	if cmd.NArg() < 1 {
 		return fmt.Errorf("dist-git directory path is required")
 	}
 
-	build, err := NewBuild(ctx, cmd)
+	storeOptions, err := storage.DefaultStoreOptions()
+	if err != nil {
+		return err
+	}
+	build, err := NewBuild(ctx, cmd, cmd.Args().First(), storeOptions, nil)
 	if err != nil {
 		return err
 	}
 
-	if _, _, err := build.executeBuildRequires(); err != nil {
+	if cmd.Bool("show-config") {
+		config, err := roci.MarshalConfig(&build.config)
+		if err != nil {
+			return err
+		}
+		_, err = os.Stdout.Write(config)
 		return err
 	}
 
-	if _, _, err := build.executeBuild(); err != nil {
+	return build.Run()
+}
+
+// lintCommand reports all problems in the config file of a dist-git directory
+func lintCommand(ctx context.Context, cmd *cli.Command) error {
+	if cmd.NArg() < 1 {
+		return fmt.Errorf("dist-git directory path is required")
+	}
+
+	yamlFileName, err := configFile(cmd.Args().First(), cmd.String("yaml-file"))
+	if err != nil {
 		return err
 	}
+	problems, err := roci.LintConfig(yamlFileName)
+	if err != nil {
+		return err
+	}
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("found %d problems in %s", len(problems), yamlFileName)
+	}
+	return nil
+}
 
-	// main package
-	if id, _, err := build.buildStage(build.config.Name, build.config.Name, false); err != nil {
+// schemaCommand prints the JSON schema of the config file
+func schemaCommand(ctx context.Context, cmd *cli.Command) error {
+	schema, err := roci.ConfigSchema()
+	if err != nil {
 		return err
-	} else {
-		rpm, err := build.RpmFromLayer(id, build.config.RpmPackage)
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(schema)
+}
+
+// exportSpecCommand writes a spec file and the payload tarballs from which
+// rpmbuild builds the same packages as roci
+func exportSpecCommand(ctx context.Context, cmd *cli.Command) error {
+	if cmd.NArg() < 1 {
+		return fmt.Errorf("dist-git directory path is required")
+	}
+
+	// resolve the output directory before anything else, see NewBuild
+	outputDir, err := filepath.Abs(cmd.String("output-dir"))
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(outputDir, 0o755); err != nil {
+		return err
+	}
+
+	storeOptions, err := storage.DefaultStoreOptions()
+	if err != nil {
+		return err
+	}
+	build, err := NewBuild(ctx, cmd, cmd.Args().First(), storeOptions, nil)
+	if err != nil {
+		return err
+	}
+	build.outputDir = outputDir
+
+	return build.ExportSpec()
+}
+
+// importSpecCommand converts a spec file into a config file and a
+// Containerfile and prints everything that has to be done by hand
+func importSpecCommand(ctx context.Context, cmd *cli.Command) error {
+	if cmd.NArg() < 1 {
+		return fmt.Errorf("spec file path is required")
+	}
+
+	f, err := os.Open(cmd.Args().First())
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	imp, err := roci.ImportSpec(f)
+	if err != nil {
+		return fmt.Errorf("%s: %w", cmd.Args().First(), err)
+	}
+	config, err := roci.MarshalConfig(imp.Config)
+	if err != nil {
+		return err
+	}
+
+	outputDir := cmd.String("output-dir")
+	if err := os.MkdirAll(outputDir, 0o755); err != nil {
+		return err
+	}
+	files := []struct {
+		name     string
+		contents []byte
+	}{
+		{imp.Name + ".yaml", config},
+		{"Containerfile", []byte(imp.Containerfile)},
+	}
+	// nothing must be overwritten, it may have been edited by hand
+	for _, f := range files {
+		if _, err := os.Stat(filepath.Join(outputDir, f.name)); err == nil {
+			return fmt.Errorf("%s already exists", filepath.Join(outputDir, f.name))
+		}
+	}
+	for _, f := range files {
+		path := filepath.Join(outputDir, f.name)
+		if err := os.WriteFile(path, f.contents, 0o644); err != nil {
+			return err
+		}
+		log.Printf("Wrote %s", path)
+	}
+
+	for _, r := range imp.Report {
+		fmt.Println(r)
+	}
+	return nil
+}
+
+// rebuildCommand unpacks a source rpm into a temporary directory and builds it
+// like a dist-git directory
+func rebuildCommand(ctx context.Context, cmd *cli.Command) error {
+	if cmd.NArg() < 1 {
+		return fmt.Errorf("source rpm path is required")
+	}
+
+	// resolve the output directory before anything else, see NewBuild
+	outputDir, err := filepath.Abs(cmd.String("output-dir"))
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(cmd.Args().First())
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	rdr, err := roci.NewRpmReader(bufio.NewReader(f))
+	if err != nil {
+		return err
+	}
+	defer rdr.Close()
+	if !rdr.Source {
+		return fmt.Errorf("%s is not a source rpm", cmd.Args().First())
+	}
+
+	dir, err := os.MkdirTemp("", "roci-rebuild-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	if err := rdr.Extract(dir); err != nil {
+		return err
+	}
+
+	// roci marks the config file as spec file
+	if !cmd.IsSet("yaml-file") {
+		flags, _ := rdr.Header.Get(roci.TagFileFlags)
+		for i, name := range rdr.FileNames() {
+			if i < len(flags.Uints()) && rpmpack.FileType(flags.Uints()[i])&rpmpack.SpecFile != 0 {
+				if err := cmd.Set("yaml-file", name); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	// the packages can only be reproduced with the same timestamp
+	buildTime, _ := rdr.Header.Get(roci.TagBuildTime)
+	if t := buildTime.Uints(); len(t) > 0 && !cmd.IsSet("source-date-epoch") {
+		if err := cmd.Set("source-date-epoch", strconv.FormatUint(t[0], 10)); err != nil {
+			return err
+		}
+	}
+
+	// the unpacked source rpm has no git history, the release and the
+	// changelog are taken from the source rpm instead
+	release, _ := rdr.Header.Get(roci.TagRelease)
+	var autoRelease *roci.AutoRelease
+	if n, err := strconv.Atoi(strings.SplitN(release.String(), ".", 2)[0]); err == nil {
+		autoRelease = &roci.AutoRelease{Release: n, Changelog: rdr.Changelog()}
+	}
+
+	storeOptions, err := storage.DefaultStoreOptions()
+	if err != nil {
+		return err
+	}
+	build, err := NewBuild(ctx, cmd, dir, storeOptions, autoRelease)
+	if err != nil {
+		return err
+	}
+	build.outputDir = outputDir
+
+	return build.Run()
+}
+
+// isolatedStoreOptions returns the options of a container storage in `dir`
+// that can use the images of the default storage, but does not modify it
+func isolatedStoreOptions(dir string) (storage.StoreOptions, error) {
+	opts, err := storage.DefaultStoreOptions()
+	if err != nil {
+		return storage.StoreOptions{}, err
+	}
+
+	// the default storage is added as additional image store, which is
+	// configured via the options of the graph driver
+	driver := opts.GraphDriverName
+	if driver == "" {
+		store, err := storage.GetStore(opts)
+		if err != nil {
+			return storage.StoreOptions{}, err
+		}
+		driver = store.GraphDriverName()
+		if _, err := store.Shutdown(false); err != nil {
+			return storage.StoreOptions{}, err
+		}
+	}
+
+	isolated := opts
+	isolated.GraphDriverName = driver
+	isolated.GraphRoot = filepath.Join(dir, "root")
+	isolated.RunRoot = filepath.Join(dir, "run")
+	isolated.ImageStore = ""
+	isolated.GraphDriverOptions = append(slices.Clone(opts.GraphDriverOptions), fmt.Sprintf("%s.imagestore=%s", driver, opts.GraphRoot))
+	return isolated, nil
+}
+
+// buildrootCreateCommand creates the buildroot image of the release from the
+// base image of its distribution profile. The rpm build tools are installed,
+// the compiler flags become the environment and the macros the labels of the
+// image.
+func buildrootCreateCommand(ctx context.Context, cmd *cli.Command) error {
+	distro, err := distroProfile(cmd)
+	if err != nil {
+		return err
+	}
+	if distro == nil {
+		return fmt.Errorf("--release is required")
+	}
+	if distro.BaseImage == "" {
+		return fmt.Errorf("the distribution profile %s has no BaseImage", distro.Name)
+	}
+	tag := cmp.Or(cmd.String("tag"), distro.Buildroot)
+
+	storeOptions, err := storage.DefaultStoreOptions()
+	if err != nil {
+		return err
+	}
+	store, err := storage.GetStore(storeOptions)
+	if err != nil {
+		return err
+	}
+	defer store.Shutdown(false)
+
+	builder, err := buildah.NewBuilder(ctx, store, buildah.BuilderOptions{
+		FromImage:    distro.BaseImage,
+		ReportWriter: os.Stderr,
+	})
+	if err != nil {
+		return err
+	}
+	defer builder.Delete()
+
+	runOptions := buildah.RunOptions{
+		Stdout:           os.Stdout,
+		Stderr:           os.Stderr,
+		Terminal:         buildah.WithoutTerminal,
+		ConfigureNetwork: define.NetworkEnabled,
+	}
+	// the build tools must be installed before the macros with the
+	// compiler flags can be evaluated
+	for _, setup := range distro.Setup {
+		if err := builder.Run([]string{"/bin/sh", "-c", setup}, runOptions); err != nil {
+			return fmt.Errorf("%s: %w", setup, err)
+		}
+	}
+
+	exprs := distro.BuildrootExpressions()
+	buff := bytes.Buffer{}
+	runOptions.Stdout = &buff
+	if err := builder.Run(roci.RpmEvalCommand(exprs), runOptions); err != nil {
+		return fmt.Errorf("failed to evaluate the macros: %w", err)
+	}
+	values, err := roci.ParseRpmEval(buff.String(), exprs)
+	if err != nil {
+		return err
+	}
+	for _, expr := range exprs {
+		if _, ok := values[expr]; !ok {
+			log.Printf("%s is undefined in the buildroot", expr)
+		}
+	}
+
+	env, labels := distro.BuildrootConfig(values)
+	for name, value := range env {
+		builder.SetEnv(name, value)
+	}
+	for name, value := range labels {
+		builder.SetLabel(name, value)
+	}
+
+	ref, err := imgStorage.Transport.ParseStoreReference(store, tag)
+	if err != nil {
+		return err
+	}
+	if _, _, _, err := builder.Commit(ctx, ref, buildah.CommitOptions{ReportWriter: os.Stderr}); err != nil {
+		return err
+	}
+	log.Printf("Created %s", tag)
+	return nil
+}
+
+// verifyReproducibleCommand builds the packages twice, each time in a fresh
+// container storage, and reports all differences between the rpms of both
+// builds
+func verifyReproducibleCommand(ctx context.Context, cmd *cli.Command) error {
+	if cmd.NArg() < 1 {
+		return fmt.Errorf("dist-git directory path is required")
+	}
+
+	var outputDirs [2]string
+	for i := range outputDirs {
+		dir, err := os.MkdirTemp("", "roci-verify-")
 		if err != nil {
 			return err
 		}
-		rpmPath := filepath.Join(build.distGit, build.config.Name+".rpm")
-		f, err := os.Create(rpmPath)
+		defer os.RemoveAll(dir)
+
+		storeOptions, err := isolatedStoreOptions(filepath.Join(dir, "storage"))
 		if err != nil {
 			return err
 		}
-		defer f.Close()
-		if err := rpm.Write(f); err != nil {
+		build, err := NewBuild(ctx, cmd, cmd.Args().First(), storeOptions, nil)
+		if err != nil {
 			return err
 		}
+		outputDirs[i] = filepath.Join(dir, "rpms")
+		if err := os.Mkdir(outputDirs[i], 0o755); err != nil {
+			return err
+		}
+		build.outputDir = outputDirs[i]
 
-	}
-
-	for _, v := range build.config.Package {
-		if _, _, err := build.buildStage(v.Name, v.Name, false); err != nil {
+		log.Printf("Build %d of 2", i+1)
+		err = build.Run()
+		// the storage must be released before it can be removed
+		if _, shutdownErr := build.store.Shutdown(true); err == nil {
+			err = shutdownErr
+		}
+		if err != nil {
 			return err
 		}
 	}
 
+	reproducible, err := compareRpms(outputDirs[0], outputDirs[1], os.Stdout)
+	if err != nil {
+		return err
+	}
+	if !reproducible {
+		return errors.New("the builds are not reproducible")
+	}
+	fmt.Println("The builds are reproducible")
 	return nil
 }
+
+// compareRpms compares the rpms in the directories `a` and `b`, writes all
+// differences into `out` and returns whether both directories have identical
+// rpms
+func compareRpms(a, b string, out io.Writer) (bool, error) {
+	rpmNames := func(dir string) ([]string, error) {
+		matches, err := filepath.Glob(filepath.Join(dir, "*.rpm"))
+		if err != nil {
+			return nil, err
+		}
+		for i, m := range matches {
+			matches[i] = filepath.Base(m)
+		}
+		return matches, nil
+	}
+	namesA, err := rpmNames(a)
+	if err != nil {
+		return false, err
+	}
+	namesB, err := rpmNames(b)
+	if err != nil {
+		return false, err
+	}
+
+	reproducible := true
+	for _, name := range namesA {
+		if !slices.Contains(namesB, name) {
+			fmt.Fprintf(out, "%s: only built by the first build\n", name)
+			reproducible = false
+		}
+	}
+	for _, name := range namesB {
+		if !slices.Contains(namesA, name) {
+			fmt.Fprintf(out, "%s: only built by the second build\n", name)
+			reproducible = false
+		}
+	}
+
+	for _, name := range namesA {
+		if !slices.Contains(namesB, name) {
+			continue
+		}
+		diffs, err := diffRpmFiles(filepath.Join(a, name), filepath.Join(b, name))
+		if err != nil {
+			return false, fmt.Errorf("failed to compare %s: %w", name, err)
+		}
+		for _, d := range diffs {
+			fmt.Fprintf(out, "%s: %s\n", name, d)
+		}
+		reproducible = reproducible && len(diffs) == 0
+	}
+
+	return reproducible, nil
+}
+
+// diffRpmFiles returns the differences of the rpm files `a` and `b`
+func diffRpmFiles(a, b string) ([]string, error) {
+	fa, err := os.Open(a)
+	if err != nil {
+		return nil, err
+	}
+	defer fa.Close()
+	fb, err := os.Open(b)
+	if err != nil {
+		return nil, err
+	}
+	defer fb.Close()
+
+	return roci.RpmDiff(bufio.NewReader(fa), bufio.NewReader(fb))
+}
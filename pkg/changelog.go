@@ -0,0 +1,77 @@
+package roci
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ChangelogFileName is the name of the file next to the config file that
+// contains the changelog, if it is not part of the config
+const ChangelogFileName = "changelog"
+
+// ParseChangelog parses `text` in the format of the %changelog section of a
+// spec file, i.e. entries like
+//
+//	Changelog: |
+//	  * Mon Mar 04 2024 Joe Packager <joe@example.com> - 1.1-2
+//	  - Add a summary
+//
+// The entries must be sorted from the newest to the oldest.
+func ParseChangelog(text string) ([]ChangelogEntry, error) {
+	var entries []ChangelogEntry
+	var lines []string
+	addText := func() {
+		if len(entries) > 0 {
+			entries[len(entries)-1].Text = strings.TrimSpace(strings.Join(lines, "\n"))
+		}
+		lines = nil
+	}
+
+	for i, line := range strings.Split(text, "\n") {
+		if !strings.HasPrefix(line, "*") {
+			if len(entries) == 0 && strings.TrimSpace(line) != "" {
+				return nil, fmt.Errorf("changelog line %d: expected an entry starting with '*'", i+1)
+			}
+			lines = append(lines, line)
+			continue
+		}
+
+		entry, err := parseChangelogHeading(line)
+		if err != nil {
+			return nil, fmt.Errorf("changelog line %d: %w", i+1, err)
+		}
+		if len(entries) > 0 && entry.Time.After(entries[len(entries)-1].Time) {
+			return nil, fmt.Errorf("changelog line %d: entries are not in descending chronological order", i+1)
+		}
+		addText()
+		entries = append(entries, entry)
+	}
+	addText()
+
+	return entries, nil
+}
+
+// parseChangelogHeading parses the date and the name of the line starting a
+// changelog entry, e.g. "* Mon Mar 04 2024 Joe Packager <joe@example.com> - 1.0-1"
+func parseChangelogHeading(line string) (ChangelogEntry, error) {
+	fields := strings.Fields(strings.TrimPrefix(line, "*"))
+	if len(fields) < 5 {
+		return ChangelogEntry{}, fmt.Errorf("expected a date and a name in %q", line)
+	}
+
+	date := strings.Join(fields[:4], " ")
+	t, err := time.Parse("Mon Jan 2 2006", date)
+	if err != nil {
+		return ChangelogEntry{}, fmt.Errorf("invalid date %q", date)
+	}
+	// time.Parse only checks that the weekday is valid, not that it matches
+	if t.Weekday().String()[:3] != fields[0] {
+		return ChangelogEntry{}, fmt.Errorf("invalid date %q: %s is a %s", date, t.Format("Jan 2 2006"), t.Weekday())
+	}
+
+	return ChangelogEntry{
+		Time: changelogDate(t),
+		Name: strings.Join(fields[4:], " "),
+	}, nil
+}
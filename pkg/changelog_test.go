@@ -0,0 +1,89 @@
+package roci
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseChangelog(t *testing.T) {
+	text := `* Mon Mar 04 2024 Joe Packager <joe@example.com> - 1.1-2
+- Add a summary
+- Fix the build
+
+* Sun Mar  3 2024 Jane Doe <jane@example.com> - 1.1-1
+- Update to 1.1
+* Fri Mar 01 2024 Joe Packager <joe@example.com> - 1.0-1
+- Initial import
+`
+	entries, err := ParseChangelog(text)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []ChangelogEntry{
+		{time.Date(2024, 3, 4, 12, 0, 0, 0, time.UTC), "Joe Packager <joe@example.com> - 1.1-2", "- Add a summary\n- Fix the build"},
+		{time.Date(2024, 3, 3, 12, 0, 0, 0, time.UTC), "Jane Doe <jane@example.com> - 1.1-1", "- Update to 1.1"},
+		{time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC), "Joe Packager <joe@example.com> - 1.0-1", "- Initial import"},
+	}
+	if len(entries) != len(expected) {
+		t.Fatalf("expected %d entries, got %+v", len(expected), entries)
+	}
+	for i, e := range expected {
+		if got := entries[i]; !got.Time.Equal(e.Time) || got.Name != e.Name || got.Text != e.Text {
+			t.Errorf("expected entry %+v, got %+v", e, got)
+		}
+	}
+}
+
+func TestParseChangelogErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		err  string
+	}{
+		{"text before the first entry", "- Initial import\n", "line 1: expected an entry"},
+		{"invalid date", "* Mon Foo 04 2024 Joe - 1-1\n", `line 1: invalid date "Mon Foo 04 2024"`},
+		{"wrong weekday", "* Tue Mar 04 2024 Joe - 1-1\n", "Mar 4 2024 is a Monday"},
+		{"missing name", "* Mon Mar 04 2024\n", "line 1: expected a date and a name"},
+		{"not sorted", "* Fri Mar 01 2024 Joe - 1-1\n- a\n* Mon Mar 04 2024 Joe - 1-2\n", "line 3: entries are not in descending chronological order"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseChangelog(tt.text)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("expected an error containing %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestLoadConfigChangelogFile(t *testing.T) {
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "foo.yaml")
+	changelog := "* Mon Mar 04 2024 Joe Packager <joe@example.com> - 1.0-1\n- Initial import\n"
+	if err := os.WriteFile(filepath.Join(dir, ChangelogFileName), []byte(changelog), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(configFile, []byte("Name: foo\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := LoadConfig(configFile)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Changelog != changelog {
+		t.Errorf("expected the changelog from the file, got %q", cfg.Changelog)
+	}
+
+	if err := os.WriteFile(configFile, []byte("Name: foo\nChangelog: |\n  * Mon Mar 04 2024 Joe - 1.0-1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadConfig(configFile); err == nil {
+		t.Error("expected an error for a changelog in the config and in the changelog file")
+	}
+}
@@ -0,0 +1,80 @@
+package roci
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// newPayloadCompressor returns a writer that compresses into `w` with the
+// compressor `setting`, which is the name of the compressor optionally
+// followed by a colon and the compression level, e.g. `zstd:19`.
+// The name of the compressor and the compression level are returned as well,
+// as they are recorded in the rpm header.
+func newPayloadCompressor(setting string, w io.Writer) (wc io.WriteCloser, name string, level string, err error) {
+	name, level, _ = strings.Cut(setting, ":")
+
+	var lvl int
+	if level != "" {
+		if lvl, err = strconv.Atoi(level); err != nil {
+			return nil, "", "", fmt.Errorf("invalid compression level %q: %w", level, err)
+		}
+	}
+
+	switch name {
+	case "", "gzip":
+		name = "gzip"
+		if level == "" {
+			lvl, level = gzip.BestCompression, "9"
+		}
+		wc, err = gzip.NewWriterLevel(w, lvl)
+	case "xz":
+		if level != "" {
+			return nil, "", "", fmt.Errorf("no compression level supported for xz: %s", level)
+		}
+		level = "6"
+		wc, err = xz.NewWriter(w)
+	case "zstd":
+		zstdLevel := zstd.SpeedBetterCompression
+		if level == "" {
+			level = "7"
+		} else {
+			zstdLevel = zstd.EncoderLevelFromZstd(lvl)
+		}
+		// a single goroutine makes the output independent of the number
+		// of CPUs of the build host
+		wc, err = zstd.NewWriter(w, zstd.WithEncoderLevel(zstdLevel), zstd.WithEncoderConcurrency(1))
+	default:
+		return nil, "", "", fmt.Errorf("unknown compressor: %s", name)
+	}
+
+	return wc, name, level, err
+}
+
+// newPayloadDecompressor returns a reader that decompresses `r` which was
+// compressed with `compressor` as recorded in the rpm header
+func newPayloadDecompressor(compressor string, r io.Reader) (io.ReadCloser, error) {
+	switch compressor {
+	case "gzip":
+		return gzip.NewReader(r)
+	case "xz":
+		rdr, err := xz.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(rdr), nil
+	case "zstd":
+		rdr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return rdr.IOReadCloser(), nil
+	default:
+		return nil, fmt.Errorf("unknown compressor: %s", compressor)
+	}
+}
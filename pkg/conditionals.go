@@ -0,0 +1,96 @@
+package roci
+
+import (
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// conditionalName are the characters of the names of conditionals, they must
+// be usable in variable names and build arguments
+var conditionalName = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// ConditionalBuildArg returns the name of the build argument of the
+// conditional `name`, e.g. WITH_DOCS for docs. It is "1" if the conditional is
+// enabled and "0" otherwise.
+func ConditionalBuildArg(name string) string {
+	return "WITH_" + strings.ToUpper(name)
+}
+
+// ApplyConditionals enables the conditionals `with` and disables the
+// conditionals `without`, like `--with` and `--without` of rpmbuild. All others
+// keep their default from Conditionals.
+//
+// Like %bcond in a spec file, the variable with_<name> is defined to 1 if the
+// conditional is enabled and without_<name> if it is not, so that e.g.
+// `%{?with_docs:pandoc}` expands to pandoc only if docs is enabled.
+// Subpackages whose Condition is not met are removed.
+//
+// It returns whether each conditional is enabled.
+func (c *Config) ApplyConditionals(with, without []string) (map[string]bool, error) {
+	buildArgs := make(map[string]string)
+	for _, name := range slices.Sorted(maps.Keys(c.Conditionals)) {
+		if !conditionalName.MatchString(name) {
+			return nil, fmt.Errorf("Conditionals: invalid name %q, only letters, digits and '_' are allowed", name)
+		}
+		// the build arguments are upper case
+		arg := ConditionalBuildArg(name)
+		if other, ok := buildArgs[arg]; ok {
+			return nil, fmt.Errorf("Conditionals: %s and %s would both be the build argument %s", other, name, arg)
+		}
+		buildArgs[arg] = name
+	}
+
+	enabled := maps.Clone(c.Conditionals)
+	if enabled == nil {
+		enabled = make(map[string]bool)
+	}
+	for _, name := range with {
+		if slices.Contains(without, name) {
+			return nil, fmt.Errorf("%s is both enabled and disabled", name)
+		}
+	}
+	for value, names := range map[bool][]string{true: with, false: without} {
+		for _, name := range names {
+			if _, ok := c.Conditionals[name]; !ok {
+				return nil, fmt.Errorf("unknown conditional %s, it must be declared in Conditionals", name)
+			}
+			enabled[name] = value
+		}
+	}
+
+	for name, on := range enabled {
+		variable := "with_" + name
+		if !on {
+			variable = "without_" + name
+		}
+		if _, ok := c.Variables[variable]; ok {
+			return nil, fmt.Errorf("Variables: %s is defined by the conditional %s", variable, name)
+		}
+		if c.Variables == nil {
+			c.Variables = make(map[string]string)
+		}
+		c.Variables[variable] = "1"
+	}
+
+	if c.Condition != "" {
+		return nil, fmt.Errorf("Condition: only subpackages can have a condition")
+	}
+	for key, pkg := range c.Package {
+		if pkg.Condition == "" {
+			continue
+		}
+		name, negated := strings.CutPrefix(pkg.Condition, "!")
+		on, ok := enabled[name]
+		if !ok {
+			return nil, fmt.Errorf("package.%s.Condition: unknown conditional %s", key, name)
+		}
+		if on == negated {
+			delete(c.Package, key)
+		}
+	}
+
+	return enabled, nil
+}
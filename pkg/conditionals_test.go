@@ -0,0 +1,114 @@
+package roci
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+const conditionalsConfig = `
+Name: poke
+Version: 4.3
+Conditionals:
+  docs: true
+  tests: false
+Requires:
+  - glibc
+  - "%{?with_docs:poke-doc = $VERSION}"
+  - "%{?without_tests:poke-untested}"
+package:
+  poke-doc:
+    Condition: docs
+  poke-nodoc:
+    Condition: "!docs"
+  poke-devel: {}
+`
+
+func TestApplyConditionals(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		with, without []string
+		enabled       map[string]bool
+		requires      []string
+		packages      []string
+	}{
+		{
+			name:     "defaults",
+			enabled:  map[string]bool{"docs": true, "tests": false},
+			requires: []string{"glibc", "poke-doc = 4.3", "poke-untested"},
+			packages: []string{"poke-devel", "poke-doc"},
+		},
+		{
+			name:     "overridden",
+			with:     []string{"tests"},
+			without:  []string{"docs"},
+			enabled:  map[string]bool{"docs": false, "tests": true},
+			requires: []string{"glibc"},
+			packages: []string{"poke-devel", "poke-nodoc"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var cfg Config
+			if err := yaml.Unmarshal([]byte(conditionalsConfig), &cfg); err != nil {
+				t.Fatal(err)
+			}
+			enabled, err := cfg.ApplyConditionals(tc.with, tc.without)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := cfg.Expand(nil); err != nil {
+				t.Fatal(err)
+			}
+
+			for name, on := range tc.enabled {
+				if enabled[name] != on {
+					t.Errorf("expected %s to be %v", name, on)
+				}
+			}
+			if !slices.Equal(cfg.Requires, tc.requires) {
+				t.Errorf("expected the Requires %v, got %v", tc.requires, cfg.Requires)
+			}
+			var packages []string
+			for key := range cfg.Package {
+				packages = append(packages, key)
+			}
+			slices.Sort(packages)
+			if !slices.Equal(packages, tc.packages) {
+				t.Errorf("expected the packages %v, got %v", tc.packages, packages)
+			}
+		})
+	}
+}
+
+func TestApplyConditionalsErrors(t *testing.T) {
+	for _, tc := range []struct {
+		config        string
+		with, without []string
+		err           string
+	}{
+		{"Conditionals: {docs: true}", []string{"foo"}, nil, "unknown conditional foo"},
+		{"Conditionals: {docs: true}", []string{"docs"}, []string{"docs"}, "docs is both enabled and disabled"},
+		{"Conditionals: {doc-s: true}", nil, nil, `invalid name "doc-s"`},
+		{"Conditionals: {docs: true, DOCS: false}", nil, nil, "DOCS and docs would both be the build argument WITH_DOCS"},
+		{"Conditionals: {docs: true}\nVariables: {with_docs: x}", nil, nil, "with_docs is defined by the conditional docs"},
+		{"Conditionals: {docs: true}\nCondition: docs", nil, nil, "only subpackages can have a condition"},
+		{"package: {foo: {Condition: docs}}", nil, nil, "package.foo.Condition: unknown conditional docs"},
+	} {
+		var cfg Config
+		if err := yaml.Unmarshal([]byte(tc.config), &cfg); err != nil {
+			t.Fatal(err)
+		}
+		_, err := cfg.ApplyConditionals(tc.with, tc.without)
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%q: expected the error %q, got %v", tc.config, tc.err, err)
+		}
+	}
+}
+
+func TestConditionalBuildArg(t *testing.T) {
+	if arg := ConditionalBuildArg("docs"); arg != "WITH_DOCS" {
+		t.Errorf("unexpected build argument %s", arg)
+	}
+}
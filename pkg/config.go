@@ -1,7 +1,13 @@
 package roci
 
 import (
+	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
 
 	"gopkg.in/yaml.v3"
 )
@@ -50,16 +56,20 @@ type RpmPreamble struct {
 	// AutoProv            string   `yaml:"AutoProv"`
 
 	// Requires dependencies:
-	RequiresPre       []string `yaml:"Requires(pre)"`
-	RequiresPost      []string `yaml:"Requires(post)"`
-	RequiresPreUn     []string `yaml:"Requires(preun)"`
-	RequiresPostUn    []string `yaml:"Requires(postun)"`
-	RequiresPreTrans  []string `yaml:"Requires(pretrans)"`
-	RequiresPostTrans []string `yaml:"Requires(posttrans)"`
-	RequiresVerify    []string `yaml:"Requires(verify)"`
-	RequiresInterp    []string `yaml:"Requires(interp)"`
-	RequiresMeta      []string `yaml:"Requires(meta)"`
-	Requires          []string `yaml:"Requires"`
+	RequiresPre         []string `yaml:"Requires(pre)"`
+	RequiresPost        []string `yaml:"Requires(post)"`
+	RequiresPreUn       []string `yaml:"Requires(preun)"`
+	RequiresPostUn      []string `yaml:"Requires(postun)"`
+	RequiresPreTrans    []string `yaml:"Requires(pretrans)"`
+	RequiresPostTrans   []string `yaml:"Requires(posttrans)"`
+	RequiresPreUnTrans  []string `yaml:"Requires(preuntrans)"`
+	RequiresPostUnTrans []string `yaml:"Requires(postuntrans)"`
+	RequiresVerify      []string `yaml:"Requires(verify)"`
+	RequiresInterp      []string `yaml:"Requires(interp)"`
+	RequiresMeta        []string `yaml:"Requires(meta)"`
+	Requires            []string `yaml:"Requires"`
+	// Requires with several qualifiers, e.g. Requires(post,postun)
+	RequiresCombined CombinedRequires `yaml:",inline"`
 
 	// remaining dependencies
 	Provides          []string `yaml:"Provides"`
@@ -91,23 +101,116 @@ type RpmPreamble struct {
 	// RemovePathPostfixes string   `yaml:"RemovePathPostfixes"`
 }
 
-// type RpmScriptlet struct {
-// 	Options []string `yaml:"Options"`
-// 	Name    string   `yaml:"Name"`
-// }
+// CombinedRequires collects the dependencies with multiple qualifiers, like
+// `Requires(post,postun)`, which cannot be expressed as struct fields.
+type CombinedRequires struct {
+	// Requires maps the qualifiers (e.g. "post,postun") to the
+	// dependencies
+	Requires map[string][]string `yaml:"-"`
+}
+
+var combinedRequiresKey = regexp.MustCompile(`^Requires\(([^()]+,[^()]+)\)$`)
+
+// UnmarshalYAML implements yaml.Unmarshaler. It is invoked with the whole
+// mapping of the package and picks all `Requires(…)` keys with more than one
+// qualifier.
+func (c *CombinedRequires) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		m := combinedRequiresKey.FindStringSubmatch(node.Content[i].Value)
+		if m == nil {
+			continue
+		}
+
+		var deps []string
+		if err := node.Content[i+1].Decode(&deps); err != nil {
+			return err
+		}
+		if c.Requires == nil {
+			c.Requires = make(map[string][]string)
+		}
+		c.Requires[m[1]] = append(c.Requires[m[1]], deps...)
+	}
+	return nil
+}
+
+// RpmScriptlet is a scriptlet of a package, e.g. `%post`.
+//
+// It can be written either as a plain string, which is then executed by
+// /bin/sh, or as a mapping with the scriptlet options (like in the spec) and
+// the script:
+//
+//	Postin:
+//	  Options: ["-p", "<lua>"]
+//	  Script: |
+//	    print("hello")
+type RpmScriptlet struct {
+	Options []string `yaml:"Options"`
+	Script  string   `yaml:"Script"`
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler so that scriptlets can be written
+// as plain strings
+func (s *RpmScriptlet) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		s.Options = nil
+		return node.Decode(&s.Script)
+	}
+
+	// plain alias to not recurse into this function again
+	type rpmScriptlet RpmScriptlet
+	return node.Decode((*rpmScriptlet)(s))
+}
+
+// IsEmpty returns true if neither a script nor an interpreter are set
+func (s RpmScriptlet) IsEmpty() bool {
+	return s.Script == "" && len(s.Options) == 0
+}
+
+// Interpreter returns the program and its arguments that execute the
+// scriptlet. It is /bin/sh unless it is overridden via `-p <program>` in
+// Options, where program may contain arguments separated by whitespace.
+func (s RpmScriptlet) Interpreter() ([]string, error) {
+	prog := []string{"/bin/sh"}
+
+	for i := 0; i < len(s.Options); i++ {
+		switch opt := s.Options[i]; opt {
+		case "-p":
+			if i+1 == len(s.Options) {
+				return nil, errors.New("scriptlet option -p requires a program")
+			}
+			i++
+			prog = strings.Fields(s.Options[i])
+			if len(prog) == 0 {
+				return nil, errors.New("scriptlet option -p requires a program")
+			}
+		default:
+			return nil, fmt.Errorf("unsupported scriptlet option %q", opt)
+		}
+	}
+
+	return prog, nil
+}
 
 type RpmPackage struct {
 	RpmPreamble `yaml:",inline"`
 
 	Description string `yaml:"Description"`
 
-	Postin       string `yaml:"Postin"`
-	Posttrans    string `yaml:"Posttrans"`
-	Postun       string `yaml:"Postun"`
-	Prein        string `yaml:"Prein"`
-	Pretrans     string `yaml:"Pretrans"`
-	Preun        string `yaml:"Preun"`
-	VerifyScript string `yaml:"VerifyScript"`
+	// Condition of a subpackage is the name of a conditional that must be
+	// enabled for it to be built, or disabled if it starts with '!'
+	Condition string `yaml:"Condition"`
+
+	Postin       RpmScriptlet `yaml:"Postin"`
+	Posttrans    RpmScriptlet `yaml:"Posttrans"`
+	Postun       RpmScriptlet `yaml:"Postun"`
+	Prein        RpmScriptlet `yaml:"Prein"`
+	Pretrans     RpmScriptlet `yaml:"Pretrans"`
+	Preun        RpmScriptlet `yaml:"Preun"`
+	VerifyScript RpmScriptlet `yaml:"VerifyScript"`
 }
 
 // Config represents the roci configuration file
@@ -115,9 +218,28 @@ type Config struct {
 	RpmPackage `yaml:",inline"`
 
 	Package map[string]RpmPackage `yaml:"package"`
+
+	// Variables are user defined variables for the expansion of the
+	// config, see Config.Expand
+	Variables map[string]string `yaml:"Variables"`
+
+	// Overrides are merged over the config for the releases that match
+	// their key, see Config.ApplyOverrides
+	Overrides map[string]ConfigOverride `yaml:"Overrides"`
+
+	// Conditionals are the names of the build conditionals and whether
+	// they are enabled by default, see Config.ApplyConditionals
+	Conditionals map[string]bool `yaml:"Conditionals"`
+
+	// Changelog of all packages in the format of the spec's %changelog,
+	// see ParseChangelog
+	Changelog string `yaml:"Changelog"`
 }
 
-// LoadConfig reads and parses the YAML configuration file
+// LoadConfig reads and parses the YAML configuration file. The changelog is
+// read from the changelog file next to it, unless it is part of the config.
+// The config is not complete yet, the overrides of the release must be applied
+// and then the preamble must be inherited.
 func LoadConfig(path string) (*Config, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
@@ -129,5 +251,74 @@ func LoadConfig(path string) (*Config, error) {
 		return nil, err
 	}
 
+	changelogFile := filepath.Join(filepath.Dir(path), ChangelogFileName)
+	changelog, err := os.ReadFile(changelogFile)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return nil, err
+	case cfg.Changelog != "":
+		return nil, fmt.Errorf("the changelog must either be in %s or in %s, not in both", path, changelogFile)
+	default:
+		cfg.Changelog = string(changelog)
+	}
+
 	return &cfg, nil
 }
+
+// InheritPreamble completes the subpackages with the preamble of the main
+// package. Subpackages inherit every field that they do not set themselves,
+// except for the name, the summary, the dependencies and SourceLicense, as
+// they only make sense for the main package.
+//
+// The name of a subpackage defaults to its key in Package and a name starting
+// with '-' is appended to the main package's name, like `%package devel` in a
+// spec file.
+func (c *Config) InheritPreamble() {
+	for key, pkg := range c.Package {
+		if pkg.Name == "" {
+			pkg.Name = key
+		}
+		if strings.HasPrefix(pkg.Name, "-") {
+			pkg.Name = c.Name + pkg.Name
+		}
+
+		inherit(&pkg.Version, c.Version)
+		inherit(&pkg.Release, c.Release)
+		inherit(&pkg.Epoch, c.Epoch)
+		inherit(&pkg.License, c.License)
+		inherit(&pkg.Group, c.Group)
+		inherit(&pkg.Icon, c.Icon)
+		inherit(&pkg.URL, c.URL)
+		inherit(&pkg.BugURL, c.BugURL)
+		inherit(&pkg.DistTag, c.DistTag)
+		inherit(&pkg.VCS, c.VCS)
+		inherit(&pkg.Distribution, c.Distribution)
+		inherit(&pkg.Vendor, c.Vendor)
+		inherit(&pkg.Packager, c.Packager)
+		inherit(&pkg.BuildArch, c.BuildArch)
+		inherit(&pkg.DocDir, c.DocDir)
+		inheritSlice(&pkg.ExcludeArch, c.ExcludeArch)
+		inheritSlice(&pkg.ExclusiveArch, c.ExclusiveArch)
+		inheritSlice(&pkg.ExcludeOS, c.ExcludeOS)
+		inheritSlice(&pkg.ExclusiveOS, c.ExclusiveOS)
+		inheritSlice(&pkg.Prefixes, c.Prefixes)
+
+		c.Package[key] = pkg
+	}
+}
+
+// inherit sets `field` to `value` if it is unset
+func inherit[T comparable](field *T, value T) {
+	var zero T
+	if *field == zero {
+		*field = value
+	}
+}
+
+// inheritSlice sets `field` to a copy of `value` if it is empty
+func inheritSlice(field *[]string, value []string) {
+	if len(*field) == 0 {
+		*field = slices.Clone(value)
+	}
+}
@@ -0,0 +1,180 @@
+package roci
+
+import (
+	"slices"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestRpmScriptletUnmarshal(t *testing.T) {
+	input := `
+Name: foo
+Preun: "/usr/sbin/alternatives --remove foo /usr/bin/foo || :"
+Postin:
+  Options: ["-p", "<lua>"]
+  Script: |
+    print("hello")
+Postun:
+  Options: ["-p", "/sbin/ldconfig"]
+`
+
+	var cfg Config
+	if err := yaml.Unmarshal([]byte(input), &cfg); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if cfg.Preun.Script != "/usr/sbin/alternatives --remove foo /usr/bin/foo || :" {
+		t.Errorf("unexpected Preun script: %q", cfg.Preun.Script)
+	}
+	if cfg.Preun.Options != nil {
+		t.Errorf("expected no Preun options, got %v", cfg.Preun.Options)
+	}
+
+	if cfg.Postin.Script != "print(\"hello\")\n" {
+		t.Errorf("unexpected Postin script: %q", cfg.Postin.Script)
+	}
+	if !slices.Equal(cfg.Postin.Options, []string{"-p", "<lua>"}) {
+		t.Errorf("unexpected Postin options: %v", cfg.Postin.Options)
+	}
+
+	if cfg.Postun.Script != "" || cfg.Postun.IsEmpty() {
+		t.Errorf("expected Postun without a script, got %+v", cfg.Postun)
+	}
+	if !cfg.Prein.IsEmpty() {
+		t.Errorf("expected empty Prein, got %+v", cfg.Prein)
+	}
+}
+
+func TestRpmScriptletInterpreter(t *testing.T) {
+	tests := []struct {
+		name     string
+		options  []string
+		expected []string
+		wantErr  bool
+	}{
+		{"default", nil, []string{"/bin/sh"}, false},
+		{"lua", []string{"-p", "<lua>"}, []string{"<lua>"}, false},
+		{"with arguments", []string{"-p", "/bin/bash -e"}, []string{"/bin/bash", "-e"}, false},
+		{"missing program", []string{"-p"}, nil, true},
+		{"empty program", []string{"-p", " "}, nil, true},
+		{"unknown option", []string{"-q"}, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prog, err := RpmScriptlet{Options: tt.options}.Interpreter()
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("expected an error, got %v", prog)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !slices.Equal(prog, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, prog)
+			}
+		})
+	}
+}
+
+func TestCombinedRequiresUnmarshal(t *testing.T) {
+	input := `
+Name: foo
+Requires(post): ["coreutils"]
+Requires(preuntrans): ["systemd"]
+Requires(postuntrans): ["dnf"]
+Requires(post,postun): ["/sbin/ldconfig"]
+Requires(pre, preun):
+  - shadow-utils
+  - systemd
+package:
+  foo-devel:
+    Requires(post,postun): ["bar"]
+`
+
+	var cfg Config
+	if err := yaml.Unmarshal([]byte(input), &cfg); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !slices.Equal(cfg.RequiresPost, []string{"coreutils"}) {
+		t.Errorf("unexpected Requires(post): %v", cfg.RequiresPost)
+	}
+	if !slices.Equal(cfg.RequiresPreUnTrans, []string{"systemd"}) || !slices.Equal(cfg.RequiresPostUnTrans, []string{"dnf"}) {
+		t.Errorf("unexpected Requires(preuntrans) and Requires(postuntrans): %v, %v", cfg.RequiresPreUnTrans, cfg.RequiresPostUnTrans)
+	}
+
+	combined := cfg.RequiresCombined.Requires
+	if len(combined) != 2 {
+		t.Fatalf("expected 2 combined requires, got %v", combined)
+	}
+	if !slices.Equal(combined["post,postun"], []string{"/sbin/ldconfig"}) {
+		t.Errorf("unexpected Requires(post,postun): %v", combined["post,postun"])
+	}
+	if !slices.Equal(combined["pre, preun"], []string{"shadow-utils", "systemd"}) {
+		t.Errorf("unexpected Requires(pre, preun): %v", combined["pre, preun"])
+	}
+
+	devel := cfg.Package["foo-devel"].RequiresCombined.Requires
+	if !slices.Equal(devel["post,postun"], []string{"bar"}) {
+		t.Errorf("unexpected subpackage Requires(post,postun): %v", devel)
+	}
+}
+
+func TestInheritPreamble(t *testing.T) {
+	input := `
+Name: poke
+Version: 4.3
+License: GPL-3.0-or-later
+SourceLicense: GPL-3.0-or-later AND MIT
+URL: https://www.jemarch.net/poke
+Vendor: Poke Inc
+Summary: Extensible editor for structured binary data
+Requires: [poke-libs]
+ExclusiveArch: [x86_64]
+package:
+  poke-devel:
+    Name: -devel
+    Summary: Development files for poke
+  poke-data:
+    License: GFDL-1.3-no-invariants-or-later
+  poke-doc:
+    Name: poke-documentation
+    ExclusiveArch: [noarch]
+`
+	var cfg Config
+	if err := yaml.Unmarshal([]byte(input), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	cfg.InheritPreamble()
+
+	devel := cfg.Package["poke-devel"]
+	if devel.Name != "poke-devel" {
+		t.Errorf("expected the shorthand name to be expanded, got %q", devel.Name)
+	}
+	if devel.Version != "4.3" || devel.License != "GPL-3.0-or-later" || devel.URL != "https://www.jemarch.net/poke" || devel.Vendor != "Poke Inc" {
+		t.Errorf("expected the preamble to be inherited, got %+v", devel.RpmPreamble)
+	}
+	if devel.Summary != "Development files for poke" || devel.SourceLicense != "" || devel.Requires != nil {
+		t.Errorf("expected summary, source license and dependencies not to be inherited, got %+v", devel.RpmPreamble)
+	}
+
+	data := cfg.Package["poke-data"]
+	if data.Name != "poke-data" {
+		t.Errorf("expected the key as name, got %q", data.Name)
+	}
+	if data.License != "GFDL-1.3-no-invariants-or-later" {
+		t.Errorf("expected the license to be overridden, got %q", data.License)
+	}
+
+	doc := cfg.Package["poke-doc"]
+	if doc.Name != "poke-documentation" || !slices.Equal(doc.ExclusiveArch, []string{"noarch"}) {
+		t.Errorf("unexpected subpackage %q with ExclusiveArch %v", doc.Name, doc.ExclusiveArch)
+	}
+	if data.ExclusiveArch[0] = "aarch64"; cfg.ExclusiveArch[0] != "x86_64" {
+		t.Error("expected the inherited slices to be copies")
+	}
+}
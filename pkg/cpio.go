@@ -0,0 +1,267 @@
+package roci
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+)
+
+const (
+	cpioNewcMagic = "070701"
+	// rpm's stripped format only stores the index of the file in the rpm
+	// header, it is used for packages with files larger than 4GiB
+	cpioStrippedMagic = "07070X"
+	cpioTrailer       = "TRAILER!!!"
+	// size of the newc header without the name
+	cpioHeaderSize = 110
+	// size of the stripped header: magic and file index
+	cpioStrippedHeaderSize = 14
+)
+
+// CpioHeader is the header of an entry in a SVR4 (newc) cpio archive
+type CpioHeader struct {
+	Name      string
+	Inode     uint32
+	Mode      uint32
+	Uid       uint32
+	Gid       uint32
+	Nlink     uint32
+	MTime     uint32
+	Size      int64
+	RDevMajor uint32
+	RDevMinor uint32
+}
+
+// cpioWriter writes a SVR4 (newc) cpio archive
+type cpioWriter struct {
+	w io.Writer
+	// bytes written so far, needed for the padding
+	written int64
+	// bytes remaining of the current entry
+	remaining int64
+}
+
+func newCpioWriter(w io.Writer) *cpioWriter {
+	return &cpioWriter{w: w}
+}
+
+func (c *cpioWriter) write(b []byte) error {
+	n, err := c.w.Write(b)
+	c.written += int64(n)
+	return err
+}
+
+func (c *cpioWriter) pad() error {
+	return c.write(make([]byte, (4-c.written%4)%4))
+}
+
+// WriteHeader finishes the previous entry and writes the header `hdr`
+func (c *cpioWriter) WriteHeader(hdr *CpioHeader) error {
+	if c.remaining != 0 {
+		return fmt.Errorf("cpio: %d bytes missing from the previous entry", c.remaining)
+	}
+	if hdr.Size > math.MaxUint32 {
+		return fmt.Errorf("cpio: %s is too large for the newc format", hdr.Name)
+	}
+	if err := c.pad(); err != nil {
+		return err
+	}
+
+	fields := []uint32{
+		hdr.Inode, hdr.Mode, hdr.Uid, hdr.Gid, hdr.Nlink, hdr.MTime, uint32(hdr.Size),
+		// device of the archived file
+		0, 0,
+		hdr.RDevMajor, hdr.RDevMinor,
+		uint32(len(hdr.Name) + 1),
+		// checksum, unused in the newc format
+		0,
+	}
+	var b bytes.Buffer
+	b.WriteString(cpioNewcMagic)
+	for _, f := range fields {
+		fmt.Fprintf(&b, "%08x", f)
+	}
+	b.WriteString(hdr.Name)
+	b.WriteByte(0)
+	if err := c.write(b.Bytes()); err != nil {
+		return err
+	}
+	if err := c.pad(); err != nil {
+		return err
+	}
+
+	c.remaining = hdr.Size
+	return nil
+}
+
+// WriteStrippedHeader finishes the previous entry and writes the header of
+// the file with the index `fx` in the rpm header in rpm's stripped format.
+// Like rpm, the header is padded to 4 bytes before the contents.
+func (c *cpioWriter) WriteStrippedHeader(fx uint32, size int64) error {
+	if c.remaining != 0 {
+		return fmt.Errorf("cpio: %d bytes missing from the previous entry", c.remaining)
+	}
+	if err := c.pad(); err != nil {
+		return err
+	}
+	if err := c.write(fmt.Appendf([]byte(cpioStrippedMagic), "%08x", fx)); err != nil {
+		return err
+	}
+	if err := c.pad(); err != nil {
+		return err
+	}
+
+	c.remaining = size
+	return nil
+}
+
+// Write writes the contents of the current entry
+func (c *cpioWriter) Write(b []byte) (int, error) {
+	if int64(len(b)) > c.remaining {
+		return 0, errors.New("cpio: write exceeds the size of the entry")
+	}
+	n, err := c.w.Write(b)
+	c.written += int64(n)
+	c.remaining -= int64(n)
+	return n, err
+}
+
+// Close writes the trailer of the archive
+func (c *cpioWriter) Close() error {
+	if err := c.WriteHeader(&CpioHeader{Name: cpioTrailer, Nlink: 1}); err != nil {
+		return err
+	}
+	return c.pad()
+}
+
+// CpioReader reads a SVR4 (newc) cpio archive
+type CpioReader struct {
+	// stripped resolves the index of a file in a stripped archive to its
+	// header, archives in the stripped format cannot be read without it
+	stripped func(fx uint32) (*CpioHeader, error)
+
+	r io.Reader
+	// bytes read so far, needed for the padding
+	read int64
+	// bytes remaining of the current entry
+	remaining int64
+}
+
+// NewCpioReader creates a reader of the cpio archive `r`
+func NewCpioReader(r io.Reader) *CpioReader {
+	return &CpioReader{r: r}
+}
+
+func (c *CpioReader) skip(n int64) error {
+	skipped, err := io.CopyN(io.Discard, c.r, n)
+	c.read += skipped
+	return err
+}
+
+// Next skips the rest of the current entry and returns the header of the next
+// one. It returns io.EOF once the trailer is reached.
+func (c *CpioReader) Next() (*CpioHeader, error) {
+	if err := c.skip(c.remaining); err != nil {
+		return nil, err
+	}
+	c.remaining = 0
+	if err := c.skip((4 - c.read%4) % 4); err != nil {
+		return nil, err
+	}
+
+	// both formats start with the magic and the stripped header is the
+	// shorter one
+	raw := make([]byte, cpioHeaderSize)
+	if _, err := io.ReadFull(c.r, raw[:cpioStrippedHeaderSize]); err != nil {
+		return nil, fmt.Errorf("cpio: failed to read header: %w", err)
+	}
+	c.read += cpioStrippedHeaderSize
+	if string(raw[:6]) == cpioStrippedMagic {
+		return c.nextStripped(raw[:cpioStrippedHeaderSize])
+	}
+	if string(raw[:6]) != cpioNewcMagic {
+		return nil, fmt.Errorf("cpio: invalid magic %q", raw[:6])
+	}
+	if _, err := io.ReadFull(c.r, raw[cpioStrippedHeaderSize:]); err != nil {
+		return nil, fmt.Errorf("cpio: failed to read header: %w", err)
+	}
+	c.read += cpioHeaderSize - cpioStrippedHeaderSize
+
+	fields := make([]uint32, 13)
+	for i := range fields {
+		v, err := strconv.ParseUint(string(raw[6+8*i:14+8*i]), 16, 32)
+		if err != nil {
+			return nil, fmt.Errorf("cpio: invalid header: %w", err)
+		}
+		fields[i] = uint32(v)
+	}
+
+	name := make([]byte, fields[11])
+	if _, err := io.ReadFull(c.r, name); err != nil {
+		return nil, fmt.Errorf("cpio: failed to read name: %w", err)
+	}
+	c.read += int64(len(name))
+	if err := c.skip((4 - c.read%4) % 4); err != nil {
+		return nil, err
+	}
+
+	hdr := &CpioHeader{
+		Name:      string(bytes.TrimRight(name, "\x00")),
+		Inode:     fields[0],
+		Mode:      fields[1],
+		Uid:       fields[2],
+		Gid:       fields[3],
+		Nlink:     fields[4],
+		MTime:     fields[5],
+		Size:      int64(fields[6]),
+		RDevMajor: fields[9],
+		RDevMinor: fields[10],
+	}
+	if hdr.Name == cpioTrailer {
+		return nil, io.EOF
+	}
+
+	c.remaining = hdr.Size
+	return hdr, nil
+}
+
+// nextStripped returns the header of the stripped entry with the header `raw`
+func (c *CpioReader) nextStripped(raw []byte) (*CpioHeader, error) {
+	if c.stripped == nil {
+		return nil, errors.New("cpio: stripped archives can only be read as part of a rpm")
+	}
+	fx, err := strconv.ParseUint(string(raw[6:]), 16, 32)
+	if err != nil {
+		return nil, fmt.Errorf("cpio: invalid header: %w", err)
+	}
+	if err := c.skip((4 - c.read%4) % 4); err != nil {
+		return nil, err
+	}
+	hdr, err := c.stripped(uint32(fx))
+	if err != nil {
+		return nil, err
+	}
+
+	c.remaining = hdr.Size
+	return hdr, nil
+}
+
+// Read reads the contents of the current entry
+func (c *CpioReader) Read(b []byte) (int, error) {
+	if c.remaining == 0 {
+		return 0, io.EOF
+	}
+	if int64(len(b)) > c.remaining {
+		b = b[:c.remaining]
+	}
+	n, err := c.r.Read(b)
+	c.read += int64(n)
+	c.remaining -= int64(n)
+	if err == io.EOF && c.remaining > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
@@ -0,0 +1,129 @@
+package roci
+
+import (
+	"maps"
+	"slices"
+
+	"github.com/google/rpmpack"
+)
+
+// RpmDependencies are all dependency relations of a package.
+//
+// In contrast to rpmpack.RPMMetaData it also contains the relations for which
+// rpmpack has no fields.
+type RpmDependencies struct {
+	Provides,
+	Requires,
+	Conflicts,
+	Obsoletes,
+	Recommends,
+	Suggests,
+	Supplements,
+	Enhances,
+	OrderWithRequires rpmpack.Relations
+}
+
+// Append adds all relations from `other` that are not yet present
+func (d *RpmDependencies) Append(other RpmDependencies) {
+	appendMissing := func(rels *rpmpack.Relations, others rpmpack.Relations) {
+		for _, o := range others {
+			if !slices.ContainsFunc(*rels, o.Equal) {
+				*rels = append(*rels, o)
+			}
+		}
+	}
+
+	appendMissing(&d.Provides, other.Provides)
+	appendMissing(&d.Requires, other.Requires)
+	appendMissing(&d.Conflicts, other.Conflicts)
+	appendMissing(&d.Obsoletes, other.Obsoletes)
+	appendMissing(&d.Recommends, other.Recommends)
+	appendMissing(&d.Suggests, other.Suggests)
+	appendMissing(&d.Supplements, other.Supplements)
+	appendMissing(&d.Enhances, other.Enhances)
+	appendMissing(&d.OrderWithRequires, other.OrderWithRequires)
+}
+
+// RpmDependenciesFromConfig converts the string representation of the
+// dependencies (like requires, provides) of the package `pkg` into relations.
+// Qualified requires (e.g. `Requires(post)`) get the corresponding sense flags
+// and the interpreters of scriptlets are added as `Requires(interp)`.
+// If any of the dependencies cannot be converted into a proper relation, then
+// an error is returned.
+func RpmDependenciesFromConfig(pkg RpmPackage) (RpmDependencies, error) {
+	strToRelations := func(deps []string, sense uint32) (rpmpack.Relations, error) {
+		rels := make(rpmpack.Relations, len(deps))
+		for i, d := range deps {
+			r, err := NewRelationWithSense(d, sense)
+			if err != nil {
+				return nil, err
+			}
+			rels[i] = r
+		}
+		return rels, nil
+	}
+
+	var deps RpmDependencies
+	var err error
+
+	type qualifiedDeps struct {
+		deps  []string
+		sense uint32
+	}
+	qualifiedRequires := []qualifiedDeps{
+		{pkg.Requires, 0},
+		{pkg.RequiresPre, SenseScriptPre},
+		{pkg.RequiresPost, SenseScriptPost},
+		{pkg.RequiresPreUn, SenseScriptPreUn},
+		{pkg.RequiresPostUn, SenseScriptPostUn},
+		{pkg.RequiresPreTrans, SensePreTrans},
+		{pkg.RequiresPostTrans, SensePostTrans},
+		{pkg.RequiresPreUnTrans, SensePreUnTrans},
+		{pkg.RequiresPostUnTrans, SensePostUnTrans},
+		{pkg.RequiresVerify, SenseScriptVerify},
+		{pkg.RequiresInterp, SenseInterp},
+		{pkg.RequiresMeta, SenseMeta},
+	}
+	for _, qualifiers := range slices.Sorted(maps.Keys(pkg.RequiresCombined.Requires)) {
+		sense, err := RequiresQualifierSense(qualifiers)
+		if err != nil {
+			return RpmDependencies{}, err
+		}
+		qualifiedRequires = append(qualifiedRequires, qualifiedDeps{pkg.RequiresCombined.Requires[qualifiers], sense})
+	}
+
+	for _, q := range qualifiedRequires {
+		rels, err := strToRelations(q.deps, q.sense)
+		if err != nil {
+			return RpmDependencies{}, err
+		}
+		deps.Requires = append(deps.Requires, rels...)
+	}
+
+	interpreters, err := ScriptletRequires(pkg)
+	if err != nil {
+		return RpmDependencies{}, err
+	}
+	deps.Requires = append(deps.Requires, interpreters...)
+
+	unqualified := []struct {
+		deps []string
+		rels *rpmpack.Relations
+	}{
+		{pkg.Provides, &deps.Provides},
+		{pkg.Conflicts, &deps.Conflicts},
+		{pkg.Obsoletes, &deps.Obsoletes},
+		{pkg.Recommends, &deps.Recommends},
+		{pkg.Suggests, &deps.Suggests},
+		{pkg.Supplements, &deps.Supplements},
+		{pkg.Enhances, &deps.Enhances},
+		{pkg.OrderWithRequires, &deps.OrderWithRequires},
+	}
+	for _, u := range unqualified {
+		if *u.rels, err = strToRelations(u.deps, 0); err != nil {
+			return RpmDependencies{}, err
+		}
+	}
+
+	return deps, nil
+}
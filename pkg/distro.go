@@ -0,0 +1,420 @@
+package roci
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DistroProfile describes how packages are built for the releases of a
+// distribution
+type DistroProfile struct {
+	// Name of the profile, its key in the profiles file
+	Name string `yaml:"-"`
+	// Release is a regular expression that matches the whole `--release`,
+	// its named groups can be used as variables in the other fields, e.g.
+	// f(?P<version>[0-9]+) and .fc$version
+	Release string `yaml:"Release"`
+	// Dist is the value of the DIST build argument and variable
+	Dist string `yaml:"Dist"`
+	// DistTag is appended to the automatic release, e.g. .fc41
+	DistTag string `yaml:"DistTag"`
+	// Buildroot is the name of the buildroot image, it is passed as the
+	// BUILDROOT build argument
+	Buildroot string `yaml:"Buildroot"`
+	// Compressor of the payload, e.g. zstd:19
+	Compressor string `yaml:"Compressor"`
+	// Macros are rpm's macros of the distribution, e.g. _libdir, they
+	// are the defaults of the config's variables
+	Macros map[string]string `yaml:"Macros"`
+	// Vendor and Distribution are written into the header of the packages
+	// unless the config sets them
+	Vendor       string `yaml:"Vendor"`
+	Distribution string `yaml:"Distribution"`
+
+	// BaseImage is the image from which `roci buildroot create` creates
+	// the buildroot image
+	BaseImage string `yaml:"BaseImage"`
+	// Setup are the shell commands that install the rpm build tools into
+	// the buildroot
+	Setup []string `yaml:"Setup"`
+	// Env are the environment variables of the buildroot and the rpm
+	// expressions that define them, e.g. CFLAGS: "%{build_cflags}"
+	Env map[string]string `yaml:"Env"`
+	// Evaluate are the macros that are evaluated in the buildroot and
+	// stored in its labels in addition to Macros, e.g. optflags
+	Evaluate []string `yaml:"Evaluate"`
+}
+
+// builtinDistros are the profiles that are always available, the profiles of
+// the user can override them
+const builtinDistros = `
+fedora: &fedora
+  Release: f(?P<version>[0-9]+)
+  Dist: $version
+  DistTag: .fc$version
+  Buildroot: fedora-rpm-buildroot:$version
+  Compressor: zstd:19
+  Vendor: Fedora Project
+  Distribution: Fedora Project
+  BaseImage: registry.fedoraproject.org/fedora:$version
+  Setup:
+    - dnf -y install rpmdevtools rpm-build redhat-rpm-config 'rpm_macro(build_rustflags)'
+    - dnf -y clean all
+  Env: &rpm-env
+    CFLAGS: "%{build_cflags}"
+    CXXFLAGS: "%{build_cxxflags}"
+    FFLAGS: "%{build_fflags}"
+    FCFLAGS: "%{build_fflags}"
+    VALAFLAGS: "%{build_valaflags}"
+    RUSTFLAGS: "%{build_rustflags}"
+    LDFLAGS: "%{build_ldflags}"
+    CC: "%{build_cc}"
+    CXX: "%{build_cxx}"
+  Evaluate: &rpm-flags [optflags, _smp_mflags]
+  Macros: &fedora-macros
+    _prefix: /usr
+    _exec_prefix: /usr
+    _bindir: /usr/bin
+    _sbindir: /usr/sbin
+    _libdir: /usr/lib64
+    _libexecdir: /usr/libexec
+    _datadir: /usr/share
+    _datarootdir: /usr/share
+    _sysconfdir: /etc
+    _localstatedir: /var
+    _sharedstatedir: /var/lib
+    _rundir: /run
+    _includedir: /usr/include
+    _infodir: /usr/share/info
+    _mandir: /usr/share/man
+    _docdir: /usr/share/doc
+    _licensedir: /usr/share/licenses
+    _unitdir: /usr/lib/systemd/system
+    _userunitdir: /usr/lib/systemd/user
+    _tmpfilesdir: /usr/lib/tmpfiles.d
+    _sysusersdir: /usr/lib/sysusers.d
+
+# rawhide becomes the next release on every branching, its dist tag is the
+# %{dist} of the buildroot
+fedora-rawhide:
+  <<: *fedora
+  Release: rawhide
+  Dist: rawhide
+  DistTag: ""
+  Buildroot: fedora-rpm-buildroot:rawhide
+  BaseImage: registry.fedoraproject.org/fedora:rawhide
+
+el:
+  Release: el(?P<version>[0-9]+)
+  Dist: $version
+  DistTag: .el$version
+  Buildroot: el-rpm-buildroot:$version
+  Compressor: zstd:19
+  BaseImage: quay.io/centos/centos:stream$version
+  Setup:
+    - dnf -y install rpm-build redhat-rpm-config
+    - dnf -y clean all
+  Env: *rpm-env
+  Evaluate: *rpm-flags
+  Macros: *fedora-macros
+
+opensuse-tumbleweed:
+  Release: opensuse-tumbleweed|tw
+  Dist: tumbleweed
+  DistTag: .suse.tw
+  Buildroot: opensuse-rpm-buildroot:tumbleweed
+  Compressor: zstd:19
+  Vendor: openSUSE
+  Distribution: openSUSE Tumbleweed
+  BaseImage: registry.opensuse.org/opensuse/tumbleweed:latest
+  Setup:
+    - zypper -n install rpm-build rpm-config-SUSE
+    - zypper -n clean -a
+  Env: *rpm-env
+  Evaluate: *rpm-flags
+  Macros:
+    <<: *fedora-macros
+    _docdir: /usr/share/doc/packages
+    _sharedstatedir: /var/lib
+
+mageia:
+  Release: mga(?P<version>[0-9]+)
+  Dist: $version
+  DistTag: .mga$version
+  Buildroot: mageia-rpm-buildroot:$version
+  Compressor: xz
+  Vendor: Mageia.Org
+  Distribution: Mageia
+  BaseImage: docker.io/library/mageia:$version
+  Setup:
+    - dnf -y install rpm-build rpm-mageia-setup-build
+    - dnf -y clean all
+  Env: *rpm-env
+  Evaluate: *rpm-flags
+  Macros: *fedora-macros
+`
+
+// DistroRegistry are the known distribution profiles
+type DistroRegistry struct {
+	// profiles in the order in which they are matched
+	profiles []*DistroProfile
+}
+
+// NewDistroRegistry returns the built-in profiles together with the profiles
+// from the files `userFiles`. The profiles of later files replace the ones
+// with the same name of earlier files and of the built-in ones.
+func NewDistroRegistry(userFiles ...string) (*DistroRegistry, error) {
+	r := &DistroRegistry{}
+	if err := r.add([]byte(builtinDistros), "built-in profiles"); err != nil {
+		return nil, err
+	}
+	for _, f := range userFiles {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		if err := r.add(data, f); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// add parses the profiles `data` from the file `source`
+func (r *DistroRegistry) add(data []byte, source string) error {
+	var profiles map[string]*DistroProfile
+	if err := yaml.Unmarshal(data, &profiles); err != nil {
+		return fmt.Errorf("%s: %w", source, err)
+	}
+
+	// user profiles are matched before the ones they extend
+	var added []*DistroProfile
+	for _, name := range slices.Sorted(maps.Keys(profiles)) {
+		p := profiles[name]
+		if p == nil || p.Release == "" {
+			return fmt.Errorf("%s: the profile %s has no Release", source, name)
+		}
+		if _, err := regexp.Compile(p.Release); err != nil {
+			return fmt.Errorf("%s: invalid Release of the profile %s: %w", source, name, err)
+		}
+		p.Name = name
+		added = append(added, p)
+		r.profiles = slices.DeleteFunc(r.profiles, func(other *DistroProfile) bool { return other.Name == name })
+	}
+	r.profiles = append(added, r.profiles...)
+	return nil
+}
+
+// Lookup returns the profile that matches `release` with the variables of its
+// fields expanded. Unknown releases are an error.
+func (r *DistroRegistry) Lookup(release string) (*DistroProfile, error) {
+	for _, p := range r.profiles {
+		re := regexp.MustCompile("^(?:" + p.Release + ")$")
+		m := re.FindStringSubmatch(release)
+		if m == nil {
+			continue
+		}
+
+		// alternatives can use the same group name, only one of them
+		// matches
+		vars := make(map[string]string)
+		for i, name := range re.SubexpNames() {
+			if name != "" && (m[i] != "" || vars[name] == "") {
+				vars[name] = m[i]
+			}
+		}
+		v := NewVariables(vars)
+
+		res := *p
+		res.Release = release
+		res.Macros = maps.Clone(p.Macros)
+		res.Env = maps.Clone(p.Env)
+		for _, field := range []*string{&res.Dist, &res.DistTag, &res.Buildroot, &res.Vendor, &res.Distribution, &res.BaseImage} {
+			expanded, err := v.Expand(*field)
+			if err != nil {
+				return nil, fmt.Errorf("profile %s: %w", p.Name, err)
+			}
+			*field = expanded
+		}
+		return &res, nil
+	}
+
+	var known []string
+	for _, p := range r.profiles {
+		known = append(known, fmt.Sprintf("%s (%s)", p.Name, p.Release))
+	}
+	slices.Sort(known)
+	return nil, fmt.Errorf("unknown release %q, the known distributions are: %s", release, strings.Join(known, ", "))
+}
+
+// Names returns the names under which overrides can match the release of the
+// profile: the release itself, the name of the profile and the name together
+// with Dist, e.g. f41, fedora and fedora-41.
+func (p *DistroProfile) Names() []string {
+	names := []string{p.Release, p.Name}
+	if p.Name != "" && p.Dist != "" {
+		names = append(names, p.Name+"-"+p.Dist)
+	}
+	names = slices.DeleteFunc(names, func(name string) bool { return name == "" })
+	return slices.Compact(names)
+}
+
+// distExpression is the dist tag of the buildroot, empty if it has none
+const distExpression = "%{?dist}"
+
+// rpmEvalSeparator separates the values of the expressions in the output of
+// RpmEvalCommand
+const rpmEvalSeparator = "--roci-eval--"
+
+// BuildrootExpressions returns the rpm expressions that `roci buildroot create`
+// evaluates in the buildroot of the profile: %{?dist}, the Macros, the
+// Evaluate macros and the expressions of Env
+func (p *DistroProfile) BuildrootExpressions() []string {
+	exprs := []string{distExpression}
+	for _, name := range p.buildrootMacros() {
+		exprs = append(exprs, "%{"+name+"}")
+	}
+	exprs = append(exprs, slices.Collect(maps.Values(p.Env))...)
+	slices.Sort(exprs)
+	return slices.Compact(exprs)
+}
+
+// buildrootMacros returns the names of the macros that are stored in the
+// labels of the buildroot
+func (p *DistroProfile) buildrootMacros() []string {
+	names := slices.Concat(slices.Collect(maps.Keys(p.Macros)), p.Evaluate)
+	slices.Sort(names)
+	return slices.Compact(names)
+}
+
+// RpmEvalCommand returns the command that prints the values of the rpm
+// expressions `exprs`, ParseRpmEval splits its output
+func RpmEvalCommand(exprs []string) []string {
+	cmd := []string{"rpm"}
+	for _, expr := range exprs {
+		cmd = append(cmd, "--eval", expr+"\n"+rpmEvalSeparator)
+	}
+	return cmd
+}
+
+// ParseRpmEval returns the values of the expressions `exprs` from the output of
+// RpmEvalCommand. Expressions with undefined macros are missing.
+func ParseRpmEval(output string, exprs []string) (map[string]string, error) {
+	values := strings.Split(output, rpmEvalSeparator+"\n")
+	if len(values) != len(exprs)+1 || values[len(exprs)] != "" {
+		return nil, fmt.Errorf("expected the values of %d expressions from rpm, got: %s", len(exprs), output)
+	}
+
+	res := make(map[string]string)
+	for i, expr := range exprs {
+		value := strings.TrimSuffix(values[i], "\n")
+		// rpm leaves undefined macros alone
+		if value != expr {
+			res[expr] = value
+		}
+	}
+	return res, nil
+}
+
+// BuildrootConfig returns the environment and the labels of the buildroot image
+// of the profile from the values of its BuildrootExpressions. The labels are
+// org.rpm.distro with the name of the profile, org.rpm.dist with the %{dist}
+// of the buildroot or the DistTag if it has none, org.rpm.vendor,
+// org.rpm.distribution and org.rpm.macro.<name> with the value of each macro.
+func (p *DistroProfile) BuildrootConfig(values map[string]string) (env map[string]string, labels map[string]string) {
+	env = make(map[string]string)
+	for name, expr := range p.Env {
+		if value, ok := values[expr]; ok {
+			env[name] = value
+		}
+	}
+
+	labels = map[string]string{"org.rpm.distro": p.Name}
+	for name, value := range map[string]string{
+		"org.rpm.dist":         cmp.Or(values[distExpression], p.DistTag),
+		"org.rpm.vendor":       p.Vendor,
+		"org.rpm.distribution": p.Distribution,
+	} {
+		if value != "" {
+			labels[name] = value
+		}
+	}
+	for _, name := range p.buildrootMacros() {
+		if value, ok := values["%{"+name+"}"]; ok {
+			labels["org.rpm.macro."+name] = value
+		}
+	}
+	return env, labels
+}
+
+// BuildrootMacros returns the macros that `roci buildroot create` stored in the
+// labels `labels` of a buildroot image, see BuildrootConfig
+func BuildrootMacros(labels map[string]string) map[string]string {
+	macros := make(map[string]string)
+	for label, value := range labels {
+		if name, ok := strings.CutPrefix(label, "org.rpm.macro."); ok && name != "" {
+			macros[name] = value
+		}
+	}
+	return macros
+}
+
+// BuildrootDistro returns the profile of a buildroot image with the labels
+// `labels`. The name of the profile, DistTag, Vendor and Distribution are read
+// from the labels org.rpm.distro, org.rpm.dist, org.rpm.vendor and
+// org.rpm.distribution, the remaining fields are the ones of `distro`, the
+// profile of `--release`.
+//
+// It is an error if the name or the DistTag contradict `distro`, unless its
+// DistTag is empty like the one of rawhide. If `distro` is nil and the image
+// has none of the labels, then the result is nil.
+func BuildrootDistro(labels map[string]string, distro *DistroProfile) (*DistroProfile, error) {
+	res := &DistroProfile{}
+	if distro != nil {
+		c := *distro
+		res = &c
+	}
+
+	found := false
+	for _, f := range []struct {
+		label       string
+		field       *string
+		contradicts bool
+	}{
+		{"org.rpm.distro", &res.Name, true},
+		{"org.rpm.dist", &res.DistTag, true},
+		{"org.rpm.vendor", &res.Vendor, false},
+		{"org.rpm.distribution", &res.Distribution, false},
+	} {
+		value, ok := labels[f.label]
+		if !ok {
+			continue
+		}
+		if distro != nil && f.contradicts && *f.field != "" && *f.field != value {
+			return nil, fmt.Errorf("the buildroot is labeled %s=%q, but the release %s has %q", f.label, value, distro.Release, *f.field)
+		}
+		*f.field = value
+		found = true
+	}
+
+	if distro == nil && !found {
+		return nil, nil
+	}
+	return res, nil
+}
+
+// builtinDistro returns the built-in profile `name`
+func builtinDistro(name string) *DistroProfile {
+	var profiles map[string]*DistroProfile
+	if err := yaml.Unmarshal([]byte(builtinDistros), &profiles); err != nil {
+		panic(err)
+	}
+	return profiles[name]
+}
@@ -0,0 +1,248 @@
+package roci
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestDistroLookup(t *testing.T) {
+	distros, err := NewDistroRegistry()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		release                              string
+		name, dist, distTag, buildroot, comp string
+		names                                []string
+	}{
+		{"f41", "fedora", "41", ".fc41", "fedora-rpm-buildroot:41", "zstd:19", []string{"f41", "fedora", "fedora-41"}},
+		// the dist tag of rawhide comes from the buildroot
+		{"rawhide", "fedora-rawhide", "rawhide", "", "fedora-rpm-buildroot:rawhide", "zstd:19", []string{"rawhide", "fedora-rawhide", "fedora-rawhide-rawhide"}},
+		{"el9", "el", "9", ".el9", "el-rpm-buildroot:9", "zstd:19", []string{"el9", "el", "el-9"}},
+		{"tw", "opensuse-tumbleweed", "tumbleweed", ".suse.tw", "opensuse-rpm-buildroot:tumbleweed", "zstd:19", []string{"tw", "opensuse-tumbleweed", "opensuse-tumbleweed-tumbleweed"}},
+		{"mga9", "mageia", "9", ".mga9", "mageia-rpm-buildroot:9", "xz", []string{"mga9", "mageia", "mageia-9"}},
+	} {
+		d, err := distros.Lookup(tc.release)
+		if err != nil {
+			t.Errorf("%s: %v", tc.release, err)
+			continue
+		}
+		if d.Name != tc.name || d.Dist != tc.dist || d.DistTag != tc.distTag || d.Buildroot != tc.buildroot || d.Compressor != tc.comp {
+			t.Errorf("%s: unexpected profile %+v", tc.release, d)
+		}
+		if names := d.Names(); !slices.Equal(names, tc.names) {
+			t.Errorf("%s: expected the names %v, got %v", tc.release, tc.names, names)
+		}
+		if d.Macros["_bindir"] != "/usr/bin" {
+			t.Errorf("%s: unexpected macros %v", tc.release, d.Macros)
+		}
+	}
+
+	tw, _ := distros.Lookup("opensuse-tumbleweed")
+	if tw.Macros["_docdir"] != "/usr/share/doc/packages" || tw.Macros["_libdir"] != "/usr/lib64" {
+		t.Errorf("unexpected openSUSE macros %v", tw.Macros)
+	}
+
+	// the release must match as a whole
+	for _, release := range []string{"f", "xf41", "el9.2", "ubuntu24.04"} {
+		if _, err := distros.Lookup(release); err == nil || !strings.Contains(err.Error(), "unknown release") {
+			t.Errorf("%s: expected an unknown release, got %v", release, err)
+		}
+	}
+}
+
+func TestDistroUserProfiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "distros.yaml")
+	err := os.WriteFile(path, []byte(`
+fedora:
+  Release: f(?P<version>[0-9]+)
+  Dist: $version
+  DistTag: .fc$version
+  Buildroot: registry.example.com/buildroot:f$version
+sles:
+  Release: sle(?P<major>[0-9]+)sp(?P<sp>[0-9]+)
+  Dist: $major.$sp
+  DistTag: .sle$major
+  Vendor: SUSE LLC
+`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	distros, err := NewDistroRegistry(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f41, err := distros.Lookup("f41")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f41.Buildroot != "registry.example.com/buildroot:f41" || f41.Compressor != "" {
+		t.Errorf("the user profile does not replace the built-in one: %+v", f41)
+	}
+	sles, err := distros.Lookup("sle15sp6")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sles.Dist != "15.6" || sles.DistTag != ".sle15" || sles.Vendor != "SUSE LLC" {
+		t.Errorf("unexpected profile %+v", sles)
+	}
+	if _, err := distros.Lookup("el9"); err != nil {
+		t.Errorf("the built-in profiles must stay available: %v", err)
+	}
+
+	if err := os.WriteFile(path, []byte("foo:\n  Release: \"f(\"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewDistroRegistry(path); err == nil || !strings.Contains(err.Error(), "invalid Release of the profile foo") {
+		t.Errorf("expected an invalid Release, got %v", err)
+	}
+}
+
+func TestDistroBuildroot(t *testing.T) {
+	distros, err := NewDistroRegistry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	f41, err := distros.Lookup("f41")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f41.BaseImage != "registry.fedoraproject.org/fedora:41" {
+		t.Errorf("unexpected base image %s", f41.BaseImage)
+	}
+
+	exprs := f41.BuildrootExpressions()
+	for _, expr := range []string{"%{?dist}", "%{_libdir}", "%{optflags}", "%{_smp_mflags}", "%{build_cflags}", "%{build_fflags}"} {
+		if !slices.Contains(exprs, expr) {
+			t.Errorf("%s is not evaluated: %v", expr, exprs)
+		}
+	}
+	if !slices.IsSorted(exprs) || len(slices.Compact(slices.Clone(exprs))) != len(exprs) {
+		t.Errorf("the expressions must be sorted and unique: %v", exprs)
+	}
+
+	cmd := RpmEvalCommand([]string{"%{_libdir}", "%{build_rustflags}", "%{optflags}"})
+	if cmd[0] != "rpm" || len(cmd) != 7 || cmd[1] != "--eval" || !strings.HasPrefix(cmd[2], "%{_libdir}\n") {
+		t.Errorf("unexpected command %q", cmd)
+	}
+
+	// what rpm prints for the command above
+	output := "/usr/lib64\n--roci-eval--\n%{build_rustflags}\n--roci-eval--\n-O2 -g\n-pipe\n--roci-eval--\n"
+	values, err := ParseRpmEval(output, []string{"%{_libdir}", "%{build_rustflags}", "%{optflags}"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"%{_libdir}": "/usr/lib64", "%{optflags}": "-O2 -g\n-pipe"}
+	if !maps.Equal(values, expected) {
+		t.Errorf("expected %v, got %v", expected, values)
+	}
+	if _, err := ParseRpmEval("/usr/lib64\n", []string{"%{_libdir}", "%{optflags}"}); err == nil {
+		t.Error("expected an error for missing values")
+	}
+
+	env, labels := f41.BuildrootConfig(map[string]string{
+		"%{_libdir}":      "/usr/lib64",
+		"%{optflags}":     "-O2",
+		"%{build_cflags}": "-O2 -flto",
+		"%{build_fflags}": "-O2 -I/usr/lib64/gfortran/modules",
+	})
+	expectedEnv := map[string]string{"CFLAGS": "-O2 -flto", "FFLAGS": "-O2 -I/usr/lib64/gfortran/modules", "FCFLAGS": "-O2 -I/usr/lib64/gfortran/modules"}
+	if !maps.Equal(env, expectedEnv) {
+		t.Errorf("expected the environment %v, got %v", expectedEnv, env)
+	}
+	expectedLabels := map[string]string{
+		"org.rpm.distro":         "fedora",
+		"org.rpm.dist":           ".fc41",
+		"org.rpm.vendor":         "Fedora Project",
+		"org.rpm.distribution":   "Fedora Project",
+		"org.rpm.macro._libdir":  "/usr/lib64",
+		"org.rpm.macro.optflags": "-O2",
+	}
+	if !maps.Equal(labels, expectedLabels) {
+		t.Errorf("expected the labels %v, got %v", expectedLabels, labels)
+	}
+
+	// the dist tag of the buildroot wins over the one of the profile
+	if _, labels := f41.BuildrootConfig(map[string]string{"%{?dist}": ".fc41~bootstrap"}); labels["org.rpm.dist"] != ".fc41~bootstrap" {
+		t.Errorf("expected the dist tag of the buildroot, got %v", labels)
+	}
+
+	macros := BuildrootMacros(labels)
+	expectedMacros := map[string]string{"_libdir": "/usr/lib64", "optflags": "-O2"}
+	if !maps.Equal(macros, expectedMacros) {
+		t.Errorf("expected the macros %v, got %v", expectedMacros, macros)
+	}
+}
+
+func TestBuildrootDistro(t *testing.T) {
+	distros, err := NewDistroRegistry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	f41, err := distros.Lookup("f41")
+	if err != nil {
+		t.Fatal(err)
+	}
+	labels := map[string]string{
+		"org.rpm.distro":       "fedora",
+		"org.rpm.dist":         ".fc41",
+		"org.rpm.vendor":       "Example",
+		"org.rpm.distribution": "Example Linux",
+	}
+
+	d, err := BuildrootDistro(labels, f41)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.DistTag != ".fc41" || d.Vendor != "Example" || d.Distribution != "Example Linux" || d.Buildroot != f41.Buildroot {
+		t.Errorf("unexpected profile %+v", d)
+	}
+	if f41.Vendor != "Fedora Project" {
+		t.Error("the profile of the release must not be modified")
+	}
+
+	// without --release everything comes from the labels
+	d, err = BuildrootDistro(labels, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Name != "fedora" || d.DistTag != ".fc41" || d.Vendor != "Example" {
+		t.Errorf("unexpected profile %+v", d)
+	}
+	if names := d.Names(); !slices.Equal(names, []string{"fedora"}) {
+		t.Errorf("unexpected names %v", names)
+	}
+
+	if d, err := BuildrootDistro(map[string]string{"maintainer": "me"}, nil); d != nil || err != nil {
+		t.Errorf("expected no profile, got %+v, %v", d, err)
+	}
+	if d, err := BuildrootDistro(nil, f41); err != nil || d.DistTag != ".fc41" {
+		t.Errorf("an image without labels must keep the profile, got %+v, %v", d, err)
+	}
+
+	el9, err := distros.Lookup("el9")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := BuildrootDistro(labels, el9); err == nil || !strings.Contains(err.Error(), "the release el9") {
+		t.Errorf("expected a contradiction, got %v", err)
+	}
+	if _, err := BuildrootDistro(map[string]string{"org.rpm.dist": ".fc40"}, f41); err == nil {
+		t.Error("expected a contradicting dist tag")
+	}
+
+	rawhide, err := distros.Lookup("rawhide")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err = BuildrootDistro(map[string]string{"org.rpm.distro": "fedora-rawhide", "org.rpm.dist": ".fc45"}, rawhide)
+	if err != nil || d.DistTag != ".fc45" || d.Vendor != "Fedora Project" {
+		t.Errorf("expected the dist tag of the rawhide buildroot, got %+v, %v", d, err)
+	}
+}
@@ -0,0 +1,322 @@
+package roci
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// BuiltinVariables are the variables that roci defines for the expansion of
+// the config, user defined variables must not override them
+var BuiltinVariables = []string{"NAME", "VERSION", "RELEASE", "EPOCH", "DIST", "ARCH", "AUTORELEASE"}
+
+// Variables expands variables and macros in strings. The values of the
+// variables may themselves contain variables, which are expanded on first use.
+type Variables struct {
+	raw       map[string]string
+	expanded  map[string]string
+	expanding map[string]bool
+}
+
+// NewVariables creates the variables `vars`
+func NewVariables(vars map[string]string) *Variables {
+	return &Variables{
+		raw:       vars,
+		expanded:  make(map[string]string),
+		expanding: make(map[string]bool),
+	}
+}
+
+// lookup returns the expanded value of the variable `name`. Names that are not
+// defined are looked up in upper case, so that macros like %{name} find the
+// built-in NAME.
+func (v *Variables) lookup(name string) (string, bool, error) {
+	if _, ok := v.raw[name]; !ok {
+		name = strings.ToUpper(name)
+	}
+	raw, ok := v.raw[name]
+	if !ok {
+		return "", false, nil
+	}
+	if val, ok := v.expanded[name]; ok {
+		return val, true, nil
+	}
+
+	if v.expanding[name] {
+		return "", false, fmt.Errorf("variable %q references itself", name)
+	}
+	v.expanding[name] = true
+	defer delete(v.expanding, name)
+
+	val, err := v.Expand(raw)
+	if err != nil {
+		return "", false, err
+	}
+	v.expanded[name] = val
+	return val, true, nil
+}
+
+// Expand expands `s` supporting the following forms:
+//
+//	$VAR, ${VAR}  value of VAR, an error if it is undefined
+//	%{var}        same as ${VAR}
+//	%{?var}       value of var or nothing if it is undefined
+//	%{?var:text}  text if var is defined
+//	%{!?var:text} text if var is undefined
+//	$$, %%        a literal $ or %
+func (v *Variables) Expand(s string) (string, error) {
+	return v.expand(s, true)
+}
+
+// ExpandMacros is like Expand, but only expands the %{…} forms, so that shell
+// variables can be used without escaping
+func (v *Variables) ExpandMacros(s string) (string, error) {
+	return v.expand(s, false)
+}
+
+func (v *Variables) expand(s string, shellVars bool) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c != '$' || !shellVars) && c != '%' || i+1 == len(s) {
+			b.WriteByte(c)
+			continue
+		}
+
+		next := s[i+1]
+		switch {
+		case next == c:
+			// escaped $ or %
+			b.WriteByte(c)
+			i++
+		case c == '%' && next == '{', c == '$' && next == '{':
+			end := matchingBrace(s, i+1)
+			if end < 0 {
+				return "", fmt.Errorf("unterminated %c{ in %q", c, s)
+			}
+			body := s[i+2 : end]
+			var val string
+			var err error
+			if c == '%' {
+				val, err = v.macro(body)
+			} else {
+				val, err = v.variable(body)
+			}
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(val)
+			i = end
+		case c == '$' && isNameStart(next):
+			end := i + 1
+			for end < len(s) && isNameChar(s[end]) {
+				end++
+			}
+			val, err := v.variable(s[i+1 : end])
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(val)
+			i = end - 1
+		default:
+			// a $ or % that does not start a variable, e.g. "$1" or "100%"
+			b.WriteByte(c)
+		}
+	}
+	return b.String(), nil
+}
+
+// variable returns the value of the defined variable `name`
+func (v *Variables) variable(name string) (string, error) {
+	val, ok, err := v.lookup(name)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", fmt.Errorf("undefined variable %q", name)
+	}
+	return val, nil
+}
+
+// macro expands the body of a %{…} macro
+func (v *Variables) macro(body string) (string, error) {
+	negate := strings.HasPrefix(body, "!?")
+	conditional := negate || strings.HasPrefix(body, "?")
+	if !conditional {
+		return v.variable(body)
+	}
+
+	body = strings.TrimPrefix(strings.TrimPrefix(body, "!"), "?")
+	name, text, hasText := strings.Cut(body, ":")
+	val, defined, err := v.lookup(name)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case defined == negate:
+		return "", nil
+	case hasText:
+		return v.Expand(text)
+	default:
+		return val, nil
+	}
+}
+
+// matchingBrace returns the index of the brace closing the one at `open`
+func matchingBrace(s string, open int) int {
+	depth := 0
+	for i := open; i < len(s); i++ {
+		switch s[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func isNameStart(c byte) bool {
+	return c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+func isNameChar(c byte) bool {
+	return isNameStart(c) || '0' <= c && c <= '9'
+}
+
+// Expand expands the variables in all strings of the config. `builtins` are
+// the values of the built-in variables that do not come from the config, e.g.
+// DIST. Name, Version, Release and Epoch of the main package define NAME,
+// VERSION, RELEASE and EPOCH.
+func (c *Config) Expand(builtins map[string]string) error {
+	vars := make(map[string]string)
+	for name, val := range c.Variables {
+		if slices.Contains(BuiltinVariables, name) {
+			return fmt.Errorf("Variables: %s is a built-in variable and cannot be redefined", name)
+		}
+		vars[name] = val
+	}
+	for name, val := range builtins {
+		if val != "" {
+			vars[name] = val
+		}
+	}
+	for name, val := range map[string]string{"NAME": c.Name, "VERSION": c.Version, "RELEASE": c.Release} {
+		if val != "" {
+			vars[name] = val
+		}
+	}
+	if c.Epoch != 0 {
+		vars["EPOCH"] = strconv.Itoa(c.Epoch)
+	}
+
+	v := NewVariables(vars)
+	// the variables themselves are expanded on use
+	variables := c.Variables
+	c.Variables = nil
+	defer func() { c.Variables = variables }()
+
+	return expandValue(v, reflect.ValueOf(c).Elem(), "", false)
+}
+
+// expandValue expands all strings in `val`, `path` is the location in the
+// config for error messages
+func expandValue(v *Variables, val reflect.Value, path string, macrosOnly bool) error {
+	switch val.Kind() {
+	case reflect.String:
+		expand := v.Expand
+		if macrosOnly {
+			expand = v.ExpandMacros
+		}
+		s, err := expand(val.String())
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		val.SetString(s)
+
+	case reflect.Struct:
+		t := val.Type()
+		for i := range t.NumField() {
+			field := t.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+			fieldPath := path
+			if name != "" && name != "-" {
+				fieldPath = joinPath(path, name)
+			}
+			// scriptlets are shell scripts with their own variables and
+			// the changelog is expanded like in a spec file
+			specLike := t == reflect.TypeOf(RpmScriptlet{}) && field.Name == "Script" ||
+				t == reflect.TypeOf(Config{}) && field.Name == "Changelog"
+			if err := expandValue(v, val.Field(i), fieldPath, macrosOnly || specLike); err != nil {
+				return err
+			}
+		}
+
+	case reflect.Slice:
+		if val.IsNil() {
+			return nil
+		}
+		// entries that expand to nothing are dropped, so that e.g. the
+		// dependency "%{?with_docs:pandoc}" only exists if docs is enabled
+		expanded := reflect.MakeSlice(val.Type(), 0, val.Len())
+		for i := range val.Len() {
+			elem := reflect.New(val.Type().Elem()).Elem()
+			elem.Set(val.Index(i))
+			if err := expandValue(v, elem, fmt.Sprintf("%s[%d]", path, i), macrosOnly); err != nil {
+				return err
+			}
+			if elem.Kind() == reflect.String && elem.String() == "" && val.Index(i).String() != "" {
+				continue
+			}
+			expanded = reflect.Append(expanded, elem)
+		}
+		val.Set(expanded)
+
+	case reflect.Map:
+		// map values are not addressable, expand a copy and store it
+		keys := val.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+		for _, key := range keys {
+			elem := reflect.New(val.Type().Elem()).Elem()
+			elem.Set(val.MapIndex(key))
+			if err := expandValue(v, elem, joinPath(path, key.String()), macrosOnly); err != nil {
+				return err
+			}
+			val.SetMapIndex(key, elem)
+		}
+	}
+	return nil
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// goArchToRpm maps the GOARCH names to the ones that rpm uses, where they differ
+var goArchToRpm = map[string]string{
+	"amd64":   "x86_64",
+	"arm64":   "aarch64",
+	"386":     "i686",
+	"arm":     "armv7hl",
+	"loong64": "loongarch64",
+}
+
+// RpmArch returns rpm's name of the architecture `goarch`
+func RpmArch(goarch string) string {
+	if arch, ok := goArchToRpm[goarch]; ok {
+		return arch
+	}
+	return goarch
+}
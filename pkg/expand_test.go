@@ -0,0 +1,136 @@
+package roci
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestVariablesExpand(t *testing.T) {
+	vars := NewVariables(map[string]string{
+		"NAME":    "poke",
+		"VERSION": "4.3",
+		"RELEASE": "2.fc$DIST",
+		"DIST":    "41",
+		"prefix":  "/usr/lib/%{name}",
+		"loop":    "${loop}",
+	})
+
+	tests := []struct {
+		input    string
+		expected string
+		wantErr  bool
+	}{
+		{"plain", "plain", false},
+		{"$NAME-$VERSION", "poke-4.3", false},
+		{"${NAME}s", "pokes", false},
+		{"%{name}-%{version}-%{release}", "poke-4.3-2.fc41", false},
+		{"$prefix/data", "/usr/lib/poke/data", false},
+		// lower-case names also find the upper-case variables, but not
+		// the other way around
+		{"$name", "poke", false},
+		{"%{PREFIX}", "", true},
+		{"1%{?epoch}", "1", false},
+		{"%{?epoch:%{epoch}:}%{version}", "4.3", false},
+		{"%{!?epoch:no epoch}", "no epoch", false},
+		{"%{?dist:.fc%{dist}}", ".fc41", false},
+		{"$$HOME 100%% $1 50%", "$HOME 100% $1 50%", false},
+		{"$UNDEFINED", "", true},
+		{"%{undefined}", "", true},
+		{"%{name", "", true},
+		{"$loop", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			res, err := vars.Expand(tt.input)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("expected an error, got %q", res)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if res != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, res)
+			}
+		})
+	}
+}
+
+func TestConfigExpand(t *testing.T) {
+	input := `
+Name: poke
+Version: 4.3
+Release: 1.fc$DIST
+Variables:
+  datadir: /usr/share/$NAME
+Requires:
+  - "poke-data = $VERSION-$RELEASE"
+Requires(post,postun): ["%{name}-libs"]
+Postin: |
+  echo $1 > %{datadir}/installed
+package:
+  poke-devel:
+    Name: "${NAME}-devel"
+    Summary: "Development files for $NAME on $ARCH"
+`
+	var cfg Config
+	if err := yaml.Unmarshal([]byte(input), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Expand(map[string]string{"DIST": "41", "ARCH": "x86_64"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if cfg.Release != "1.fc41" {
+		t.Errorf("unexpected Release: %q", cfg.Release)
+	}
+	if !slices.Equal(cfg.Requires, []string{"poke-data = 4.3-1.fc41"}) {
+		t.Errorf("unexpected Requires: %v", cfg.Requires)
+	}
+	if !slices.Equal(cfg.RequiresCombined.Requires["post,postun"], []string{"poke-libs"}) {
+		t.Errorf("unexpected Requires(post,postun): %v", cfg.RequiresCombined.Requires)
+	}
+	// shell variables in scriptlets are left alone
+	if cfg.Postin.Script != "echo $1 > /usr/share/poke/installed\n" {
+		t.Errorf("unexpected Postin: %q", cfg.Postin.Script)
+	}
+	devel := cfg.Package["poke-devel"]
+	if devel.Name != "poke-devel" || devel.Summary != "Development files for poke on x86_64" {
+		t.Errorf("unexpected subpackage: %q, %q", devel.Name, devel.Summary)
+	}
+	// the variables are kept as they are
+	if cfg.Variables["datadir"] != "/usr/share/$NAME" {
+		t.Errorf("unexpected Variables: %v", cfg.Variables)
+	}
+}
+
+func TestConfigExpandErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		err   string
+	}{
+		{"undefined", "Name: foo\nRequires: [\"bar = $VERSION\"]\n", `Requires[0]: undefined variable "VERSION"`},
+		{"subpackage", "Name: foo\npackage:\n  foo-devel:\n    Summary: $FOO\n", `package.foo-devel.Summary: undefined variable "FOO"`},
+		{"builtin redefined", "Name: foo\nVariables:\n  NAME: bar\n", "NAME is a built-in variable"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var cfg Config
+			if err := yaml.Unmarshal([]byte(tt.input), &cfg); err != nil {
+				t.Fatal(err)
+			}
+			err := cfg.Expand(nil)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("expected an error containing %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package roci
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// git runs git with the arguments `args` in the repository `dir` and returns
+// its trimmed output
+func git(dir string, args ...string) (string, error) {
+	out, err := exec.Command("git", append([]string{"-C", dir}, args...)...).Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return "", fmt.Errorf("git %s failed: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// LastCommitTime returns the commit time of the HEAD commit of the git
+// repository `dir`
+func LastCommitTime(dir string) (time.Time, error) {
+	out, err := git(dir, "log", "-1", "--format=%ct")
+	if err != nil {
+		return time.Time{}, err
+	}
+	ts, err := strconv.ParseInt(out, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid commit time %q: %w", out, err)
+	}
+	return time.Unix(ts, 0).UTC(), nil
+}
+
+// AutoRelease is the release number and the changelog of a package derived
+// from the history of its dist-git repository
+type AutoRelease struct {
+	// Release is the number of commits since the last change of the
+	// package's version, including that change
+	Release int
+	// Changelog has an entry for every commit, newest first
+	Changelog []ChangelogEntry
+}
+
+// AutoReleaseFromGit calculates the automatic release of the package in the
+// dist-git directory `dir` with the config file `configFile` like rpmautospec:
+// every commit that touches `dir` increments the release, unless it changes
+// the Version in the config file, which resets the release to 1.
+func AutoReleaseFromGit(dir, configFile string) (*AutoRelease, error) {
+	rel, err := filepath.Rel(dir, configFile)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := git(dir, "log", "--reverse", "--format=%H%x00%ct%x00%an <%ae>%x00%s", "--", ".")
+	if err != nil {
+		return nil, err
+	}
+	if out == "" {
+		return nil, fmt.Errorf("no commits in %s", dir)
+	}
+
+	var release int
+	var version string
+	var changelog []ChangelogEntry
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.SplitN(line, "\x00", 4)
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("unexpected git log output %q", line)
+		}
+		hash, author, subject := fields[0], fields[2], fields[3]
+		ts, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid commit time %q: %w", fields[1], err)
+		}
+
+		v := versionAtCommit(dir, hash, rel)
+		if release == 0 || v != version {
+			release = 1
+		} else {
+			release++
+		}
+		version = v
+
+		name := author
+		if version != "" {
+			name = fmt.Sprintf("%s - %s-%d", author, version, release)
+		}
+		changelog = append(changelog, ChangelogEntry{
+			Time: changelogDate(time.Unix(ts, 0)),
+			Name: name,
+			Text: "- " + subject,
+		})
+	}
+	slices.Reverse(changelog)
+
+	return &AutoRelease{Release: release, Changelog: changelog}, nil
+}
+
+// versionAtCommit returns the Version in the config file `configFile` (relative
+// to `dir`) at the commit `hash`, or "" if the file or the Version are missing
+func versionAtCommit(dir, hash, configFile string) string {
+	data, err := git(dir, "show", hash+":./"+filepath.ToSlash(configFile))
+	if err != nil {
+		return ""
+	}
+	var cfg struct {
+		Version string `yaml:"Version"`
+	}
+	if err := yaml.Unmarshal([]byte(data), &cfg); err != nil {
+		return ""
+	}
+	return cfg.Version
+}
+
+// changelogDate returns noon (UTC) of the day of `t`, as rpm stores the dates
+// of the changelog
+func changelogDate(t time.Time) time.Time {
+	y, m, d := t.UTC().Date()
+	return time.Date(y, m, d, 12, 0, 0, 0, time.UTC)
+}
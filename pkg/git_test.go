@@ -0,0 +1,107 @@
+package roci
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// gitRepo creates a git repository in a temporary directory
+func gitRepo(t *testing.T) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	dir := t.TempDir()
+	runGit(t, dir, nil, "init", "-q")
+	return dir
+}
+
+// runGit runs git in `dir` with the additional environment `env`
+func runGit(t *testing.T, dir string, env []string, args ...string) {
+	t.Helper()
+
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=Joe Packager",
+		"GIT_AUTHOR_EMAIL=joe@example.com",
+		"GIT_COMMITTER_NAME=Joe Packager",
+		"GIT_COMMITTER_EMAIL=joe@example.com",
+	)
+	cmd.Env = append(cmd.Env, env...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git %v failed: %v\n%s", args, err, out)
+	}
+}
+
+// commitFile writes `contents` into `name` and commits it at `date`
+func commitFile(t *testing.T, dir, name, contents, message string, date time.Time) {
+	t.Helper()
+
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(contents), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, dir, nil, "add", name)
+	ts := date.Format(time.RFC3339)
+	runGit(t, dir, []string{"GIT_AUTHOR_DATE=" + ts, "GIT_COMMITTER_DATE=" + ts}, "commit", "-q", "-m", message)
+}
+
+func TestLastCommitTime(t *testing.T) {
+	dir := gitRepo(t)
+	if _, err := LastCommitTime(dir); err == nil {
+		t.Error("expected an error for a repository without commits")
+	}
+
+	first := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	second := first.Add(48 * time.Hour)
+	commitFile(t, dir, "foo.yaml", "Name: foo\n", "Initial import", first)
+	commitFile(t, dir, "Containerfile", "FROM scratch\n", "Add Containerfile", second)
+
+	ts, err := LastCommitTime(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !ts.Equal(second) {
+		t.Errorf("expected %v, got %v", second, ts)
+	}
+}
+
+func TestAutoReleaseFromGit(t *testing.T) {
+	dir := gitRepo(t)
+	configFile := filepath.Join(dir, "foo.yaml")
+	if _, err := AutoReleaseFromGit(dir, configFile); err == nil {
+		t.Error("expected an error for a repository without commits")
+	}
+
+	day := time.Date(2024, 3, 1, 8, 30, 0, 0, time.UTC)
+	commitFile(t, dir, "foo.yaml", "Name: foo\nVersion: 1.0\n", "Initial import", day)
+	commitFile(t, dir, "Containerfile", "FROM scratch\n", "Add Containerfile", day.Add(24*time.Hour))
+	commitFile(t, dir, "foo.yaml", "Name: foo\nVersion: 1.1\n", "Update to 1.1", day.Add(48*time.Hour))
+	commitFile(t, dir, "foo.yaml", "Name: foo\nVersion: 1.1\nSummary: foo\n", "Add a summary", day.Add(72*time.Hour))
+
+	auto, err := AutoReleaseFromGit(dir, configFile)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if auto.Release != 2 {
+		t.Errorf("expected release 2, got %d", auto.Release)
+	}
+
+	expected := []ChangelogEntry{
+		{time.Date(2024, 3, 4, 12, 0, 0, 0, time.UTC), "Joe Packager <joe@example.com> - 1.1-2", "- Add a summary"},
+		{time.Date(2024, 3, 3, 12, 0, 0, 0, time.UTC), "Joe Packager <joe@example.com> - 1.1-1", "- Update to 1.1"},
+		{time.Date(2024, 3, 2, 12, 0, 0, 0, time.UTC), "Joe Packager <joe@example.com> - 1.0-2", "- Add Containerfile"},
+		{time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC), "Joe Packager <joe@example.com> - 1.0-1", "- Initial import"},
+	}
+	if len(auto.Changelog) != len(expected) {
+		t.Fatalf("expected %d changelog entries, got %+v", len(expected), auto.Changelog)
+	}
+	for i, e := range expected {
+		if got := auto.Changelog[i]; !got.Time.Equal(e.Time) || got.Name != e.Name || got.Text != e.Text {
+			t.Errorf("expected changelog entry %+v, got %+v", e, got)
+		}
+	}
+}
@@ -0,0 +1,294 @@
+package roci
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+// header magic, version 1 and 4 reserved bytes
+var headerMagic = []byte{0x8e, 0xad, 0xe8, 0x01, 0, 0, 0, 0}
+
+// region tags of the signature and of the main header
+const (
+	regionSignatures = 62
+	regionImmutable  = 63
+)
+
+// data types of header entries
+const (
+	TypeNull        = 0
+	TypeChar        = 1
+	TypeInt8        = 2
+	TypeInt16       = 3
+	TypeInt32       = 4
+	TypeInt64       = 5
+	TypeString      = 6
+	TypeBin         = 7
+	TypeStringArray = 8
+	TypeI18NString  = 9
+)
+
+// integer entries must be aligned to their size
+var typeAlignment = map[int]int{
+	TypeInt16: 2,
+	TypeInt32: 4,
+	TypeInt64: 8,
+}
+
+// HeaderEntry is the value of a tag in a rpm header
+type HeaderEntry struct {
+	Type  int
+	Count int
+	Data  []byte
+}
+
+func EntryString(value string) HeaderEntry {
+	return HeaderEntry{TypeString, 1, append([]byte(value), 0)}
+}
+
+func EntryI18NString(value string) HeaderEntry {
+	return HeaderEntry{TypeI18NString, 1, append([]byte(value), 0)}
+}
+
+func EntryStringSlice(values []string) HeaderEntry {
+	var b bytes.Buffer
+	for _, v := range values {
+		b.WriteString(v)
+		b.WriteByte(0)
+	}
+	return HeaderEntry{TypeStringArray, len(values), b.Bytes()}
+}
+
+func EntryBytes(value []byte) HeaderEntry {
+	return HeaderEntry{TypeBin, len(value), value}
+}
+
+func EntryInt16(values []uint16) HeaderEntry {
+	return intEntry(TypeInt16, len(values), values)
+}
+
+func EntryInt32(values []uint32) HeaderEntry {
+	return intEntry(TypeInt32, len(values), values)
+}
+
+func EntryInt64(values []uint64) HeaderEntry {
+	return intEntry(TypeInt64, len(values), values)
+}
+
+func intEntry(typ, count int, values any) HeaderEntry {
+	var b bytes.Buffer
+	// writing fixed size integers into a bytes.Buffer cannot fail
+	_ = binary.Write(&b, binary.BigEndian, values)
+	return HeaderEntry{typ, count, b.Bytes()}
+}
+
+// Strings returns the value of a string, i18n string or string array entry
+func (e HeaderEntry) Strings() []string {
+	switch e.Type {
+	case TypeString, TypeI18NString, TypeStringArray:
+		s := strings.Split(string(e.Data), "\x00")
+		// the last string is terminated by a NUL as well
+		return s[:min(e.Count, len(s))]
+	}
+	return nil
+}
+
+// String returns the first string of a string entry
+func (e HeaderEntry) String() string {
+	if s := e.Strings(); len(s) > 0 {
+		return s[0]
+	}
+	return ""
+}
+
+// Uints returns the values of an integer entry
+func (e HeaderEntry) Uints() []uint64 {
+	size := map[int]int{TypeChar: 1, TypeInt8: 1, TypeInt16: 2, TypeInt32: 4, TypeInt64: 8}[e.Type]
+	if size == 0 {
+		return nil
+	}
+
+	values := make([]uint64, 0, e.Count)
+	for i := 0; i < e.Count && (i+1)*size <= len(e.Data); i++ {
+		b := e.Data[i*size : (i+1)*size]
+		switch size {
+		case 1:
+			values = append(values, uint64(b[0]))
+		case 2:
+			values = append(values, uint64(binary.BigEndian.Uint16(b)))
+		case 4:
+			values = append(values, uint64(binary.BigEndian.Uint32(b)))
+		case 8:
+			values = append(values, binary.BigEndian.Uint64(b))
+		}
+	}
+	return values
+}
+
+// Equal checks whether both entries have the same type and value
+func (e HeaderEntry) Equal(o HeaderEntry) bool {
+	return e.Type == o.Type && e.Count == o.Count && bytes.Equal(e.Data, o.Data)
+}
+
+// Header is a rpm header or signature header, which maps tags to their values
+type Header struct {
+	entries map[int]HeaderEntry
+}
+
+// NewHeader creates an empty header
+func NewHeader() *Header {
+	return &Header{entries: make(map[int]HeaderEntry)}
+}
+
+// Set adds or overwrites the value of `tag`
+func (h *Header) Set(tag int, e HeaderEntry) {
+	h.entries[tag] = e
+}
+
+// Get returns the value of `tag`
+func (h *Header) Get(tag int) (HeaderEntry, bool) {
+	e, ok := h.entries[tag]
+	return e, ok
+}
+
+// Delete removes `tag` from the header
+func (h *Header) Delete(tag int) {
+	delete(h.entries, tag)
+}
+
+// Tags returns all tags of the header in ascending order
+func (h *Header) Tags() []int {
+	tags := make([]int, 0, len(h.entries))
+	for t := range h.entries {
+		tags = append(tags, t)
+	}
+	slices.Sort(tags)
+	return tags
+}
+
+// Bytes serializes the header with the region tag `region`. The entries are
+// sorted by their tag, so that the output only depends on the header's
+// contents.
+func (h *Header) Bytes(region int) []byte {
+	tags := h.Tags()
+
+	// the region trailer is an index entry of the region tag, whose
+	// offset is the negative size of the index
+	trailer := &bytes.Buffer{}
+	_ = binary.Write(trailer, binary.BigEndian, []int32{int32(region), TypeBin, -int32(16 * (len(tags) + 1)), 16})
+
+	data := &bytes.Buffer{}
+	index := &bytes.Buffer{}
+	for _, tag := range tags {
+		e := h.entries[tag]
+		if align, ok := typeAlignment[e.Type]; ok && data.Len()%align != 0 {
+			data.Write(make([]byte, align-data.Len()%align))
+		}
+		_ = binary.Write(index, binary.BigEndian, []int32{int32(tag), int32(e.Type), int32(data.Len()), int32(e.Count)})
+		data.Write(e.Data)
+	}
+	trailerOffset := data.Len()
+	data.Write(trailer.Bytes())
+
+	out := &bytes.Buffer{}
+	out.Write(headerMagic)
+	_ = binary.Write(out, binary.BigEndian, []int32{int32(len(tags) + 1), int32(data.Len())})
+	_ = binary.Write(out, binary.BigEndian, []int32{int32(region), TypeBin, int32(trailerOffset), 16})
+	out.Write(index.Bytes())
+	out.Write(data.Bytes())
+	return out.Bytes()
+}
+
+// ReadHeader reads a header from `r`. The region tag is not included in the
+// result.
+// If `padded` is true, then the padding to 8 bytes is consumed as well, which is
+// present after the signature header.
+func ReadHeader(r io.Reader, padded bool) (*Header, error) {
+	intro := make([]byte, 16)
+	if _, err := io.ReadFull(r, intro); err != nil {
+		return nil, fmt.Errorf("failed to read header: %w", err)
+	}
+	if !bytes.Equal(intro[:3], headerMagic[:3]) {
+		return nil, errors.New("invalid header magic")
+	}
+	count := binary.BigEndian.Uint32(intro[8:12])
+	size := binary.BigEndian.Uint32(intro[12:16])
+	// sanity limits to not allocate arbitrary amounts of memory
+	if count > 1<<16 || size > 1<<28 {
+		return nil, fmt.Errorf("header too large: %d entries, %d bytes", count, size)
+	}
+
+	index := make([]byte, 16*count)
+	if _, err := io.ReadFull(r, index); err != nil {
+		return nil, fmt.Errorf("failed to read header index: %w", err)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, fmt.Errorf("failed to read header data: %w", err)
+	}
+	if padded {
+		if _, err := io.ReadFull(r, make([]byte, (8-size%8)%8)); err != nil {
+			return nil, fmt.Errorf("failed to read header padding: %w", err)
+		}
+	}
+
+	type indexEntry struct {
+		tag, typ, offset, count int
+	}
+	entries := make([]indexEntry, count)
+	for i := range entries {
+		e := index[16*i : 16*(i+1)]
+		entries[i] = indexEntry{
+			tag:    int(int32(binary.BigEndian.Uint32(e[0:4]))),
+			typ:    int(binary.BigEndian.Uint32(e[4:8])),
+			offset: int(int32(binary.BigEndian.Uint32(e[8:12]))),
+			count:  int(binary.BigEndian.Uint32(e[12:16])),
+		}
+	}
+
+	h := NewHeader()
+	for _, e := range entries {
+		if e.tag == regionSignatures || e.tag == regionImmutable {
+			continue
+		}
+		if e.offset < 0 || e.offset > len(data) {
+			return nil, fmt.Errorf("invalid offset of tag %d", e.tag)
+		}
+
+		var length int
+		switch e.typ {
+		case TypeNull:
+		case TypeChar, TypeInt8, TypeBin:
+			length = e.count
+		case TypeInt16:
+			length = 2 * e.count
+		case TypeInt32:
+			length = 4 * e.count
+		case TypeInt64:
+			length = 8 * e.count
+		case TypeString, TypeI18NString, TypeStringArray:
+			// strings are terminated by a NUL byte each
+			for n := 0; n < e.count; n++ {
+				end := bytes.IndexByte(data[e.offset+length:], 0)
+				if end < 0 {
+					return nil, fmt.Errorf("unterminated string in tag %d", e.tag)
+				}
+				length += end + 1
+			}
+		default:
+			return nil, fmt.Errorf("unknown type %d of tag %d", e.typ, e.tag)
+		}
+		if e.offset+length > len(data) {
+			return nil, fmt.Errorf("tag %d exceeds the header", e.tag)
+		}
+
+		h.Set(e.tag, HeaderEntry{e.typ, e.count, data[e.offset : e.offset+length]})
+	}
+
+	return h, nil
+}
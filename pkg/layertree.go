@@ -0,0 +1,194 @@
+package roci
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"maps"
+	"path"
+	"slices"
+	"strings"
+)
+
+const (
+	// prefix of whiteout files that delete a file from a lower layer
+	whiteoutPrefix = ".wh."
+	// whiteout file that removes all contents of its directory in the lower
+	// layers
+	opaqueWhiteout = ".wh..wh..opq"
+)
+
+// LayerEntry is a node of the merged filesystem of an image
+type LayerEntry struct {
+	Header *tar.Header
+	// Layer is the digest of the layer that last modified the entry
+	Layer string
+	// Digest is the sha256 of the contents of regular files, it is only
+	// computed if the layer was applied with hashing enabled
+	Digest string
+}
+
+// LayerTree is the merged filesystem that results from applying the layers of
+// an image on top of each other.
+// It only stores the metadata of each entry, not its contents.
+type LayerTree struct {
+	entries map[string]*LayerEntry
+}
+
+// NewLayerTree creates an empty LayerTree
+func NewLayerTree() *LayerTree {
+	return &LayerTree{entries: make(map[string]*LayerEntry)}
+}
+
+// Clone returns a copy of the tree that can be modified independently
+func (t *LayerTree) Clone() *LayerTree {
+	return &LayerTree{entries: maps.Clone(t.entries)}
+}
+
+// Entry returns the entry at `path` or nil if it does not exist
+func (t *LayerTree) Entry(path string) *LayerEntry {
+	return t.entries[path]
+}
+
+// EntryPath converts the name of a tar entry into an absolute path
+func EntryPath(name string) string {
+	return path.Clean("/" + name)
+}
+
+// ApplyLayer reads the tar stream `rdr` of the layer with the digest `layer`
+// and applies it on top of the tree, including OCI whiteouts.
+// If `hash` is true, then the digest of the contents of each regular file is
+// calculated, so that it can be compared with other trees.
+func (t *LayerTree) ApplyLayer(layer string, rdr io.Reader, hash bool) error {
+	tarRdr := tar.NewReader(rdr)
+	for {
+		hdr, err := tarRdr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		p := EntryPath(hdr.Name)
+		if p == "/" {
+			continue
+		}
+
+		dir, base := path.Split(p)
+		switch {
+		case base == opaqueWhiteout:
+			// the whiteout only removes the contents of lower layers,
+			// entries of this layer might already have been added
+			t.removeChildren(path.Clean(dir), func(e *LayerEntry) bool { return e.Layer != layer })
+			continue
+		case strings.HasPrefix(base, whiteoutPrefix):
+			target := path.Join(dir, strings.TrimPrefix(base, whiteoutPrefix))
+			delete(t.entries, target)
+			t.removeChildren(target, func(*LayerEntry) bool { return true })
+			continue
+		}
+
+		entry := &LayerEntry{Header: hdr, Layer: layer}
+		if hash && hdr.Typeflag == tar.TypeReg {
+			h := sha256.New()
+			if _, err := io.Copy(h, tarRdr); err != nil {
+				return fmt.Errorf("failed to read %s: %w", p, err)
+			}
+			entry.Digest = fmt.Sprintf("%x", h.Sum(nil))
+		}
+
+		// a directory replaced by something else loses its contents
+		if old, ok := t.entries[p]; ok && old.Header.Typeflag == tar.TypeDir && hdr.Typeflag != tar.TypeDir {
+			t.removeChildren(p, func(*LayerEntry) bool { return true })
+		}
+		t.entries[p] = entry
+	}
+}
+
+func (t *LayerTree) removeChildren(dir string, shouldRemove func(*LayerEntry) bool) {
+	prefix := strings.TrimSuffix(dir, "/") + "/"
+	for p, e := range t.entries {
+		if strings.HasPrefix(p, prefix) && shouldRemove(e) {
+			delete(t.entries, p)
+		}
+	}
+}
+
+// sameEntry checks whether `a` and `b` have the same contents and metadata.
+// The modification time is ignored, as directories of the base image get
+// touched when files are added to them.
+func sameEntry(a, b *LayerEntry) bool {
+	if a.Layer == b.Layer && a.Header.Name == b.Header.Name {
+		return true
+	}
+
+	ha, hb := a.Header, b.Header
+	if ha.Typeflag != hb.Typeflag || ha.Mode != hb.Mode ||
+		ha.Uid != hb.Uid || ha.Gid != hb.Gid ||
+		ha.Uname != hb.Uname || ha.Gname != hb.Gname ||
+		ha.Linkname != hb.Linkname ||
+		ha.Devmajor != hb.Devmajor || ha.Devminor != hb.Devminor {
+		return false
+	}
+
+	if ha.Typeflag == tar.TypeReg {
+		// without digests we cannot tell whether the contents match
+		return ha.Size == hb.Size && a.Digest != "" && a.Digest == b.Digest
+	}
+	return true
+}
+
+// DetachedHardlinks returns the hardlinks among the sorted paths `changed`
+// whose target is not part of `changed`, e.g. links to a file of the base
+// image. They cannot be packaged as hardlinks, but must be regular files with
+// the contents of their target. The result maps the path of the regular file
+// with the contents to the sorted paths of its detached hardlinks.
+func (t *LayerTree) DetachedHardlinks(changed []string) map[string][]string {
+	res := make(map[string][]string)
+	for _, p := range changed {
+		e := t.entries[p]
+		if e == nil || e.Header.Typeflag != tar.TypeLink {
+			continue
+		}
+		target := EntryPath(e.Header.Linkname)
+		if _, found := slices.BinarySearch(changed, target); found {
+			continue
+		}
+
+		// a link to a link shares the contents of the final target
+		for range len(t.entries) {
+			te := t.entries[target]
+			if te == nil || te.Header.Typeflag != tar.TypeLink {
+				break
+			}
+			target = EntryPath(te.Header.Linkname)
+		}
+		// broken links are reported when the package is written
+		if te := t.entries[target]; te != nil && te.Header.Typeflag == tar.TypeReg {
+			res[target] = append(res[target], p)
+		}
+	}
+	return res
+}
+
+// Diff compares the tree with the tree of its base image `base`.
+// It returns the sorted paths of all entries that were added or modified and
+// the sorted paths of all entries that exist in `base`, but were removed.
+func (t *LayerTree) Diff(base *LayerTree) (changed []string, removed []string) {
+	for p, e := range t.entries {
+		if b, ok := base.entries[p]; !ok || !sameEntry(e, b) {
+			changed = append(changed, p)
+		}
+	}
+	for p := range base.entries {
+		if _, ok := t.entries[p]; !ok {
+			removed = append(removed, p)
+		}
+	}
+
+	slices.Sort(changed)
+	slices.Sort(removed)
+	return changed, removed
+}
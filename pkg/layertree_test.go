@@ -0,0 +1,173 @@
+package roci
+
+import (
+	"archive/tar"
+	"bytes"
+	"slices"
+	"testing"
+)
+
+type tarEntry struct {
+	hdr  tar.Header
+	body string
+}
+
+func makeLayer(t *testing.T, entries ...tarEntry) *bytes.Buffer {
+	t.Helper()
+
+	buf := &bytes.Buffer{}
+	w := tar.NewWriter(buf)
+	for _, e := range entries {
+		hdr := e.hdr
+		if hdr.Typeflag == tar.TypeReg {
+			hdr.Size = int64(len(e.body))
+		}
+		if err := w.WriteHeader(&hdr); err != nil {
+			t.Fatalf("failed to write tar header: %v", err)
+		}
+		if _, err := w.Write([]byte(e.body)); err != nil {
+			t.Fatalf("failed to write tar body: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("failed to close tar: %v", err)
+	}
+	return buf
+}
+
+func dir(name string) tarEntry {
+	return tarEntry{hdr: tar.Header{Name: name, Typeflag: tar.TypeDir, Mode: 0o755}}
+}
+
+func file(name, body string) tarEntry {
+	return tarEntry{hdr: tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0o644}, body: body}
+}
+
+func TestLayerTreeDiff(t *testing.T) {
+	baseLayer := makeLayer(t,
+		dir("usr/"),
+		dir("usr/bin/"),
+		file("usr/bin/sh", "shell"),
+		dir("etc/"),
+		file("etc/os-release", "fedora"),
+		dir("var/cache/dnf/"),
+		file("var/cache/dnf/foo", "cache"),
+	)
+
+	shared := NewLayerTree()
+	if err := shared.ApplyLayer("sha256:base", baseLayer, false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the base image gets another layer that the stage does not have
+	base := shared.Clone()
+	baseUpdate := makeLayer(t, file("etc/os-release", "fedora 42"))
+	if err := base.ApplyLayer("sha256:base-update", baseUpdate, true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	stage := shared
+	stageLayer1 := makeLayer(t,
+		// touched, but unmodified directory
+		dir("usr/bin/"),
+		file("usr/bin/poke", "poke"),
+		file("usr/bin/tmp", "tmp"),
+		file("etc/os-release", "fedora 42"),
+		dir("usr/share/poke/"),
+		file("usr/share/poke/old", "old"),
+	)
+	stageLayer2 := makeLayer(t,
+		file("usr/bin/.wh.tmp", ""),
+		file("usr/share/poke/.wh..wh..opq", ""),
+		file("usr/share/poke/new", "new"),
+	)
+	if err := stage.ApplyLayer("sha256:stage1", stageLayer1, true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := stage.ApplyLayer("sha256:stage2", stageLayer2, true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	changed, removed := stage.Diff(base)
+
+	expectedChanged := []string{"/usr/bin/poke", "/usr/share/poke", "/usr/share/poke/new"}
+	if !slices.Equal(changed, expectedChanged) {
+		t.Errorf("expected changed %v, got %v", expectedChanged, changed)
+	}
+	if removed != nil {
+		t.Errorf("expected nothing to be removed, got %v", removed)
+	}
+
+	if e := stage.Entry("/usr/share/poke/new"); e == nil || e.Layer != "sha256:stage2" {
+		t.Errorf("expected /usr/share/poke/new from the second layer, got %+v", e)
+	}
+}
+
+func TestLayerTreeDiffRemoved(t *testing.T) {
+	base := NewLayerTree()
+	if err := base.ApplyLayer("sha256:base", makeLayer(t,
+		dir("etc/"),
+		file("etc/foo", "foo"),
+		dir("var/lib/"),
+		file("var/lib/bar", "bar"),
+	), false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	stage := base.Clone()
+	if err := stage.ApplyLayer("sha256:stage", makeLayer(t,
+		file("etc/.wh.foo", ""),
+		file("var/lib/.wh..wh..opq", ""),
+	), true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	changed, removed := stage.Diff(base)
+	if changed != nil {
+		t.Errorf("expected nothing to be changed, got %v", changed)
+	}
+	if expected := []string{"/etc/foo", "/var/lib/bar"}; !slices.Equal(removed, expected) {
+		t.Errorf("expected removed %v, got %v", expected, removed)
+	}
+}
+
+func TestLayerTreeDetachedHardlinks(t *testing.T) {
+	base := NewLayerTree()
+	baseLayer := makeLayer(t,
+		dir("usr/"),
+		dir("usr/bin/"),
+		file("usr/bin/python3.13", "python"),
+		tarEntry{hdr: tar.Header{Name: "usr/bin/python3", Typeflag: tar.TypeLink, Linkname: "usr/bin/python3.13"}},
+	)
+	if err := base.ApplyLayer("sha256:base", bytes.NewReader(baseLayer.Bytes()), true); err != nil {
+		t.Fatal(err)
+	}
+
+	stage := NewLayerTree()
+	if err := stage.ApplyLayer("sha256:base", baseLayer, true); err != nil {
+		t.Fatal(err)
+	}
+	// linking copies the unmodified target into the layer as well
+	stageLayer := makeLayer(t,
+		file("usr/bin/python3.13", "python"),
+		tarEntry{hdr: tar.Header{Name: "usr/bin/python", Typeflag: tar.TypeLink, Linkname: "usr/bin/python3.13"}},
+		tarEntry{hdr: tar.Header{Name: "usr/bin/py", Typeflag: tar.TypeLink, Linkname: "usr/bin/python3"}},
+		file("usr/bin/poke", "poke"),
+		tarEntry{hdr: tar.Header{Name: "usr/bin/pk", Typeflag: tar.TypeLink, Linkname: "usr/bin/poke"}},
+		tarEntry{hdr: tar.Header{Name: "usr/bin/broken", Typeflag: tar.TypeLink, Linkname: "usr/bin/missing"}},
+	)
+	if err := stage.ApplyLayer("sha256:stage", stageLayer, true); err != nil {
+		t.Fatal(err)
+	}
+
+	changed, _ := stage.Diff(base)
+	expectedChanged := []string{"/usr/bin/broken", "/usr/bin/pk", "/usr/bin/poke", "/usr/bin/py", "/usr/bin/python"}
+	if !slices.Equal(changed, expectedChanged) {
+		t.Fatalf("expected the changes %v, got %v", expectedChanged, changed)
+	}
+
+	detached := stage.DetachedHardlinks(changed)
+	if len(detached) != 1 || !slices.Equal(detached["/usr/bin/python3.13"], []string{"/usr/bin/py", "/usr/bin/python"}) {
+		t.Errorf("unexpected detached hardlinks %v", detached)
+	}
+}
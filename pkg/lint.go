@@ -0,0 +1,419 @@
+package roci
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/google/rpmpack"
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigError is a problem at a specific line of the config file
+type ConfigError struct {
+	File string
+	Line int
+	Msg  string
+}
+
+func (e *ConfigError) Error() string {
+	if e.Line == 0 {
+		return fmt.Sprintf("%s: %s", e.File, e.Msg)
+	}
+	return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Msg)
+}
+
+// LoadConfigStrict is LoadConfig, but fails if LintConfig finds any problem in
+// the config file
+func LoadConfigStrict(path string) (*Config, error) {
+	problems, err := LintConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(problems) > 0 {
+		errs := make([]error, len(problems))
+		for i, p := range problems {
+			errs[i] = p
+		}
+		return nil, errors.Join(errs...)
+	}
+	return LoadConfig(path)
+}
+
+// LintConfig checks the config file `path` for
+//
+//   - unknown keys, e.g. typos like `Requries`
+//   - values of the wrong type
+//   - missing required fields: Name, Version, License and Summary of the main
+//     package and the Summary of the subpackages
+//   - invalid characters in Name, Version and Release
+//   - an Epoch that is out of range
+//   - dependencies that cannot be parsed
+//   - conditionals with invalid names and conditions that use undeclared
+//     conditionals
+//   - an invalid changelog
+//
+// It returns all problems sorted by their line. The error is only set if the
+// file cannot be read or is no valid YAML.
+func LintConfig(path string) ([]*ConfigError, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	l := &configLinter{file: path}
+	if len(doc.Content) == 0 {
+		l.errorf(nil, "the config file is empty")
+		return l.problems, nil
+	}
+	root := doc.Content[0]
+
+	var cfg Config
+	var typeErr *yaml.TypeError
+	if err := root.Decode(&cfg); errors.As(err, &typeErr) {
+		for _, msg := range typeErr.Errors {
+			line := 0
+			if _, err := fmt.Sscanf(msg, "line %d:", &line); err == nil {
+				msg = strings.TrimSpace(msg[strings.Index(msg, ":")+1:])
+			}
+			l.problems = append(l.problems, &ConfigError{File: path, Line: line, Msg: msg})
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	l.checkKeys(root, reflect.TypeOf(Config{}))
+	l.checkPackage(root, true)
+	if packages := mappingValue(root, "package"); packages != nil && packages.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(packages.Content); i += 2 {
+			l.checkPackage(packages.Content[i+1], false)
+		}
+	}
+	if overrides := mappingValue(root, "Overrides"); overrides != nil && overrides.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(overrides.Content); i += 2 {
+			override := overrides.Content[i+1]
+			l.checkPackageValues(override, true)
+			if packages := mappingValue(override, "package"); packages != nil && packages.Kind == yaml.MappingNode {
+				for j := 0; j+1 < len(packages.Content); j += 2 {
+					l.checkPackageValues(packages.Content[j+1], false)
+				}
+			}
+		}
+	}
+	l.checkConditionals(root, cfg)
+
+	if changelog := mappingValue(root, "Changelog"); changelog != nil {
+		if _, err := ParseChangelog(changelog.Value); err != nil {
+			l.errorf(changelog, "%v", err)
+		}
+	} else if text, err := os.ReadFile(filepath.Join(filepath.Dir(path), ChangelogFileName)); err == nil {
+		if _, err := ParseChangelog(string(text)); err != nil {
+			l.problems = append(l.problems, &ConfigError{File: filepath.Join(filepath.Dir(path), ChangelogFileName), Msg: err.Error()})
+		}
+	}
+
+	slices.SortStableFunc(l.problems, func(a, b *ConfigError) int {
+		return cmp.Or(strings.Compare(a.File, b.File), cmp.Compare(a.Line, b.Line))
+	})
+	return l.problems, nil
+}
+
+type configLinter struct {
+	file     string
+	problems []*ConfigError
+}
+
+// errorf records a problem at the line of `node`
+func (l *configLinter) errorf(node *yaml.Node, format string, args ...any) {
+	line := 0
+	if node != nil {
+		line = node.Line
+	}
+	l.problems = append(l.problems, &ConfigError{File: l.file, Line: line, Msg: fmt.Sprintf(format, args...)})
+}
+
+// checkKeys reports all keys in `node` that `t` does not have. yaml's
+// KnownFields cannot be used for this, as it would reject the `Requires(…)`
+// keys with several qualifiers.
+func (l *configLinter) checkKeys(node *yaml.Node, t reflect.Type) {
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		// e.g. scriptlets written as a plain string
+		if node.Kind != yaml.MappingNode {
+			return
+		}
+		keys, combined := structKeys(t)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i]
+			if ft, ok := keys[key.Value]; ok {
+				l.checkKeys(node.Content[i+1], ft)
+			} else if !combined || !combinedRequiresKey.MatchString(key.Value) {
+				l.errorf(key, "unknown key %q%s", key.Value, didYouMean(key.Value, keys))
+			}
+		}
+
+	case reflect.Map:
+		if node.Kind == yaml.MappingNode {
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				l.checkKeys(node.Content[i+1], t.Elem())
+			}
+		}
+
+	case reflect.Slice:
+		if node.Kind == yaml.SequenceNode {
+			for _, item := range node.Content {
+				l.checkKeys(item, t.Elem())
+			}
+		}
+	}
+}
+
+// structKeys returns the yaml keys of the struct `t` and their types and
+// whether it accepts the `Requires(…)` keys with several qualifiers
+func structKeys(t reflect.Type) (map[string]reflect.Type, bool) {
+	keys := make(map[string]reflect.Type)
+	combined := false
+	for i := range t.NumField() {
+		field := t.Field(i)
+		name, opts, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		switch {
+		case field.Type == reflect.TypeOf(CombinedRequires{}):
+			combined = true
+		case opts == "inline":
+			inlined, c := structKeys(field.Type)
+			for k, v := range inlined {
+				keys[k] = v
+			}
+			combined = combined || c
+		case name == "-" || !field.IsExported():
+		case name == "":
+			keys[strings.ToLower(field.Name)] = field.Type
+		default:
+			keys[name] = field.Type
+		}
+	}
+	return keys, combined
+}
+
+// didYouMean suggests the known key that is the most similar to `key`
+func didYouMean(key string, keys map[string]reflect.Type) string {
+	best, bestDistance := "", 3
+	for k := range keys {
+		if d := editDistance(strings.ToLower(key), strings.ToLower(k)); d < bestDistance || d == bestDistance && k < best {
+			best, bestDistance = k, d
+		}
+	}
+	if best == "" {
+		return ""
+	}
+	return fmt.Sprintf(", did you mean %q?", best)
+}
+
+// editDistance is the Levenshtein distance of `a` and `b`
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+// dependencyKeys are the keys of the dependencies of a package, except for
+// the Requires with several qualifiers
+var dependencyKeys = []string{
+	"Requires", "Requires(pre)", "Requires(post)", "Requires(preun)",
+	"Requires(postun)", "Requires(pretrans)", "Requires(posttrans)",
+	"Requires(preuntrans)", "Requires(postuntrans)", "Requires(verify)", "Requires(interp)", "Requires(meta)",
+	"Provides", "Conflicts", "Obsoletes", "Recommends", "Suggests",
+	"Supplements", "Enhances", "OrderWithRequires",
+}
+
+var (
+	// rpm allows more characters, but these are the ones that do not
+	// cause trouble in file names and dependencies
+	nameChars    = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.+-]*$`)
+	versionChars = regexp.MustCompile(`^[A-Za-z0-9._+~^]+$`)
+)
+
+// checkPackage checks the values of the package `node`
+func (l *configLinter) checkPackage(node *yaml.Node, main bool) {
+	if node.Kind != yaml.MappingNode {
+		return
+	}
+
+	required := []string{"Summary"}
+	if main {
+		required = []string{"Name", "Version", "License", "Summary"}
+	}
+	for _, key := range required {
+		if v := mappingValue(node, key); v == nil || v.Kind == yaml.ScalarNode && v.Value == "" {
+			l.errorf(node, "missing required key %q", key)
+		}
+	}
+	l.checkPackageValues(node, main)
+}
+
+// checkPackageValues checks the names, versions and dependencies of the
+// package `node` without requiring any keys, like in the overrides
+func (l *configLinter) checkPackageValues(node *yaml.Node, main bool) {
+	if node.Kind != yaml.MappingNode {
+		return
+	}
+
+	if name := mappingValue(node, "Name"); name != nil && name.Value != "" {
+		// subpackages can use the shorthand -devel
+		value := name.Value
+		if !main && strings.HasPrefix(value, "-") {
+			value = "x" + value
+		}
+		if !nameChars.MatchString(withoutVariables(value)) {
+			l.errorf(name, "invalid Name %q: only letters, digits and '_.+-' are allowed and it must not start with '.', '+' or '-'", name.Value)
+		}
+	}
+	for _, key := range []string{"Version", "Release"} {
+		if v := mappingValue(node, key); v != nil && v.Value != "" && !versionChars.MatchString(withoutVariables(v.Value)) {
+			l.errorf(v, "invalid %s %q: only letters, digits and '._+~^' are allowed", key, v.Value)
+		}
+	}
+
+	if epoch := mappingValue(node, "Epoch"); epoch != nil {
+		if e, err := strconv.ParseInt(epoch.Value, 10, 64); err == nil && (e < 0 || e > math.MaxUint32) {
+			l.errorf(epoch, "Epoch %d is out of range, it must be between 0 and %d", e, uint32(math.MaxUint32))
+		}
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		if m := combinedRequiresKey.FindStringSubmatch(key.Value); m != nil {
+			if _, err := RequiresQualifierSense(m[1]); err != nil {
+				l.errorf(key, "%v", err)
+			}
+		} else if !slices.Contains(dependencyKeys, key.Value) {
+			continue
+		}
+		if value.Kind != yaml.SequenceNode {
+			continue
+		}
+		for _, dep := range value.Content {
+			if err := checkDependency(dep.Value); err != nil {
+				l.errorf(dep, "invalid dependency in %s: %v", key.Value, err)
+			}
+		}
+	}
+}
+
+// checkConditionals checks the names of the conditionals and that the
+// conditions of the packages use them
+func (l *configLinter) checkConditionals(root *yaml.Node, cfg Config) {
+	if conditionals := mappingValue(root, "Conditionals"); conditionals != nil && conditionals.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(conditionals.Content); i += 2 {
+			if key := conditionals.Content[i]; !conditionalName.MatchString(key.Value) {
+				l.errorf(key, "invalid conditional %q: only letters, digits and '_' are allowed", key.Value)
+			}
+		}
+	}
+
+	if condition := mappingValue(root, "Condition"); condition != nil {
+		l.errorf(condition, "only subpackages can have a Condition")
+	}
+	packages := mappingValue(root, "package")
+	if packages == nil || packages.Kind != yaml.MappingNode {
+		return
+	}
+	for i := 0; i+1 < len(packages.Content); i += 2 {
+		condition := mappingValue(packages.Content[i+1], "Condition")
+		if condition == nil || condition.Value == "" {
+			continue
+		}
+		name := strings.TrimPrefix(condition.Value, "!")
+		if _, ok := cfg.Conditionals[name]; !ok {
+			l.errorf(condition, "unknown conditional %q, it must be declared in Conditionals", name)
+		}
+	}
+}
+
+// checkDependency checks that `dep` is a valid dependency like "foo >= 1.0"
+func checkDependency(dep string) error {
+	r, err := rpmpack.NewRelation(dep)
+	if err != nil {
+		return err
+	}
+	// rich dependencies are checked by rpm
+	if strings.HasPrefix(dep, "(") {
+		return nil
+	}
+	switch {
+	case r.Name == "":
+		return fmt.Errorf("%q has no name", dep)
+	case r.Version != "" && r.Sense == rpmpack.SenseAny:
+		return fmt.Errorf("%q has a version, but no comparison operator", dep)
+	case r.Version == "" && r.Sense != rpmpack.SenseAny:
+		return fmt.Errorf("%q has a comparison operator, but no version", dep)
+	case strings.ContainsAny(r.Version, " \t"):
+		return fmt.Errorf("%q has more than one version", dep)
+	}
+	return nil
+}
+
+// withoutVariables replaces all variables and macros in `s` by a placeholder,
+// so that their names do not count as invalid characters
+func withoutVariables(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch {
+		case (s[i] == '$' || s[i] == '%') && i+1 < len(s) && s[i+1] == '{':
+			if end := matchingBrace(s, i+1); end > 0 {
+				b.WriteByte('0')
+				i = end
+				continue
+			}
+		case s[i] == '$' && i+1 < len(s) && isNameStart(s[i+1]):
+			i++
+			for i+1 < len(s) && isNameChar(s[i+1]) {
+				i++
+			}
+			b.WriteByte('0')
+			continue
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// mappingValue returns the value of `key` in the mapping `node`
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
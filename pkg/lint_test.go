@@ -0,0 +1,133 @@
+package roci
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeConfig writes `contents` into foo.yaml in a temporary directory
+func writeConfig(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "foo.yaml")
+	if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLintConfigValid(t *testing.T) {
+	path := writeConfig(t, `
+Name: poke
+Version: 4.3
+Release: $AUTORELEASE
+Epoch: 1
+License: GPL-3.0-or-later
+Summary: Extensible editor for structured binary data
+Requires:
+  - "poke-data = $VERSION-$RELEASE"
+  - "(poke-libs if poke-devel)"
+Requires(post,postun): [glibc]
+Postin: /sbin/ldconfig
+Postun:
+  Options: ["-p", "/sbin/ldconfig"]
+Variables:
+  datadir: /usr/share/poke
+Conditionals:
+  docs: true
+package:
+  poke-devel:
+    Name: -devel
+    Condition: "!docs"
+    Summary: Development files for poke
+    Release: "1%{?dist}"
+`)
+	problems, err := LintConfig(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, p := range problems {
+		t.Errorf("unexpected problem: %v", p)
+	}
+
+	if _, err := LoadConfigStrict(path); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestLintConfig(t *testing.T) {
+	path := writeConfig(t, `Name: po/ke
+Version: 4.3-1
+Epoch: -1
+License: GPL-3.0-or-later
+Requries: [glibc]
+Requires:
+  - "glibc 2.38"
+  - ">= 2.38"
+Requires(post,foo): [glibc]
+Postin:
+  Scirpt: echo
+package:
+  poke-devel:
+    Summary: [not, a, string]
+    URL: https://example.com
+    Condition: "!docs"
+  Name: wrong indentation
+Conditionals:
+  doc-s: true
+Overrides:
+  f4*:
+    Release: 1-2
+    Requires: ["glibc 2.40"]
+    package:
+      poke-devel:
+        Name: -devel/x
+        Requires: ["< 2"]
+`)
+
+	problems, err := LintConfig(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []string{
+		":1: missing required key \"Summary\"",
+		`:1: invalid Name "po/ke"`,
+		`:2: invalid Version "4.3-1"`,
+		":3: Epoch -1 is out of range",
+		`:5: unknown key "Requries", did you mean "Requires"?`,
+		`:7: invalid dependency in Requires: "glibc 2.38" has a version, but no comparison operator`,
+		`:8: invalid dependency in Requires: ">= 2.38" has no name`,
+		`:9: unknown Requires qualifier "foo"`,
+		`:11: unknown key "Scirpt", did you mean "Script"?`,
+		":14: cannot unmarshal !!seq into string",
+		`:16: unknown conditional "docs"`,
+		":17: cannot unmarshal !!str `wrong i...` into roci.RpmPackage",
+		`:19: invalid conditional "doc-s"`,
+		`:22: invalid Release "1-2"`,
+		`:23: invalid dependency in Requires: "glibc 2.40" has a version, but no comparison operator`,
+		`:26: invalid Name "-devel/x"`,
+		`:27: invalid dependency in Requires: "< 2" has no name`,
+	}
+	var got []string
+	for _, p := range problems {
+		if !strings.HasPrefix(p.Error(), path+":") {
+			t.Errorf("expected the problem to start with the file name, got %v", p)
+		}
+		got = append(got, strings.TrimPrefix(p.Error(), path))
+	}
+	for _, e := range expected {
+		found := false
+		for _, g := range got {
+			found = found || strings.HasPrefix(g, e)
+		}
+		if !found {
+			t.Errorf("expected a problem %q, got:\n%s", e, strings.Join(got, "\n"))
+		}
+	}
+
+	if _, err := LoadConfigStrict(path); err == nil {
+		t.Error("expected the strict mode to fail")
+	}
+}
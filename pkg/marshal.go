@@ -0,0 +1,139 @@
+package roci
+
+import (
+	"maps"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// MarshalConfig writes the config as YAML like it would be written by hand:
+// unset fields are omitted, scriptlets without options are plain strings,
+// multi line strings are literal blocks and the `Requires(…)` with several
+// qualifiers are keys of the package.
+func MarshalConfig(cfg *Config) ([]byte, error) {
+	node := valueNode(reflect.ValueOf(cfg).Elem())
+	if node == nil {
+		node = &yaml.Node{Kind: yaml.MappingNode}
+	}
+
+	var b strings.Builder
+	enc := yaml.NewEncoder(&b)
+	enc.SetIndent(2)
+	if err := enc.Encode(node); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return []byte(b.String()), nil
+}
+
+// valueNode returns the yaml node of `v` or nil if it is unset
+func valueNode(v reflect.Value) *yaml.Node {
+	switch v.Kind() {
+	case reflect.String:
+		if v.String() == "" {
+			return nil
+		}
+		node := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: v.String()}
+		if strings.Contains(v.String(), "\n") {
+			node.Style = yaml.LiteralStyle
+		}
+		return node
+
+	case reflect.Int:
+		if v.Int() == 0 {
+			return nil
+		}
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: strconv.FormatInt(v.Int(), 10)}
+
+	case reflect.Bool:
+		// only used in maps, where false must not be omitted
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: strconv.FormatBool(v.Bool())}
+
+	case reflect.Slice:
+		if v.Len() == 0 {
+			return nil
+		}
+		node := &yaml.Node{Kind: yaml.SequenceNode}
+		for i := range v.Len() {
+			if item := valueNode(v.Index(i)); item != nil {
+				node.Content = append(node.Content, item)
+			} else {
+				node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str"})
+			}
+		}
+		return node
+
+	case reflect.Map:
+		node := &yaml.Node{Kind: yaml.MappingNode}
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+		for _, key := range keys {
+			value := valueNode(v.MapIndex(key))
+			// e.g. a subpackage that only consists of its stage
+			if value == nil && v.Type().Elem().Kind() == reflect.Struct {
+				value = &yaml.Node{Kind: yaml.MappingNode, Style: yaml.FlowStyle}
+			}
+			if value != nil {
+				node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key.String()}, value)
+			}
+		}
+		if len(node.Content) == 0 {
+			return nil
+		}
+		return node
+
+	case reflect.Struct:
+		return structNode(v)
+	}
+	return nil
+}
+
+// structNode returns the yaml mapping of the struct `v` in the order of its
+// fields
+func structNode(v reflect.Value) *yaml.Node {
+	switch s := v.Interface().(type) {
+	case RpmScriptlet:
+		if len(s.Options) == 0 {
+			return valueNode(reflect.ValueOf(s.Script))
+		}
+	case CombinedRequires:
+		node := &yaml.Node{Kind: yaml.MappingNode}
+		for _, q := range slices.Sorted(maps.Keys(s.Requires)) {
+			if deps := valueNode(reflect.ValueOf(s.Requires[q])); deps != nil {
+				node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: "Requires(" + q + ")"}, deps)
+			}
+		}
+		return node
+	}
+
+	node := &yaml.Node{Kind: yaml.MappingNode}
+	t := v.Type()
+	for i := range t.NumField() {
+		field := t.Field(i)
+		name, opts, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if !field.IsExported() || name == "-" {
+			continue
+		}
+
+		value := valueNode(v.Field(i))
+		if value == nil {
+			continue
+		}
+		if opts == "inline" {
+			node.Content = append(node.Content, value.Content...)
+			continue
+		}
+		node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: name}, value)
+	}
+	if len(node.Content) == 0 {
+		return nil
+	}
+	return node
+}
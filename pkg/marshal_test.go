@@ -0,0 +1,66 @@
+package roci
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestMarshalConfigRoundTrip(t *testing.T) {
+	input := `
+Name: foo
+Version: "1.10"
+Epoch: 2
+License: MIT
+Summary: "yes"
+Requires: ["bar >= 1.0", ""]
+Requires(post,postun): [glibc]
+Description: |
+  Multi
+  line
+Preun: /sbin/ldconfig
+Postin:
+  Options: ["-p", "<lua>"]
+  Script: print("hello")
+Variables:
+  datadir: /usr/share/$NAME
+Conditionals:
+  docs: false
+package:
+  foo-devel:
+    Name: -devel
+    Summary: Development files
+  foo-empty: {}
+`
+	var expected Config
+	if err := yaml.Unmarshal([]byte(input), &expected); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := MarshalConfig(&expected)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cfg Config
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		t.Fatalf("cannot read back\n%s\n%v", data, err)
+	}
+	if !reflect.DeepEqual(cfg, expected) {
+		t.Errorf("expected\n%+v\ngot\n%+v\nfrom\n%s", expected, cfg, data)
+	}
+
+	for _, s := range []string{
+		`Version: "1.10"`,
+		"Requires(post,postun):\n  - glibc\n",
+		"Description: |\n  Multi\n  line\n",
+		"Preun: /sbin/ldconfig\n",
+		"  foo-empty: {}\n",
+		"  docs: false\n",
+	} {
+		if !strings.Contains(string(data), s) {
+			t.Errorf("%q is not in\n%s", s, data)
+		}
+	}
+}
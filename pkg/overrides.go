@@ -0,0 +1,152 @@
+package roci
+
+import (
+	"fmt"
+	"maps"
+	"path"
+	"reflect"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigOverride are the fields of the config that are overridden for the
+// releases matching its key in Config.Overrides
+type ConfigOverride struct {
+	RpmPackage `yaml:",inline"`
+
+	Package map[string]RpmPackage `yaml:"package"`
+
+	// keys are the keys that the override sets in the main package ("")
+	// and in the subpackages, so that empty values replace the config too
+	keys map[string]map[string]bool
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler and records the keys of the
+// override
+func (o *ConfigOverride) UnmarshalYAML(node *yaml.Node) error {
+	// plain alias to not recurse into this function again
+	type configOverride ConfigOverride
+	err := node.Decode((*configOverride)(o))
+
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	o.keys = map[string]map[string]bool{"": mappingKeys(node)}
+	if packages := mappingValue(node, "package"); packages != nil {
+		if packages.Kind == yaml.AliasNode {
+			packages = packages.Alias
+		}
+		for i := 0; i+1 < len(packages.Content); i += 2 {
+			pkg := packages.Content[i+1]
+			if pkg.Kind == yaml.AliasNode {
+				pkg = pkg.Alias
+			}
+			o.keys[packages.Content[i].Value] = mappingKeys(pkg)
+		}
+	}
+	// type errors are reported after decoding everything else
+	return err
+}
+
+// mappingKeys returns the keys of the mapping `node`
+func mappingKeys(node *yaml.Node) map[string]bool {
+	keys := make(map[string]bool)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		keys[node.Content[i].Value] = true
+	}
+	return keys
+}
+
+// ApplyOverrides merges the overrides whose pattern matches one of the names
+// of the release `names` over the main package and the subpackages, see
+// DistroProfile.Names. The patterns are shell patterns like `fedora*` or
+// `el9`. Patterns with wildcards are applied first, so that exact matches
+// win, otherwise they are applied in alphabetical order.
+//
+// Every field that an override sets replaces the value of the config, lists
+// are replaced as a whole and empty values like `Requires: []` clear it. Subpackages that only exist in an override are
+// added. ApplyOverrides must be called before InheritPreamble, so that the
+// subpackages inherit the overridden preamble.
+func (c *Config) ApplyOverrides(names ...string) error {
+	if len(names) == 0 {
+		c.Overrides = nil
+		return nil
+	}
+
+	patterns := slices.SortedFunc(maps.Keys(c.Overrides), func(a, b string) int {
+		aGlob, bGlob := strings.ContainsAny(a, "*?["), strings.ContainsAny(b, "*?[")
+		switch {
+		case aGlob && !bGlob:
+			return -1
+		case !aGlob && bGlob:
+			return 1
+		}
+		return strings.Compare(a, b)
+	})
+
+	for _, pattern := range patterns {
+		matches := false
+		for _, name := range names {
+			m, err := path.Match(pattern, name)
+			if err != nil {
+				return fmt.Errorf("Overrides: invalid pattern %q: %w", pattern, err)
+			}
+			matches = matches || m
+		}
+		if !matches {
+			continue
+		}
+
+		override := c.Overrides[pattern]
+		mergeOverride(reflect.ValueOf(&c.RpmPackage).Elem(), reflect.ValueOf(override.RpmPackage), override.keys[""])
+		for key, pkg := range override.Package {
+			if c.Package == nil {
+				c.Package = make(map[string]RpmPackage)
+			}
+			merged := c.Package[key]
+			mergeOverride(reflect.ValueOf(&merged).Elem(), reflect.ValueOf(pkg), override.keys[key])
+			c.Package[key] = merged
+		}
+	}
+
+	// the overrides are part of the config now
+	c.Overrides = nil
+	return nil
+}
+
+// mergeOverride sets all fields of `dst` that are set in `src`. The fields
+// whose yaml key is in `keys` are set even if they are empty.
+func mergeOverride(dst, src reflect.Value, keys map[string]bool) {
+	switch {
+	case src.IsZero() && len(keys) == 0:
+	// scriptlets are replaced as a whole, their options belong to the
+	// script
+	case src.Type() == reflect.TypeOf(RpmScriptlet{}):
+		dst.Set(src)
+	case src.Kind() == reflect.Struct:
+		for i := range src.NumField() {
+			field := src.Type().Field(i)
+			name, opts, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+			switch {
+			case !field.IsExported():
+			case opts == "inline":
+				mergeOverride(dst.Field(i), src.Field(i), keys)
+			case keys[name]:
+				dst.Field(i).Set(src.Field(i))
+			default:
+				mergeOverride(dst.Field(i), src.Field(i), nil)
+			}
+		}
+	case src.Kind() == reflect.Map:
+		if dst.IsNil() {
+			dst.Set(reflect.MakeMap(src.Type()))
+		}
+		for _, key := range src.MapKeys() {
+			dst.SetMapIndex(key, src.MapIndex(key))
+		}
+	default:
+		dst.Set(src)
+	}
+}
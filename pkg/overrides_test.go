@@ -0,0 +1,152 @@
+package roci
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+const overridesConfig = `
+Name: poke
+Version: 4.3
+License: GPL-3.0-or-later
+Requires: [libgc]
+Postin: /sbin/ldconfig
+package:
+  poke-devel:
+    Name: -devel
+    Requires: [gc-devel]
+Overrides:
+  fedora*:
+    Requires: [gc]
+    package:
+      poke-devel:
+        Requires: [gc-devel, pkgconf]
+  f41:
+    Requires: [gc-f41]
+  el9:
+    License: GPL-3.0-only
+    Postin:
+      Options: ["-p", "<lua>"]
+  opensuse-tumbleweed:
+    package:
+      poke-lang:
+        Summary: Language files
+`
+
+func TestApplyOverrides(t *testing.T) {
+	distros, err := NewDistroRegistry()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		release       string
+		requires      []string
+		develRequires []string
+		license       string
+		postin        RpmScriptlet
+		packages      []string
+	}{
+		{"", []string{"libgc"}, []string{"gc-devel"}, "GPL-3.0-or-later", RpmScriptlet{Script: "/sbin/ldconfig"}, []string{"poke-devel"}},
+		{"f40", []string{"gc"}, []string{"gc-devel", "pkgconf"}, "GPL-3.0-or-later", RpmScriptlet{Script: "/sbin/ldconfig"}, []string{"poke-devel"}},
+		// the exact match wins over the pattern
+		{"f41", []string{"gc-f41"}, []string{"gc-devel", "pkgconf"}, "GPL-3.0-or-later", RpmScriptlet{Script: "/sbin/ldconfig"}, []string{"poke-devel"}},
+		{"el9", []string{"libgc"}, []string{"gc-devel"}, "GPL-3.0-only", RpmScriptlet{Options: []string{"-p", "<lua>"}}, []string{"poke-devel"}},
+		{"opensuse-tumbleweed", []string{"libgc"}, []string{"gc-devel"}, "GPL-3.0-or-later", RpmScriptlet{Script: "/sbin/ldconfig"}, []string{"poke-devel", "poke-lang"}},
+	} {
+		t.Run(tc.release, func(t *testing.T) {
+			var names []string
+			if tc.release != "" {
+				distro, err := distros.Lookup(tc.release)
+				if err != nil {
+					t.Fatal(err)
+				}
+				names = distro.Names()
+			}
+
+			var cfg Config
+			if err := yaml.Unmarshal([]byte(overridesConfig), &cfg); err != nil {
+				t.Fatal(err)
+			}
+			if err := cfg.ApplyOverrides(names...); err != nil {
+				t.Fatal(err)
+			}
+			cfg.InheritPreamble()
+
+			if cfg.Overrides != nil {
+				t.Errorf("the overrides must be removed after applying them")
+			}
+			if !slices.Equal(cfg.Requires, tc.requires) {
+				t.Errorf("expected the Requires %v, got %v", tc.requires, cfg.Requires)
+			}
+			devel := cfg.Package["poke-devel"]
+			if !slices.Equal(devel.Requires, tc.develRequires) || devel.Name != "poke-devel" {
+				t.Errorf("expected poke-devel with the Requires %v, got %+v", tc.develRequires, devel)
+			}
+			// the subpackages inherit the overridden preamble
+			if cfg.License != tc.license || devel.License != tc.license {
+				t.Errorf("expected the License %s, got %s and %s", tc.license, cfg.License, devel.License)
+			}
+			if !slices.Equal(cfg.Postin.Options, tc.postin.Options) || cfg.Postin.Script != tc.postin.Script {
+				t.Errorf("expected the Postin %+v, got %+v", tc.postin, cfg.Postin)
+			}
+			var packages []string
+			for key := range cfg.Package {
+				packages = append(packages, key)
+			}
+			slices.Sort(packages)
+			if !slices.Equal(packages, tc.packages) {
+				t.Errorf("expected the packages %v, got %v", tc.packages, packages)
+			}
+		})
+	}
+}
+
+func TestApplyOverridesInvalidPattern(t *testing.T) {
+	cfg := Config{Overrides: map[string]ConfigOverride{"fedora[": {}}}
+	if err := cfg.ApplyOverrides("f41", "fedora", "fedora-41"); err == nil || !strings.Contains(err.Error(), `invalid pattern "fedora["`) {
+		t.Errorf("expected an invalid pattern, got %v", err)
+	}
+}
+
+func TestApplyOverridesEmptyValues(t *testing.T) {
+	var cfg Config
+	err := yaml.Unmarshal([]byte(`
+Name: poke
+URL: https://www.jemarch.net/poke
+Requires: [libgc]
+Requires(post,postun): [glibc]
+Postin: /sbin/ldconfig
+package:
+  poke-devel:
+    Summary: Development files
+    Requires: [gc-devel]
+Overrides:
+  el9:
+    URL: ""
+    Requires: []
+    Requires(post,postun): []
+    Postin: ""
+    package:
+      poke-devel:
+        Requires:
+`), &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.ApplyOverrides("el9"); err != nil {
+		t.Fatal(err)
+	}
+
+	devel := cfg.Package["poke-devel"]
+	if cfg.URL != "" || len(cfg.Requires) != 0 || len(cfg.RequiresCombined.Requires["post,postun"]) != 0 || !cfg.Postin.IsEmpty() || len(devel.Requires) != 0 {
+		t.Errorf("expected the override to clear the values, got %+v and %+v", cfg.RpmPackage, devel)
+	}
+	// keys that the override does not set are kept
+	if cfg.Name != "poke" || devel.Summary != "Development files" {
+		t.Errorf("expected the other values to be kept, got %+v and %+v", cfg.RpmPackage, devel)
+	}
+}
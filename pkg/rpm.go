@@ -0,0 +1,836 @@
+package roci
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/rpmpack"
+)
+
+// file type bits of the file modes, see stat(2)
+const (
+	modeTypeMask = 0170000
+	modeFifo     = 0010000
+	modeChar     = 0020000
+	modeDir      = 0040000
+	modeBlock    = 0060000
+	modeReg      = 0100000
+	modeSymlink  = 0120000
+)
+
+// RpmFile is a file in the payload of a rpm
+type RpmFile struct {
+	Name string
+	// Mode are the permission bits and the file type, e.g. 0100644 for a
+	// regular file
+	Mode  uint32
+	Owner string
+	Group string
+	MTime uint32
+	// Size is the size of the contents of regular files, the contents
+	// themselves are provided by Rpm.Contents when writing the package
+	Size int64
+	// Digest is the hex encoded sha256 of the contents of regular files
+	Digest string
+	// LinkTo is the target of a symbolic link
+	LinkTo string
+	// HardlinkTo is the path of another regular file of the package of
+	// which this file is a hardlink
+	HardlinkTo string
+	// device numbers of character and block devices
+	RDevMajor uint32
+	RDevMinor uint32
+	Flags     rpmpack.FileType
+}
+
+// RpmFileFromTar converts the tar entry `hdr` with the contents `contents` into
+// a file of the rpm with the path `name`. The contents are only read to
+// calculate their digest, so that they do not have to be held in memory.
+func RpmFileFromTar(name string, hdr *tar.Header, contents io.Reader) (RpmFile, error) {
+	f := RpmFile{
+		Name:  name,
+		Mode:  uint32(hdr.Mode) &^ modeTypeMask,
+		Owner: hdr.Uname,
+		Group: hdr.Gname,
+		MTime: uint32(hdr.ModTime.Unix()),
+	}
+
+	// layers usually only carry numeric ids, rpm needs names though
+	if f.Owner == "" {
+		f.Owner = idToName(hdr.Uid)
+	}
+	if f.Group == "" {
+		f.Group = idToName(hdr.Gid)
+	}
+
+	switch hdr.Typeflag {
+	case tar.TypeReg:
+		f.Mode |= modeReg
+		h := sha256.New()
+		n, err := io.Copy(h, contents)
+		if err != nil {
+			return RpmFile{}, fmt.Errorf("failed to read %s: %w", name, err)
+		}
+		if n != hdr.Size {
+			return RpmFile{}, fmt.Errorf("%s has %d bytes, expected %d", name, n, hdr.Size)
+		}
+		f.Size = n
+		f.Digest = fmt.Sprintf("%x", h.Sum(nil))
+	case tar.TypeLink:
+		f.Mode |= modeReg
+		f.HardlinkTo = EntryPath(hdr.Linkname)
+	case tar.TypeSymlink:
+		f.Mode |= modeSymlink
+		f.LinkTo = hdr.Linkname
+	case tar.TypeDir:
+		f.Mode |= modeDir
+	case tar.TypeChar, tar.TypeBlock:
+		if hdr.Typeflag == tar.TypeChar {
+			f.Mode |= modeChar
+		} else {
+			f.Mode |= modeBlock
+		}
+		f.RDevMajor = uint32(hdr.Devmajor)
+		f.RDevMinor = uint32(hdr.Devminor)
+	case tar.TypeFifo:
+		f.Mode |= modeFifo
+	default:
+		return RpmFile{}, fmt.Errorf("%s has the unsupported type %q", name, hdr.Typeflag)
+	}
+
+	return f, nil
+}
+
+// RpmFileFromDisk converts the regular file `path` into a file of the rpm with
+// the name `name` that is owned by root. Like RpmFileFromTar, it only reads the
+// file to calculate its digest.
+func RpmFileFromDisk(name, path string) (RpmFile, error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return RpmFile{}, err
+	}
+	defer fd.Close()
+
+	st, err := fd.Stat()
+	if err != nil {
+		return RpmFile{}, err
+	}
+	if !st.Mode().IsRegular() {
+		return RpmFile{}, fmt.Errorf("%s is not a regular file", path)
+	}
+
+	h := sha256.New()
+	n, err := io.Copy(h, fd)
+	if err != nil {
+		return RpmFile{}, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	return RpmFile{
+		Name:   name,
+		Mode:   modeReg | uint32(st.Mode().Perm()),
+		Owner:  "root",
+		Group:  "root",
+		MTime:  uint32(st.ModTime().Unix()),
+		Size:   n,
+		Digest: fmt.Sprintf("%x", h.Sum(nil)),
+	}, nil
+}
+
+// FileContents returns the contents of the files on disk `paths` by their
+// name in the rpm
+func FileContents(paths map[string]string) ContentsFunc {
+	return func(fn func(name string, contents io.Reader) error) error {
+		for _, name := range slices.Sorted(maps.Keys(paths)) {
+			fd, err := os.Open(paths[name])
+			if err != nil {
+				return err
+			}
+			err = fn(name, fd)
+			fd.Close()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func idToName(id int) string {
+	if id == 0 {
+		return "root"
+	}
+	return strconv.Itoa(id)
+}
+
+// ContentsFunc invokes `fn` with the contents of the regular files of a package
+// in an arbitrary order. Files that are not part of the package are skipped.
+type ContentsFunc func(fn func(name string, contents io.Reader) error) error
+
+// Rpm is a rpm package that can be written to disk.
+//
+// rpmpack is only used for the package's metadata and the dependency
+// relations, as it cannot represent hardlinks or device nodes and keeps the
+// whole package in memory.
+type Rpm struct {
+	rpmpack.RPMMetaData
+	// Dependencies are all dependencies of the package. The relations of
+	// RPMMetaData are ignored, NewRpm moves them here.
+	Dependencies RpmDependencies
+	// SourceRpm is the file name of the source rpm, it defaults to
+	// <name>-<version>-<release>.src.rpm
+	SourceRpm string
+	// SourcePackage marks the package as source rpm, the names of its
+	// files are relative instead of absolute paths
+	SourcePackage bool
+	// Contents provides the contents of the regular files while writing
+	// the package
+	Contents ContentsFunc
+	// Changelog are the entries of the %changelog, newest first
+	Changelog []ChangelogEntry
+
+	files      map[string]RpmFile
+	customTags *Header
+}
+
+// ChangelogEntry is an entry of the %changelog of a package
+type ChangelogEntry struct {
+	Time time.Time
+	// Name is the author and the version, e.g.
+	// "Joe Packager <joe@example.com> - 1.0-1"
+	Name string
+	Text string
+}
+
+// NewRpm creates a rpm package with the metadata `m`
+func NewRpm(m rpmpack.RPMMetaData) (*Rpm, error) {
+	if m.OS == "" {
+		m.OS = "linux"
+	}
+	if m.Arch == "" {
+		m.Arch = "noarch"
+	}
+	// fail early on invalid compressors
+	if _, _, _, err := newPayloadCompressor(m.Compressor, io.Discard); err != nil {
+		return nil, err
+	}
+
+	r := &Rpm{
+		RPMMetaData: m,
+		files:       make(map[string]RpmFile),
+		customTags:  NewHeader(),
+	}
+	r.Dependencies.Append(RpmDependencies{
+		Provides:   m.Provides,
+		Requires:   m.Requires,
+		Conflicts:  m.Conflicts,
+		Obsoletes:  m.Obsoletes,
+		Recommends: m.Recommends,
+		Suggests:   m.Suggests,
+	})
+	r.Provides, r.Requires, r.Conflicts, r.Obsoletes, r.Recommends, r.Suggests = nil, nil, nil, nil, nil, nil
+
+	return r, nil
+}
+
+// FullVersion returns the [epoch:]version[-release] of the package
+func (r *Rpm) FullVersion() string {
+	v := r.Version
+	if r.Epoch != 0 && r.Epoch != rpmpack.NoEpoch {
+		v = fmt.Sprintf("%d:%s", r.Epoch, v)
+	}
+	if r.Release != "" {
+		v += "-" + r.Release
+	}
+	return v
+}
+
+// AddFile adds `f` to the package, replacing a previous file with the same
+// name
+func (r *Rpm) AddFile(f RpmFile) {
+	// rpm does not allow the root directory to be included
+	if f.Name == "/" {
+		return
+	}
+	r.files[f.Name] = f
+}
+
+// SetTag sets a tag in the header, overriding the value that would be
+// generated
+func (r *Rpm) SetTag(tag int, e HeaderEntry) {
+	r.customTags.Set(tag, e)
+}
+
+// payloadFile is a file of the package with all information that is derived
+// when writing the package
+type payloadFile struct {
+	RpmFile
+	size  int64
+	inode uint32
+	// number of hardlinks of the file
+	nlink uint32
+	// only the last file of a hardlink set carries the contents
+	hasContent bool
+	// name of the file whose contents this file has, differs for hardlinks
+	contentsFrom string
+}
+
+// payloadFiles returns all files sorted by their name with their hardlinks
+// resolved
+func (r *Rpm) payloadFiles() ([]*payloadFile, error) {
+	names := make([]string, 0, len(r.files))
+	for n := range r.files {
+		names = append(names, n)
+	}
+	slices.Sort(names)
+
+	// resolve each hardlink to the file with the contents
+	linkTarget := func(f RpmFile) (RpmFile, error) {
+		seen := map[string]bool{f.Name: true}
+		for f.HardlinkTo != "" {
+			target, ok := r.files[f.HardlinkTo]
+			if !ok {
+				return RpmFile{}, fmt.Errorf("%s is a hardlink to %s, which is not part of the package", f.Name, f.HardlinkTo)
+			}
+			if seen[target.Name] {
+				return RpmFile{}, fmt.Errorf("%s is part of a hardlink loop", f.Name)
+			}
+			seen[target.Name] = true
+			f = target
+		}
+		if f.Mode&modeTypeMask != modeReg {
+			return RpmFile{}, fmt.Errorf("%s is a hardlink to a file that is not a regular file", f.Name)
+		}
+		return f, nil
+	}
+
+	files := make([]*payloadFile, len(names))
+	sets := make(map[string][]*payloadFile)
+	for i, n := range names {
+		f := &payloadFile{RpmFile: r.files[n], inode: uint32(i + 1), nlink: 1, hasContent: true}
+		files[i] = f
+
+		switch f.Mode & modeTypeMask {
+		case modeReg:
+			target, err := linkTarget(f.RpmFile)
+			if err != nil {
+				return nil, err
+			}
+			f.size = target.Size
+			f.Digest = target.Digest
+			f.contentsFrom = target.Name
+			sets[target.Name] = append(sets[target.Name], f)
+		case modeSymlink:
+			f.size = int64(len(f.LinkTo))
+		case modeDir:
+			f.size = 4096
+		case modeChar, modeBlock, modeFifo:
+		default:
+			return nil, fmt.Errorf("%s has the unsupported mode %o", f.Name, f.Mode)
+		}
+	}
+
+	// all files of a hardlink set share the inode, rpm expects the contents
+	// on the last file of the set
+	for _, set := range sets {
+		for i, f := range set {
+			f.inode = set[0].inode
+			f.nlink = uint32(len(set))
+			f.hasContent = i == len(set)-1
+		}
+	}
+
+	return files, nil
+}
+
+// makedev encodes a device number like glibc's makedev(3) in 32 bits
+func makedev(major, minor uint32) uint32 {
+	return minor&0xff | (major&0xfff)<<8 | (minor&^0xff)<<12
+}
+
+// devMajor and devMinor decode a device number of makedev
+func devMajor(dev uint32) uint32 { return (dev >> 8) & 0xfff }
+func devMinor(dev uint32) uint32 { return dev&0xff | (dev>>12)&^0xff }
+
+// maxFileSize is the largest file that can be stored in the newc cpio format,
+// larger files need rpm's stripped format. It is a variable so that the tests
+// do not need files of 4GiB.
+var maxFileSize int64 = math.MaxUint32
+
+// needsLargeFiles returns true if `files` can only be stored in rpm's stripped
+// payload format with 64bit file sizes
+func needsLargeFiles(files []*payloadFile) bool {
+	return slices.ContainsFunc(files, func(f *payloadFile) bool { return f.size > maxFileSize })
+}
+
+// rpmlibRequires returns the rpmlib() features that the package needs
+func (r *Rpm) rpmlibRequires(compressor string, largeFiles bool) (rpmpack.Relations, error) {
+	features := []string{
+		"rpmlib(CompressedFileNames) <= 3.0.4-1",
+		"rpmlib(FileDigests) <= 4.6.0-1",
+	}
+	if !r.SourcePackage {
+		features = append(features, "rpmlib(PayloadFilesHavePrefix) <= 4.0-1")
+	}
+	switch compressor {
+	case "xz":
+		features = append(features, "rpmlib(PayloadIsXz) <= 5.2-1")
+	case "zstd":
+		features = append(features, "rpmlib(PayloadIsZstd) <= 5.4.18-1")
+	}
+	if largeFiles {
+		features = append(features, "rpmlib(LargeFiles) <= 4.12.0-1")
+	}
+
+	var rels rpmpack.Relations
+	for _, f := range features {
+		rel, err := NewRelationWithSense(f, SenseRpmlib)
+		if err != nil {
+			return nil, err
+		}
+		rels = append(rels, rel)
+	}
+	return rels, nil
+}
+
+// writePayload writes the compressed cpio archive of `files` into `w` and
+// returns the name of the compressor, its level and the size of the
+// uncompressed archive.
+//
+// The contents of regular files are streamed from r.Contents after all other
+// files, so that at most one file is in flight at any time. This also puts the
+// contents of a hardlink set behind all of its other files, as rpm expects it.
+func (r *Rpm) writePayload(w io.Writer, files []*payloadFile, largeFiles bool) (compressor string, level string, archiveSize int64, err error) {
+	z, compressor, level, err := newPayloadCompressor(r.Compressor, w)
+	if err != nil {
+		return "", "", 0, err
+	}
+
+	counter := &countingWriter{w: z}
+	cpio := newCpioWriter(counter)
+	writeHeader := func(fx int, f *payloadFile, size int64) error {
+		var err error
+		if largeFiles {
+			err = cpio.WriteStrippedHeader(uint32(fx), size)
+		} else {
+			err = cpio.WriteHeader(&CpioHeader{
+				Name:      payloadName(f.Name),
+				Inode:     f.inode,
+				Mode:      f.Mode,
+				Nlink:     f.nlink,
+				MTime:     f.MTime,
+				Size:      size,
+				RDevMajor: f.RDevMajor,
+				RDevMinor: f.RDevMinor,
+			})
+		}
+		if err != nil {
+			return fmt.Errorf("failed to write payload header of %s: %w", f.Name, err)
+		}
+		return nil
+	}
+
+	// file index of the files whose contents are still missing by the name
+	// of the file providing them
+	pending := make(map[string]int)
+	for i, f := range files {
+		// ghost files are not part of the payload
+		if f.Flags&rpmpack.GhostFile != 0 {
+			continue
+		}
+
+		var body []byte
+		switch f.Mode & modeTypeMask {
+		case modeReg:
+			if f.hasContent && f.size > 0 {
+				pending[f.contentsFrom] = i
+				continue
+			}
+		case modeSymlink:
+			body = []byte(f.LinkTo)
+		}
+
+		if err := writeHeader(i, f, int64(len(body))); err != nil {
+			return "", "", 0, err
+		}
+		if _, err := cpio.Write(body); err != nil {
+			return "", "", 0, fmt.Errorf("failed to write payload of %s: %w", f.Name, err)
+		}
+	}
+
+	if len(pending) > 0 {
+		if r.Contents == nil {
+			return "", "", 0, errors.New("the package has no source for the contents of its files")
+		}
+		err := r.Contents(func(name string, contents io.Reader) error {
+			i, ok := pending[name]
+			if !ok {
+				return nil
+			}
+			delete(pending, name)
+
+			f := files[i]
+			if err := writeHeader(i, f, f.size); err != nil {
+				return err
+			}
+			h := sha256.New()
+			n, err := io.Copy(io.MultiWriter(cpio, h), io.LimitReader(contents, f.size))
+			if err != nil {
+				return fmt.Errorf("failed to write payload of %s: %w", f.Name, err)
+			}
+			// the contents are read twice, once for the header and once
+			// for the payload, both must match
+			if n != f.size || (f.Digest != "" && fmt.Sprintf("%x", h.Sum(nil)) != f.Digest) {
+				return fmt.Errorf("the contents of %s changed while writing the package", name)
+			}
+			return nil
+		})
+		if err != nil {
+			return "", "", 0, err
+		}
+	}
+	if len(pending) > 0 {
+		missing := make([]string, 0, len(pending))
+		for name := range pending {
+			missing = append(missing, name)
+		}
+		slices.Sort(missing)
+		return "", "", 0, fmt.Errorf("the contents of %s are missing", strings.Join(missing, ", "))
+	}
+
+	if err := cpio.Close(); err != nil {
+		return "", "", 0, fmt.Errorf("failed to close cpio payload: %w", err)
+	}
+	if err := z.Close(); err != nil {
+		return "", "", 0, fmt.Errorf("failed to close %s payload: %w", compressor, err)
+	}
+
+	return compressor, level, counter.n, nil
+}
+
+// payloadName returns the name of the file `name` in the cpio archive, rpm
+// expects absolute paths to be prefixed with a dot
+func payloadName(name string) string {
+	if strings.HasPrefix(name, "/") {
+		return "." + name
+	}
+	return name
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	c.n += int64(n)
+	return n, err
+}
+
+// addFileTags adds the tags describing the files to the header
+func addFileTags(h *Header, files []*payloadFile, largeFiles bool) {
+	var (
+		dirs       []string
+		dirIndexes = make(map[string]uint32)
+	)
+
+	n := len(files)
+	basenames := make([]string, n)
+	dirindexes := make([]uint32, n)
+	sizes := make([]uint64, n)
+	modes := make([]uint16, n)
+	rdevs := make([]uint16, n)
+	mtimes := make([]uint32, n)
+	digests := make([]string, n)
+	linktos := make([]string, n)
+	flags := make([]uint32, n)
+	owners := make([]string, n)
+	groups := make([]string, n)
+	verifyFlags := make([]uint32, n)
+	devices := make([]uint32, n)
+	inodes := make([]uint32, n)
+	langs := make([]string, n)
+
+	for i, f := range files {
+		dir, base := path.Split(f.Name)
+		idx, ok := dirIndexes[dir]
+		if !ok {
+			idx = uint32(len(dirs))
+			dirIndexes[dir] = idx
+			dirs = append(dirs, dir)
+		}
+
+		basenames[i] = base
+		dirindexes[i] = idx
+		sizes[i] = uint64(f.size)
+		modes[i] = uint16(f.Mode)
+		// rpm's rpm_rdev_t only has 16 bits, rpmbuild truncates larger
+		// device numbers the same way
+		rdevs[i] = uint16(makedev(f.RDevMajor, f.RDevMinor))
+		mtimes[i] = f.MTime
+		digests[i] = f.Digest
+		linktos[i] = f.LinkTo
+		flags[i] = uint32(f.Flags)
+		owners[i] = f.Owner
+		groups[i] = f.Group
+		verifyFlags[i] = 0xffffffff
+		devices[i] = 1
+		inodes[i] = f.inode
+	}
+
+	h.Set(TagBaseNames, EntryStringSlice(basenames))
+	h.Set(TagDirIndexes, EntryInt32(dirindexes))
+	h.Set(TagDirNames, EntryStringSlice(dirs))
+	if largeFiles {
+		h.Set(TagLongFileSizes, EntryInt64(sizes))
+	} else {
+		small := make([]uint32, n)
+		for i, size := range sizes {
+			small[i] = uint32(size)
+		}
+		h.Set(TagFileSizes, EntryInt32(small))
+	}
+	h.Set(TagFileModes, EntryInt16(modes))
+	h.Set(TagFileRDevs, EntryInt16(rdevs))
+	h.Set(TagFileMTimes, EntryInt32(mtimes))
+	h.Set(TagFileDigests, EntryStringSlice(digests))
+	h.Set(TagFileLinkTos, EntryStringSlice(linktos))
+	h.Set(TagFileFlags, EntryInt32(flags))
+	h.Set(TagFileUserName, EntryStringSlice(owners))
+	h.Set(TagFileGroupName, EntryStringSlice(groups))
+	h.Set(TagFileVerifyFlags, EntryInt32(verifyFlags))
+	h.Set(TagFileDevices, EntryInt32(devices))
+	h.Set(TagFileINodes, EntryInt32(inodes))
+	h.Set(TagFileLangs, EntryStringSlice(langs))
+	h.Set(TagFileDigestAlgo, EntryInt32([]uint32{hashAlgoSHA256}))
+}
+
+// addRelationTags adds the relations `rels` to the header
+func addRelationTags(h *Header, rels rpmpack.Relations, nameTag, versionTag, flagsTag int) {
+	if len(rels) == 0 {
+		return
+	}
+
+	names := make([]string, len(rels))
+	versions := make([]string, len(rels))
+	flags := make([]uint32, len(rels))
+	for i, r := range rels {
+		names[i] = r.Name
+		versions[i] = r.Version
+		flags[i] = uint32(r.Sense)
+	}
+
+	h.Set(nameTag, EntryStringSlice(names))
+	h.Set(versionTag, EntryStringSlice(versions))
+	h.Set(flagsTag, EntryInt32(flags))
+}
+
+// Write writes the whole package into `w`.
+//
+// The payload has to be assembled before the headers, which contain its digest
+// and size, so it is buffered in a temporary file.
+func (r *Rpm) Write(w io.Writer) error {
+	files, err := r.payloadFiles()
+	if err != nil {
+		return err
+	}
+	largeFiles := needsLargeFiles(files)
+
+	payload, err := os.CreateTemp("", "roci-payload-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(payload.Name())
+	defer payload.Close()
+
+	payloadDigest := sha256.New()
+	payloadSize := &countingWriter{w: io.MultiWriter(payload, payloadDigest)}
+	compressor, level, archiveSize, err := r.writePayload(payloadSize, files, largeFiles)
+	if err != nil {
+		return err
+	}
+
+	h := NewHeader()
+	h.Set(TagHeaderI18NTable, EntryStringSlice([]string{"C"}))
+	h.Set(TagName, EntryString(r.Name))
+	h.Set(TagVersion, EntryString(r.Version))
+	h.Set(TagRelease, EntryString(r.Release))
+	if r.Epoch != rpmpack.NoEpoch {
+		h.Set(TagEpoch, EntryInt32([]uint32{r.Epoch}))
+	}
+	h.Set(TagSummary, EntryI18NString(r.Summary))
+	h.Set(TagDescription, EntryI18NString(r.Description))
+	if !r.BuildTime.IsZero() {
+		h.Set(TagBuildTime, EntryInt32([]uint32{uint32(r.BuildTime.Unix())}))
+	}
+	h.Set(TagBuildHost, EntryString(r.BuildHost))
+	if r.Vendor != "" {
+		h.Set(TagVendor, EntryString(r.Vendor))
+	}
+	h.Set(TagLicense, EntryString(r.Licence))
+	if r.Packager != "" {
+		h.Set(TagPackager, EntryString(r.Packager))
+	}
+	if r.Group != "" {
+		h.Set(TagGroup, EntryI18NString(r.Group))
+	}
+	if r.URL != "" {
+		h.Set(TagURL, EntryString(r.URL))
+	}
+	h.Set(TagOS, EntryString(r.OS))
+	h.Set(TagArch, EntryString(r.Arch))
+	if len(r.Prefixes) > 0 {
+		h.Set(TagPrefixes, EntryStringSlice(r.Prefixes))
+	}
+
+	if len(r.Changelog) > 0 {
+		times := make([]uint32, len(r.Changelog))
+		names := make([]string, len(r.Changelog))
+		texts := make([]string, len(r.Changelog))
+		for i, e := range r.Changelog {
+			times[i] = uint32(e.Time.Unix())
+			names[i] = e.Name
+			texts[i] = e.Text
+		}
+		h.Set(TagChangelogTime, EntryInt32(times))
+		h.Set(TagChangelogName, EntryStringSlice(names))
+		h.Set(TagChangelogText, EntryStringSlice(texts))
+	}
+
+	if r.SourcePackage {
+		h.Set(TagSourcePackage, EntryInt32([]uint32{1}))
+	} else {
+		sourceRpm := r.SourceRpm
+		if sourceRpm == "" {
+			sourceRpm = fmt.Sprintf("%s-%s.src.rpm", r.Name, r.FullVersion())
+		}
+		h.Set(TagSourceRPM, EntryString(sourceRpm))
+	}
+
+	var installSize int64
+	for _, f := range files {
+		if f.Mode&modeTypeMask != modeDir && f.hasContent {
+			installSize += f.size
+		}
+	}
+	if installSize > math.MaxUint32 {
+		h.Set(TagLongSize, EntryInt64([]uint64{uint64(installSize)}))
+	} else {
+		h.Set(TagSize, EntryInt32([]uint32{uint32(installSize)}))
+	}
+	if len(files) > 0 {
+		addFileTags(h, files, largeFiles)
+	}
+
+	h.Set(TagPayloadFormat, EntryString("cpio"))
+	h.Set(TagPayloadCompressor, EntryString(compressor))
+	h.Set(TagPayloadFlags, EntryString(level))
+	h.Set(TagPayloadDigest, EntryStringSlice([]string{fmt.Sprintf("%x", payloadDigest.Sum(nil))}))
+	h.Set(TagPayloadDigestAlgo, EntryInt32([]uint32{hashAlgoSHA256}))
+
+	// a binary package must provide itself
+	deps := r.Dependencies
+	if !r.SourcePackage {
+		selfProvide := &rpmpack.Relation{Name: r.Name, Version: r.FullVersion(), Sense: rpmpack.SenseEqual}
+		deps.Provides = append(rpmpack.Relations{selfProvide}, deps.Provides...)
+	}
+	rpmlib, err := r.rpmlibRequires(compressor, largeFiles)
+	if err != nil {
+		return err
+	}
+	deps.Append(RpmDependencies{Requires: rpmlib})
+
+	addRelationTags(h, deps.Provides, TagProvideName, TagProvideVersion, TagProvideFlags)
+	addRelationTags(h, deps.Requires, TagRequireName, TagRequireVersion, TagRequireFlags)
+	addRelationTags(h, deps.Conflicts, TagConflictName, TagConflictVersion, TagConflictFlags)
+	addRelationTags(h, deps.Obsoletes, TagObsoleteName, TagObsoleteVersion, TagObsoleteFlags)
+	addRelationTags(h, deps.Recommends, TagRecommendName, TagRecommendVersion, TagRecommendFlags)
+	addRelationTags(h, deps.Suggests, TagSuggestName, TagSuggestVersion, TagSuggestFlags)
+	addRelationTags(h, deps.Supplements, TagSupplementName, TagSupplementVersion, TagSupplementFlags)
+	addRelationTags(h, deps.Enhances, TagEnhanceName, TagEnhanceVersion, TagEnhanceFlags)
+	addRelationTags(h, deps.OrderWithRequires, TagOrderName, TagOrderVersion, TagOrderFlags)
+
+	// custom tags must be added last, as they override the generated ones
+	for _, tag := range r.customTags.Tags() {
+		e, _ := r.customTags.Get(tag)
+		h.Set(tag, e)
+	}
+	hb := h.Bytes(regionImmutable)
+
+	sig := NewHeader()
+	if size := int64(len(hb)) + payloadSize.n; size > math.MaxUint32 {
+		sig.Set(SigTagLongSize, EntryInt64([]uint64{uint64(size)}))
+	} else {
+		sig.Set(SigTagSize, EntryInt32([]uint32{uint32(size)}))
+	}
+	sig.Set(SigTagSHA256, EntryString(fmt.Sprintf("%x", sha256.Sum256(hb))))
+	if archiveSize > math.MaxUint32 {
+		sig.Set(SigTagLongArchiveSize, EntryInt64([]uint64{uint64(archiveSize)}))
+	} else {
+		sig.Set(SigTagPayloadSize, EntryInt32([]uint32{uint32(archiveSize)}))
+	}
+	sb := sig.Bytes(regionSignatures)
+
+	for _, b := range [][]byte{
+		lead(r.Name, r.FullVersion(), r.SourcePackage),
+		sb,
+		// the signature header is padded to 8 bytes
+		make([]byte, (8-len(sb)%8)%8),
+		hb,
+	} {
+		if _, err := w.Write(b); err != nil {
+			return fmt.Errorf("failed to write rpm: %w", err)
+		}
+	}
+
+	if _, err := payload.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, payload); err != nil {
+		return fmt.Errorf("failed to write rpm: %w", err)
+	}
+
+	return nil
+}
+
+// lead returns the (obsolete) lead of a rpm
+func lead(name, fullVersion string, source bool) []byte {
+	b := []byte{
+		// magic
+		0xed, 0xab, 0xee, 0xdb,
+		// version 3.0
+		0x03, 0x00,
+		// type binary or source
+		0x00, 0x00,
+		// architecture
+		0x00, 0x01,
+	}
+	if source {
+		b[7] = 0x01
+	}
+
+	n := []byte(name + "-" + fullVersion)
+	if len(n) > 65 {
+		n = n[:65]
+	}
+	b = append(b, n...)
+	b = append(b, make([]byte, 66-len(n))...)
+
+	// os linux and signature type header style
+	b = append(b, 0x00, 0x01, 0x00, 0x05)
+	// reserved
+	return append(b, make([]byte, 16)...)
+}
+
+var errNoRpm = errors.New("not a rpm package")
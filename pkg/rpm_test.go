@@ -0,0 +1,597 @@
+package roci
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/rpmpack"
+)
+
+// walkLayer invokes `fn` on every entry of the tar archive `layer`
+func walkLayer(layer []byte, fn func(hdr *tar.Header, contents io.Reader) error) error {
+	tarRdr := tar.NewReader(bytes.NewReader(layer))
+	for {
+		hdr, err := tarRdr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(hdr, tarRdr); err != nil {
+			return err
+		}
+	}
+}
+
+// rpmFromLayer converts all entries of the tar archive `layer` into a rpm like
+// roci does it with the stage images
+func rpmFromLayer(t *testing.T, layer []byte, m rpmpack.RPMMetaData) *Rpm {
+	t.Helper()
+
+	rpm, err := NewRpm(m)
+	if err != nil {
+		t.Fatalf("failed to create rpm: %v", err)
+	}
+
+	err = walkLayer(layer, func(hdr *tar.Header, contents io.Reader) error {
+		f, err := RpmFileFromTar(EntryPath(hdr.Name), hdr, contents)
+		if err != nil {
+			return err
+		}
+		rpm.AddFile(f)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to convert layer: %v", err)
+	}
+
+	rpm.Contents = func(fn func(name string, contents io.Reader) error) error {
+		return walkLayer(layer, func(hdr *tar.Header, contents io.Reader) error {
+			if hdr.Typeflag != tar.TypeReg {
+				return nil
+			}
+			return fn(EntryPath(hdr.Name), contents)
+		})
+	}
+	return rpm
+}
+
+func TestRpmRoundTrip(t *testing.T) {
+	t.Run("newc", func(t *testing.T) { testRpmRoundTrip(t, false) })
+	t.Run("stripped", func(t *testing.T) {
+		// pretend that the larger files exceed 4GiB
+		defer func(size int64) { maxFileSize = size }(maxFileSize)
+		maxFileSize = 10
+		testRpmRoundTrip(t, true)
+	})
+}
+
+func testRpmRoundTrip(t *testing.T, largeFiles bool) {
+	mtime := time.Unix(1700000000, 0)
+	layer := makeLayer(t,
+		tarEntry{hdr: tar.Header{Name: "usr/", Typeflag: tar.TypeDir, Mode: 0o755, ModTime: mtime}},
+		tarEntry{hdr: tar.Header{Name: "usr/bin/", Typeflag: tar.TypeDir, Mode: 0o755, ModTime: mtime}},
+		tarEntry{hdr: tar.Header{Name: "usr/bin/poke", Typeflag: tar.TypeReg, Mode: 0o755, ModTime: mtime}, body: "#!/bin/sh\necho poke\n"},
+		tarEntry{hdr: tar.Header{Name: "usr/bin/poke-link", Typeflag: tar.TypeLink, Linkname: "usr/bin/poke", Mode: 0o755, ModTime: mtime}},
+		tarEntry{hdr: tar.Header{Name: "usr/bin/apoke", Typeflag: tar.TypeLink, Linkname: "usr/bin/poke", Mode: 0o755, ModTime: mtime}},
+		tarEntry{hdr: tar.Header{Name: "usr/bin/pk", Typeflag: tar.TypeSymlink, Linkname: "poke", Mode: 0o777, ModTime: mtime}},
+		tarEntry{hdr: tar.Header{Name: "usr/share/", Typeflag: tar.TypeDir, Mode: 0o700, Uid: 1000, Gid: 1000, ModTime: mtime}},
+		tarEntry{hdr: tar.Header{Name: "usr/share/doc", Typeflag: tar.TypeReg, Mode: 0o644, Uname: "poke", Gname: "users", ModTime: mtime}, body: "docs"},
+		tarEntry{hdr: tar.Header{Name: "dev/null", Typeflag: tar.TypeChar, Mode: 0o666, Devmajor: 1, Devminor: 3, ModTime: mtime}},
+		tarEntry{hdr: tar.Header{Name: "dev/loop0", Typeflag: tar.TypeBlock, Mode: 0o660, Devmajor: 7, Devminor: 0, ModTime: mtime}},
+		tarEntry{hdr: tar.Header{Name: "run/fifo", Typeflag: tar.TypeFifo, Mode: 0o600, ModTime: mtime}},
+	)
+	expectedLayer := bytes.NewReader(layer.Bytes())
+
+	rpm := rpmFromLayer(t, layer.Bytes(), rpmpack.RPMMetaData{Name: "poke", Version: "4.3", Release: "1", Compressor: "zstd"})
+	buf := &bytes.Buffer{}
+	if err := rpm.Write(buf); err != nil {
+		t.Fatalf("failed to write rpm: %v", err)
+	}
+
+	rdr, err := NewRpmReader(buf)
+	if err != nil {
+		t.Fatalf("failed to read rpm: %v", err)
+	}
+	defer rdr.Close()
+
+	if name, _ := rdr.Header.Get(TagName); name.String() != "poke" {
+		t.Errorf("expected name poke, got %q", name.String())
+	}
+
+	// collect the header's view of the files
+	basenames, _ := rdr.Header.Get(TagBaseNames)
+	dirnames, _ := rdr.Header.Get(TagDirNames)
+	dirindexes, _ := rdr.Header.Get(TagDirIndexes)
+	modes, _ := rdr.Header.Get(TagFileModes)
+	linktos, _ := rdr.Header.Get(TagFileLinkTos)
+	inodes, _ := rdr.Header.Get(TagFileINodes)
+	rdevs, _ := rdr.Header.Get(TagFileRDevs)
+	sizes, _ := rdr.Header.Get(TagFileSizes)
+	if largeFiles {
+		sizes, _ = rdr.Header.Get(TagLongFileSizes)
+	}
+	owners, _ := rdr.Header.Get(TagFileUserName)
+
+	type headerFile struct {
+		mode, inode, rdev, size uint64
+		linkto, owner           string
+	}
+	headerFiles := make(map[string]headerFile)
+	var headerOrder []string
+	for i, base := range basenames.Strings() {
+		name := dirnames.Strings()[dirindexes.Uints()[i]] + base
+		headerOrder = append(headerOrder, name)
+		headerFiles[name] = headerFile{
+			mode:   modes.Uints()[i],
+			inode:  inodes.Uints()[i],
+			rdev:   rdevs.Uints()[i],
+			size:   sizes.Uints()[i],
+			linkto: linktos.Strings()[i],
+			owner:  owners.Strings()[i],
+		}
+	}
+	if !slices.IsSorted(headerOrder) {
+		t.Errorf("expected the files in the header to be sorted, got %v", headerOrder)
+	}
+
+	requires, _ := rdr.Header.Get(TagRequireName)
+	if slices.Contains(requires.Strings(), "rpmlib(LargeFiles)") != largeFiles {
+		t.Errorf("expected rpmlib(LargeFiles) only with large files, got %v", requires.Strings())
+	}
+
+	payload, err := rdr.Payload()
+	if err != nil {
+		t.Fatalf("failed to read payload: %v", err)
+	}
+	cpioFiles := make(map[string]*CpioHeader)
+	cpioBodies := make(map[string]string)
+	for {
+		hdr, err := payload.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("failed to read payload: %v", err)
+		}
+		body, err := io.ReadAll(payload)
+		if err != nil {
+			t.Fatalf("failed to read payload: %v", err)
+		}
+		cpioFiles[hdr.Name] = hdr
+		cpioBodies[hdr.Name] = string(body)
+	}
+
+	// now compare every entry of the layer with the rpm
+	tarRdr := tar.NewReader(expectedLayer)
+	for {
+		hdr, err := tarRdr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("failed to read layer: %v", err)
+		}
+		body, _ := io.ReadAll(tarRdr)
+
+		name := EntryPath(hdr.Name)
+		hf, ok := headerFiles[name]
+		if !ok {
+			t.Errorf("%s is missing in the header", name)
+			continue
+		}
+		cf, ok := cpioFiles["."+name]
+		if !ok {
+			t.Errorf("%s is missing in the payload", name)
+			continue
+		}
+
+		if uint64(cf.Mode) != hf.mode || hf.mode&0o7777 != uint64(hdr.Mode) {
+			t.Errorf("%s: mode mismatch, tar %o, header %o, payload %o", name, hdr.Mode, hf.mode, cf.Mode)
+		}
+		if cf.MTime != uint32(mtime.Unix()) {
+			t.Errorf("%s: expected mtime %d, got %d", name, mtime.Unix(), cf.MTime)
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeReg:
+			if hf.mode&modeTypeMask != modeReg || hf.size != uint64(len(body)) {
+				t.Errorf("%s: expected a regular file of size %d, got %o with %d", name, len(body), hf.mode, hf.size)
+			}
+		case tar.TypeLink:
+			target := headerFiles[EntryPath(hdr.Linkname)]
+			if hf.inode != target.inode || hf.mode&modeTypeMask != modeReg || hf.size != target.size {
+				t.Errorf("%s: expected a hardlink of %s, got %+v", name, hdr.Linkname, hf)
+			}
+			if cf.Nlink != 3 {
+				t.Errorf("%s: expected 3 links, got %d", name, cf.Nlink)
+			}
+		case tar.TypeSymlink:
+			if hf.mode&modeTypeMask != modeSymlink || hf.linkto != hdr.Linkname || cpioBodies["."+name] != hdr.Linkname {
+				t.Errorf("%s: expected a symlink to %s, got %+v", name, hdr.Linkname, hf)
+			}
+		case tar.TypeDir:
+			if hf.mode&modeTypeMask != modeDir {
+				t.Errorf("%s: expected a directory, got %o", name, hf.mode)
+			}
+		case tar.TypeChar, tar.TypeBlock:
+			if hf.rdev != uint64(hdr.Devmajor<<8|hdr.Devminor) || cf.RDevMajor != uint32(hdr.Devmajor) || cf.RDevMinor != uint32(hdr.Devminor) {
+				t.Errorf("%s: expected device %d:%d, got %#x", name, hdr.Devmajor, hdr.Devminor, hf.rdev)
+			}
+		case tar.TypeFifo:
+			if hf.mode&modeTypeMask != modeFifo {
+				t.Errorf("%s: expected a fifo, got %o", name, hf.mode)
+			}
+		}
+	}
+
+	// the contents of a hardlink set are only stored in its last file
+	if cpioBodies["./usr/bin/poke-link"] != "#!/bin/sh\necho poke\n" {
+		t.Errorf("expected the contents in the last file of the hardlink set, got %q", cpioBodies["./usr/bin/poke-link"])
+	}
+	if cpioBodies["./usr/bin/poke"] != "" || cpioBodies["./usr/bin/apoke"] != "" {
+		t.Error("expected no contents in the other files of the hardlink set")
+	}
+
+	if headerFiles["/usr/share/doc"].owner != "poke" || headerFiles["/usr/share"].owner != "1000" || headerFiles["/usr/bin/poke"].owner != "root" {
+		t.Errorf("unexpected file owners: %v", owners.Strings())
+	}
+}
+
+func TestRpmDanglingHardlink(t *testing.T) {
+	rpm, err := NewRpm(rpmpack.RPMMetaData{Name: "foo", Version: "1"})
+	if err != nil {
+		t.Fatalf("failed to create rpm: %v", err)
+	}
+	rpm.AddFile(RpmFile{Name: "/usr/bin/foo", Mode: modeReg | 0o755, HardlinkTo: "/usr/bin/bar"})
+
+	if err := rpm.Write(io.Discard); err == nil {
+		t.Error("expected an error for a hardlink to a file outside of the package")
+	}
+}
+
+func TestRpmContentsChanged(t *testing.T) {
+	layer := makeLayer(t,
+		tarEntry{hdr: tar.Header{Name: "usr/bin/foo", Typeflag: tar.TypeReg, Mode: 0o755}, body: "foo"},
+	)
+	rpm := rpmFromLayer(t, layer.Bytes(), rpmpack.RPMMetaData{Name: "foo", Version: "1"})
+	rpm.Contents = func(fn func(name string, contents io.Reader) error) error {
+		return fn("/usr/bin/foo", strings.NewReader("bar"))
+	}
+
+	if err := rpm.Write(io.Discard); err == nil {
+		t.Error("expected an error for contents that changed after adding the file")
+	}
+
+	rpm.Contents = func(fn func(name string, contents io.Reader) error) error { return nil }
+	if err := rpm.Write(io.Discard); err == nil {
+		t.Error("expected an error for missing contents")
+	}
+}
+
+func TestRpmReproducible(t *testing.T) {
+	mtime := time.Unix(1700000000, 0)
+	layer := makeLayer(t,
+		tarEntry{hdr: tar.Header{Name: "usr/bin/foo", Typeflag: tar.TypeReg, Mode: 0o755, ModTime: mtime}, body: "foo"},
+		tarEntry{hdr: tar.Header{Name: "usr/bin/bar", Typeflag: tar.TypeReg, Mode: 0o755, ModTime: mtime}, body: "bar"},
+		tarEntry{hdr: tar.Header{Name: "usr/bin/baz", Typeflag: tar.TypeSymlink, Linkname: "foo", ModTime: mtime}},
+	).Bytes()
+
+	var rpms [2][]byte
+	for i := range rpms {
+		rpm := rpmFromLayer(t, layer, rpmpack.RPMMetaData{Name: "foo", Version: "1", Compressor: "zstd", BuildTime: mtime})
+		rpm.Dependencies.Append(RpmDependencies{Requires: rpmpack.Relations{{Name: "bash"}, {Name: "glibc"}}})
+		buf := &bytes.Buffer{}
+		if err := rpm.Write(buf); err != nil {
+			t.Fatalf("failed to write rpm: %v", err)
+		}
+		rpms[i] = buf.Bytes()
+	}
+
+	if !bytes.Equal(rpms[0], rpms[1]) {
+		t.Error("expected two rpms from the same inputs to be identical")
+	}
+}
+
+// writeSourceRpm writes a source rpm with the files `files` (name to
+// contents)
+func writeSourceRpm(t *testing.T, files map[string]string) *bytes.Buffer {
+	t.Helper()
+
+	// the names may be anything, so they are not used on disk
+	dir := t.TempDir()
+	paths := make(map[string]string)
+	for name, contents := range files {
+		p := filepath.Join(dir, strconv.Itoa(len(paths)))
+		if err := os.WriteFile(p, []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		paths[name] = p
+	}
+
+	rpm, err := NewRpm(rpmpack.RPMMetaData{Name: "poke", Version: "4.3", Release: "1"})
+	if err != nil {
+		t.Fatalf("failed to create rpm: %v", err)
+	}
+	rpm.SourcePackage = true
+	for name, p := range paths {
+		f, err := RpmFileFromDisk(name, p)
+		if err != nil {
+			t.Fatalf("failed to add %s: %v", name, err)
+		}
+		rpm.AddFile(f)
+	}
+	rpm.Contents = FileContents(paths)
+
+	buf := &bytes.Buffer{}
+	if err := rpm.Write(buf); err != nil {
+		t.Fatalf("failed to write rpm: %v", err)
+	}
+	return buf
+}
+
+func TestSourceRpm(t *testing.T) {
+	files := map[string]string{
+		"poke.yaml":              "Name: poke\n",
+		"Containerfile":          "FROM scratch\n",
+		"poke-4.3.tar.gz":        "tarball",
+		"patches/0001-fix.patch": "patch",
+	}
+	rdr, err := NewRpmReader(writeSourceRpm(t, files))
+	if err != nil {
+		t.Fatalf("failed to read rpm: %v", err)
+	}
+	defer rdr.Close()
+
+	if !rdr.Source {
+		t.Error("expected a source rpm in the lead")
+	}
+	if e, ok := rdr.Header.Get(TagSourcePackage); !ok || e.Uints()[0] != 1 {
+		t.Error("expected the SourcePackage tag")
+	}
+	if _, ok := rdr.Header.Get(TagSourceRPM); ok {
+		t.Error("expected no SourceRPM tag in a source rpm")
+	}
+	if _, ok := rdr.Header.Get(TagProvideName); ok {
+		t.Error("expected no provides in a source rpm")
+	}
+	dirnames, _ := rdr.Header.Get(TagDirNames)
+	if !slices.Equal(dirnames.Strings(), []string{"", "patches/"}) {
+		t.Errorf("expected relative directories, got %v", dirnames.Strings())
+	}
+
+	payload, err := rdr.Payload()
+	if err != nil {
+		t.Fatalf("failed to read payload: %v", err)
+	}
+	found := make(map[string]string)
+	for {
+		hdr, err := payload.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("failed to read payload: %v", err)
+		}
+		body, _ := io.ReadAll(payload)
+		found[hdr.Name] = string(body)
+	}
+	if !maps.Equal(found, files) {
+		t.Errorf("expected the payload %v, got %v", files, found)
+	}
+}
+
+func TestRpmExtract(t *testing.T) {
+	files := map[string]string{
+		"poke.yaml":              "Name: poke\n",
+		"patches/0001-fix.patch": "patch",
+		// must not escape the destination
+		"../../evil": "evil",
+	}
+	rdr, err := NewRpmReader(writeSourceRpm(t, files))
+	if err != nil {
+		t.Fatalf("failed to read rpm: %v", err)
+	}
+	defer rdr.Close()
+
+	dir := t.TempDir()
+	dest := filepath.Join(dir, "a", "b")
+	if err := rdr.Extract(dest); err != nil {
+		t.Fatalf("failed to extract rpm: %v", err)
+	}
+
+	for name, contents := range map[string]string{
+		"poke.yaml":              "Name: poke\n",
+		"patches/0001-fix.patch": "patch",
+		"evil":                   "evil",
+	} {
+		b, err := os.ReadFile(filepath.Join(dest, name))
+		if err != nil || string(b) != contents {
+			t.Errorf("expected %q in %s, got %q (%v)", contents, name, b, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "evil")); err == nil {
+		t.Error("extracting escaped the destination")
+	}
+}
+
+func TestRpmChangelog(t *testing.T) {
+	changelog := []ChangelogEntry{
+		{time.Date(2024, 3, 2, 12, 0, 0, 0, time.UTC), "Joe Packager <joe@example.com> - 1.0-2", "- Fix the build"},
+		{time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC), "Joe Packager <joe@example.com> - 1.0-1", "- Initial import"},
+	}
+
+	rpm, err := NewRpm(rpmpack.RPMMetaData{Name: "foo", Version: "1.0", Release: "2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rpm.Changelog = changelog
+	buf := &bytes.Buffer{}
+	if err := rpm.Write(buf); err != nil {
+		t.Fatalf("failed to write rpm: %v", err)
+	}
+
+	rdr, err := NewRpmReader(buf)
+	if err != nil {
+		t.Fatalf("failed to read rpm: %v", err)
+	}
+	if got := rdr.Changelog(); !slices.EqualFunc(got, changelog, func(a, b ChangelogEntry) bool {
+		return a.Time.Equal(b.Time) && a.Name == b.Name && a.Text == b.Text
+	}) {
+		t.Errorf("expected changelog %+v, got %+v", changelog, got)
+	}
+}
+
+// runTool runs the command `name` with `args` and returns its output, the test
+// is skipped if the command is not installed
+func runTool(t *testing.T, stdin []byte, name string, args ...string) []byte {
+	t.Helper()
+
+	if _, err := exec.LookPath(name); err != nil {
+		t.Skipf("%s is not installed", name)
+	}
+	cmd := exec.Command(name, args...)
+	cmd.Stdin = bytes.NewReader(stdin)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("%s %s failed: %v\n%s", name, strings.Join(args, " "), err, stderr.String())
+	}
+	return out
+}
+
+func TestRpmTooling(t *testing.T) {
+	t.Run("newc", func(t *testing.T) { testRpmTooling(t) })
+	t.Run("stripped", func(t *testing.T) {
+		defer func(size int64) { maxFileSize = size }(maxFileSize)
+		maxFileSize = 10
+		testRpmTooling(t)
+	})
+}
+
+// testRpmTooling checks a package with every file type with rpm itself
+func testRpmTooling(t *testing.T) {
+	mtime := time.Unix(1700000000, 0)
+	layer := makeLayer(t,
+		tarEntry{hdr: tar.Header{Name: "usr/", Typeflag: tar.TypeDir, Mode: 0o755, ModTime: mtime}},
+		tarEntry{hdr: tar.Header{Name: "usr/bin/", Typeflag: tar.TypeDir, Mode: 0o755, ModTime: mtime}},
+		tarEntry{hdr: tar.Header{Name: "usr/bin/poke", Typeflag: tar.TypeReg, Mode: 0o755, ModTime: mtime}, body: "#!/bin/sh\necho poke\n"},
+		tarEntry{hdr: tar.Header{Name: "usr/bin/poke-link", Typeflag: tar.TypeLink, Linkname: "usr/bin/poke", Mode: 0o755, ModTime: mtime}},
+		tarEntry{hdr: tar.Header{Name: "usr/bin/pk", Typeflag: tar.TypeSymlink, Linkname: "poke", Mode: 0o777, ModTime: mtime}},
+		tarEntry{hdr: tar.Header{Name: "usr/share/empty", Typeflag: tar.TypeReg, Mode: 0o644, ModTime: mtime}},
+		tarEntry{hdr: tar.Header{Name: "dev/null", Typeflag: tar.TypeChar, Mode: 0o666, Devmajor: 1, Devminor: 3, ModTime: mtime}},
+		tarEntry{hdr: tar.Header{Name: "dev/loop0", Typeflag: tar.TypeBlock, Mode: 0o660, Devmajor: 7, Devminor: 0, ModTime: mtime}},
+		tarEntry{hdr: tar.Header{Name: "dev/nvme0n1", Typeflag: tar.TypeBlock, Mode: 0o660, Devmajor: 259, Devminor: 300, ModTime: mtime}},
+		tarEntry{hdr: tar.Header{Name: "run/fifo", Typeflag: tar.TypeFifo, Mode: 0o600, ModTime: mtime}},
+	)
+
+	rpm := rpmFromLayer(t, layer.Bytes(), rpmpack.RPMMetaData{Name: "poke", Version: "4.3", Release: "1", Arch: "noarch", Compressor: "zstd"})
+	buf := &bytes.Buffer{}
+	if err := rpm.Write(buf); err != nil {
+		t.Fatalf("failed to write rpm: %v", err)
+	}
+	dir := t.TempDir()
+	rpmFile := filepath.Join(dir, "poke-4.3-1.noarch.rpm")
+	if err := os.WriteFile(rpmFile, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	dbpath := "--dbpath=" + filepath.Join(dir, "db")
+
+	// the digests of the header and the payload
+	runTool(t, nil, "rpm", dbpath, "-K", rpmFile)
+
+	// path size mtime digest mode owner group isconfig isdoc rdev symlink,
+	// the digest is empty for some file types
+	dump := make(map[string][]string)
+	for _, line := range strings.Split(strings.TrimSpace(string(runTool(t, nil, "rpm", dbpath, "-qp", "--dump", rpmFile))), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 10 {
+			t.Fatalf("unexpected dump line %q", line)
+		}
+		n := len(fields)
+		dump[fields[0]] = []string{fields[1], fields[n-7], fields[n-2], fields[n-1]}
+	}
+	for name, expected := range map[string]struct{ size, mode, rdev, link string }{
+		"/usr":               {"4096", "040755", "0x0000", "X"},
+		"/usr/bin/poke":      {"20", "0100755", "0x0000", "X"},
+		"/usr/bin/poke-link": {"20", "0100755", "0x0000", "X"},
+		"/usr/bin/pk":        {"4", "0120777", "0x0000", "poke"},
+		"/usr/share/empty":   {"0", "0100644", "0x0000", "X"},
+		"/dev/null":          {"0", "020666", "0x0103", "X"},
+		"/dev/loop0":         {"0", "060660", "0x0700", "X"},
+		"/run/fifo":          {"0", "010600", "0x0000", "X"},
+	} {
+		f, ok := dump[name]
+		if !ok {
+			t.Errorf("%s is missing in rpm's dump", name)
+			continue
+		}
+		if f[0] != expected.size || f[1] != expected.mode || f[2] != expected.rdev || f[3] != expected.link {
+			t.Errorf("%s: unexpected dump %q", name, f)
+		}
+	}
+
+	cpio := runTool(t, runTool(t, nil, "rpm2cpio", rpmFile), "cpio", "-t", "--quiet")
+	names := strings.Fields(string(cpio))
+	slices.Sort(names)
+	expected := []string{"./dev/loop0", "./dev/null", "./dev/nvme0n1", "./run/fifo", "./usr", "./usr/bin", "./usr/bin/pk", "./usr/bin/poke", "./usr/bin/poke-link", "./usr/share/empty"}
+	if !slices.Equal(names, expected) {
+		t.Errorf("expected the payload %v, got %v", expected, names)
+	}
+}
+
+func TestMakedev(t *testing.T) {
+	for _, tc := range []struct {
+		major, minor, dev uint32
+	}{
+		{1, 3, 0x0103},
+		{7, 0, 0x0700},
+		{259, 300, 0x11032c},
+		{0xfff, 0xfffff, 0xffffffff},
+	} {
+		dev := makedev(tc.major, tc.minor)
+		if dev != tc.dev {
+			t.Errorf("makedev(%d, %d): expected %#x, got %#x", tc.major, tc.minor, tc.dev, dev)
+		}
+		if devMajor(dev) != tc.major || devMinor(dev) != tc.minor {
+			t.Errorf("%#x: expected %d:%d, got %d:%d", dev, tc.major, tc.minor, devMajor(dev), devMinor(dev))
+		}
+	}
+}
+
+func TestCpioStrippedPadding(t *testing.T) {
+	buf := &bytes.Buffer{}
+	cpio := newCpioWriter(buf)
+	if err := cpio.WriteStrippedHeader(1, 3); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cpio.Write([]byte("abc")); err != nil {
+		t.Fatal(err)
+	}
+
+	// like rpm, the header is padded to 4 bytes before the contents
+	if expected := "07070X00000001\x00\x00abc"; buf.String() != expected {
+		t.Errorf("expected %q, got %q", expected, buf.String())
+	}
+
+	rdr := NewCpioReader(bytes.NewReader(buf.Bytes()))
+	rdr.stripped = func(fx uint32) (*CpioHeader, error) { return &CpioHeader{Name: "./foo", Size: 3}, nil }
+	if _, err := rdr.Next(); err != nil {
+		t.Fatal(err)
+	}
+	if body, _ := io.ReadAll(rdr); string(body) != "abc" {
+		t.Errorf("expected the contents abc, got %q", body)
+	}
+}
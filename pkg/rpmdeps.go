@@ -9,24 +9,30 @@ import (
 	"github.com/google/rpmpack"
 )
 
-// ParseRpmdepsOutput parses the out of the rpmdeps tool and returns RPMMetaData
-// with deduplicated dependency relations (Requires, Provides, Recommends,
-// etc.)
-func ParseRpmdepsOutput(output string) (rpmpack.RPMMetaData, error) {
+// ParseRpmdepsOutput parses the out of the rpmdeps tool and returns
+// RpmDependencies with deduplicated dependency relations (Requires, Provides,
+// Recommends, etc.)
+func ParseRpmdepsOutput(output string) (RpmDependencies, error) {
 	deps := struct {
-		requires   map[string]*rpmpack.Relation
-		recommends map[string]*rpmpack.Relation
-		provides   map[string]*rpmpack.Relation
-		conflicts  map[string]*rpmpack.Relation
-		obsoletes  map[string]*rpmpack.Relation
-		suggests   map[string]*rpmpack.Relation
+		requires          map[string]*rpmpack.Relation
+		recommends        map[string]*rpmpack.Relation
+		provides          map[string]*rpmpack.Relation
+		conflicts         map[string]*rpmpack.Relation
+		obsoletes         map[string]*rpmpack.Relation
+		suggests          map[string]*rpmpack.Relation
+		supplements       map[string]*rpmpack.Relation
+		enhances          map[string]*rpmpack.Relation
+		orderWithRequires map[string]*rpmpack.Relation
 	}{
-		requires:   make(map[string]*rpmpack.Relation),
-		recommends: make(map[string]*rpmpack.Relation),
-		provides:   make(map[string]*rpmpack.Relation),
-		conflicts:  make(map[string]*rpmpack.Relation),
-		obsoletes:  make(map[string]*rpmpack.Relation),
-		suggests:   make(map[string]*rpmpack.Relation),
+		requires:          make(map[string]*rpmpack.Relation),
+		recommends:        make(map[string]*rpmpack.Relation),
+		provides:          make(map[string]*rpmpack.Relation),
+		conflicts:         make(map[string]*rpmpack.Relation),
+		obsoletes:         make(map[string]*rpmpack.Relation),
+		suggests:          make(map[string]*rpmpack.Relation),
+		supplements:       make(map[string]*rpmpack.Relation),
+		enhances:          make(map[string]*rpmpack.Relation),
+		orderWithRequires: make(map[string]*rpmpack.Relation),
 	}
 
 	for line := range strings.SplitSeq(output, "\n") {
@@ -57,8 +63,12 @@ func ParseRpmdepsOutput(output string) (rpmpack.RPMMetaData, error) {
 			targetMap = deps.obsoletes
 		case 's':
 			targetMap = deps.suggests
-		case 'S', 'e', 'o':
-			continue
+		case 'S':
+			targetMap = deps.supplements
+		case 'e':
+			targetMap = deps.enhances
+		case 'o':
+			targetMap = deps.orderWithRequires
 		default:
 			continue
 		}
@@ -66,19 +76,22 @@ func ParseRpmdepsOutput(output string) (rpmpack.RPMMetaData, error) {
 		if _, exists := targetMap[depString]; !exists {
 			r, err := rpmpack.NewRelation(depString)
 			if err != nil {
-				return rpmpack.RPMMetaData{}, fmt.Errorf("invalid dependency %q: %w", depString, err)
+				return RpmDependencies{}, fmt.Errorf("invalid dependency %q: %w", depString, err)
 			}
 			targetMap[depString] = r
 		}
 	}
 
-	return rpmpack.RPMMetaData{
-		Requires:   mapValues(deps.requires),
-		Recommends: mapValues(deps.recommends),
-		Provides:   mapValues(deps.provides),
-		Conflicts:  mapValues(deps.conflicts),
-		Obsoletes:  mapValues(deps.obsoletes),
-		Suggests:   mapValues(deps.suggests),
+	return RpmDependencies{
+		Requires:          mapValues(deps.requires),
+		Recommends:        mapValues(deps.recommends),
+		Provides:          mapValues(deps.provides),
+		Conflicts:         mapValues(deps.conflicts),
+		Obsoletes:         mapValues(deps.obsoletes),
+		Suggests:          mapValues(deps.suggests),
+		Supplements:       mapValues(deps.supplements),
+		Enhances:          mapValues(deps.enhances),
+		OrderWithRequires: mapValues(deps.orderWithRequires),
 	}, nil
 }
 
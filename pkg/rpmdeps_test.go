@@ -64,9 +64,20 @@ func TestParseRpmdepsOutput(t *testing.T) {
 		t.Errorf("expected 6 unique provides, got %d", len(meta.Provides))
 	}
 
-	// Unsupported types (S, e, o) should be skipped
+	// Unused types should be nil
 	if meta.Recommends != nil || meta.Suggests != nil || meta.Conflicts != nil || meta.Obsoletes != nil {
-		t.Error("expected unsupported types and unused types to be nil")
+		t.Error("expected unused types to be nil")
+	}
+
+	// Reverse weak dependencies and ordering hints are kept
+	if len(meta.Supplements) != 1 || meta.Supplements[0].Name != "libsupplement.so()(64bit)" {
+		t.Errorf("expected libsupplement.so()(64bit) in supplements, got %v", meta.Supplements)
+	}
+	if len(meta.Enhances) != 1 || meta.Enhances[0].Name != "libenhance.so()(64bit)" {
+		t.Errorf("expected libenhance.so()(64bit) in enhances, got %v", meta.Enhances)
+	}
+	if len(meta.OrderWithRequires) != 1 || meta.OrderWithRequires[0].Name != "liborder.so()(64bit)" {
+		t.Errorf("expected liborder.so()(64bit) in order with requires, got %v", meta.OrderWithRequires)
 	}
 
 	// Verify specific relations exist
@@ -107,7 +118,10 @@ func TestParseRpmdepsOutput_AllTypes(t *testing.T) {
   P provided.so()(64bit)
   C conflicted.so()(64bit)
   O itself < 1.0
-  s some-symbol`
+  s some-symbol
+  S supplemented.so()(64bit)
+  e enhanced.so()(64bit)
+  o ordered.so()(64bit)`
 
 	meta, err := ParseRpmdepsOutput(input)
 	if err != nil {
@@ -125,6 +139,9 @@ func TestParseRpmdepsOutput_AllTypes(t *testing.T) {
 		{"Conflicts", meta.Conflicts, "conflicted.so()(64bit)"},
 		{"Obsoletes", meta.Obsoletes, "itself"},
 		{"Suggests", meta.Suggests, "some-symbol"},
+		{"Supplements", meta.Supplements, "supplemented.so()(64bit)"},
+		{"Enhances", meta.Enhances, "enhanced.so()(64bit)"},
+		{"OrderWithRequires", meta.OrderWithRequires, "ordered.so()(64bit)"},
 	}
 
 	for _, tt := range tests {
@@ -139,3 +156,52 @@ func TestParseRpmdepsOutput_AllTypes(t *testing.T) {
 		})
 	}
 }
+
+func TestRpmDependenciesFromConfig(t *testing.T) {
+	pkg := RpmPackage{
+		RpmPreamble: RpmPreamble{
+			Requires:          []string{"bar"},
+			RequiresPost:      []string{"coreutils"},
+			Supplements:       []string{"(foo and langpacks-de)"},
+			Enhances:          []string{"foo-core >= 1.0"},
+			OrderWithRequires: []string{"systemd"},
+		},
+	}
+
+	deps, err := RpmDependenciesFromConfig(pkg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(deps.Requires) != 2 || uint32(deps.Requires[1].Sense) != SenseScriptPost {
+		t.Errorf("expected bar and Requires(post) coreutils, got %v", deps.Requires)
+	}
+	if len(deps.Supplements) != 1 || deps.Supplements[0].Name != "(foo and langpacks-de)" {
+		t.Errorf("unexpected supplements: %v", deps.Supplements)
+	}
+	if len(deps.Enhances) != 1 || deps.Enhances[0].Name != "foo-core" || deps.Enhances[0].Version != "1.0" {
+		t.Errorf("unexpected enhances: %v", deps.Enhances)
+	}
+	if len(deps.OrderWithRequires) != 1 || deps.OrderWithRequires[0].Name != "systemd" {
+		t.Errorf("unexpected order with requires: %v", deps.OrderWithRequires)
+	}
+
+	auto, err := ParseRpmdepsOutput(`0 /usr/lib64/foo.so
+  R bar
+  S (foo and langpacks-fr)
+  o systemd`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	deps.Append(auto)
+	if len(deps.Requires) != 2 {
+		t.Errorf("expected duplicate requires to be skipped, got %v", deps.Requires)
+	}
+	if len(deps.Supplements) != 2 {
+		t.Errorf("expected 2 supplements, got %v", deps.Supplements)
+	}
+	if len(deps.OrderWithRequires) != 1 {
+		t.Errorf("expected duplicate order with requires to be skipped, got %v", deps.OrderWithRequires)
+	}
+}
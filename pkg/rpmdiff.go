@@ -0,0 +1,237 @@
+package roci
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// fileTags are the header tags with one value per file, they are compared
+// file by file instead of as a whole
+var fileTags = map[int]string{
+	TagFileSizes:       "size",
+	TagLongFileSizes:   "size",
+	TagFileModes:       "mode",
+	TagFileRDevs:       "rdev",
+	TagFileMTimes:      "mtime",
+	TagFileDigests:     "digest",
+	TagFileLinkTos:     "link target",
+	TagFileFlags:       "flags",
+	TagFileUserName:    "owner",
+	TagFileGroupName:   "group",
+	TagFileVerifyFlags: "verify flags",
+	TagFileDevices:     "device",
+	TagFileINodes:      "inode",
+	TagFileLangs:       "language",
+}
+
+// RpmDiff compares the rpm packages `a` and `b` header by header and file by
+// file and returns a description of each difference. Two packages without any
+// difference are bit for bit identical.
+func RpmDiff(a, b io.Reader) ([]string, error) {
+	ra, err := NewRpmReader(a)
+	if err != nil {
+		return nil, err
+	}
+	defer ra.Close()
+	rb, err := NewRpmReader(b)
+	if err != nil {
+		return nil, err
+	}
+	defer rb.Close()
+
+	var diffs []string
+	if ra.Source != rb.Source {
+		diffs = append(diffs, "lead: the package types differ")
+	}
+	diffs = append(diffs, diffHeaders("signature", sigTagNames, ra.Signature, rb.Signature)...)
+	diffs = append(diffs, diffHeaders("header", tagNames, ra.Header, rb.Header)...)
+
+	filesA, filesB := headerFiles(ra.Header), headerFiles(rb.Header)
+	diffs = append(diffs, diffFiles(filesA, filesB)...)
+	if d := diffOrder("header", filesA.names, filesB.names); d != "" {
+		diffs = append(diffs, d)
+	}
+
+	payloadA, err := readPayload(ra)
+	if err != nil {
+		return nil, err
+	}
+	payloadB, err := readPayload(rb)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range sortedUnion(payloadA.digests, payloadB.digests) {
+		da, okA := payloadA.digests[name]
+		db, okB := payloadB.digests[name]
+		switch {
+		case !okA || !okB:
+			diffs = append(diffs, fmt.Sprintf("payload: %s is only in one package", name))
+		case da != db:
+			diffs = append(diffs, fmt.Sprintf("payload: the contents of %s differ", name))
+		}
+	}
+	if d := diffOrder("payload", payloadA.names, payloadB.names); d != "" {
+		diffs = append(diffs, d)
+	}
+
+	return diffs, nil
+}
+
+// diffHeaders compares all tags of both headers except for the file tags
+func diffHeaders(kind string, names map[int]string, a, b *Header) []string {
+	tags := slices.Concat(a.Tags(), b.Tags())
+	slices.Sort(tags)
+
+	var diffs []string
+	for _, tag := range slices.Compact(tags) {
+		if _, ok := fileTags[tag]; ok {
+			continue
+		}
+
+		name, ok := names[tag]
+		if !ok {
+			name = strconv.Itoa(tag)
+		}
+		ea, okA := a.Get(tag)
+		eb, okB := b.Get(tag)
+		switch {
+		case !okA || !okB:
+			diffs = append(diffs, fmt.Sprintf("%s: tag %s is only in one package", kind, name))
+		case !ea.Equal(eb):
+			diffs = append(diffs, fmt.Sprintf("%s: tag %s differs: %s != %s", kind, name, formatEntry(ea), formatEntry(eb)))
+		}
+	}
+	return diffs
+}
+
+// formatEntry returns a human readable representation of `e`
+func formatEntry(e HeaderEntry) string {
+	if s := e.Strings(); s != nil {
+		return fmt.Sprintf("%q", s)
+	}
+	if u := e.Uints(); u != nil {
+		return fmt.Sprint(u)
+	}
+	return fmt.Sprintf("%x", e.Data)
+}
+
+// fileAttributes are the files of a header in the order of the header with
+// their attributes by the name of the attribute
+type fileAttributes struct {
+	names []string
+	attrs map[string]map[string]string
+}
+
+func headerFiles(h *Header) fileAttributes {
+	files := fileAttributes{names: fileNames(h), attrs: make(map[string]map[string]string)}
+	for _, name := range files.names {
+		files.attrs[name] = make(map[string]string)
+	}
+
+	for tag, attr := range fileTags {
+		e, ok := h.Get(tag)
+		if !ok {
+			continue
+		}
+		values := e.Strings()
+		if values == nil {
+			for _, u := range e.Uints() {
+				if attr == "mode" {
+					values = append(values, fmt.Sprintf("%o", u))
+				} else {
+					values = append(values, strconv.FormatUint(u, 10))
+				}
+			}
+		}
+		for i, v := range values {
+			if i < len(files.names) {
+				files.attrs[files.names[i]][attr] = v
+			}
+		}
+	}
+	return files
+}
+
+func diffFiles(a, b fileAttributes) []string {
+	var diffs []string
+	for _, name := range sortedUnion(a.attrs, b.attrs) {
+		fa, okA := a.attrs[name]
+		fb, okB := b.attrs[name]
+		if !okA || !okB {
+			diffs = append(diffs, fmt.Sprintf("file %s is only in one package", name))
+			continue
+		}
+		for _, attr := range sortedUnion(fa, fb) {
+			if fa[attr] != fb[attr] {
+				diffs = append(diffs, fmt.Sprintf("file %s: %s differs: %q != %q", name, attr, fa[attr], fb[attr]))
+			}
+		}
+	}
+	return diffs
+}
+
+// payloadEntries are the names of the payload entries in the order of the
+// archive and the digests of their contents
+type payloadEntries struct {
+	names   []string
+	digests map[string]string
+}
+
+func readPayload(r *RpmReader) (payloadEntries, error) {
+	p, err := r.Payload()
+	if err != nil {
+		return payloadEntries{}, err
+	}
+
+	entries := payloadEntries{digests: make(map[string]string)}
+	for {
+		hdr, err := p.Next()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return payloadEntries{}, fmt.Errorf("failed to read payload: %w", err)
+		}
+
+		h := sha256.New()
+		if _, err := io.Copy(h, p); err != nil {
+			return payloadEntries{}, fmt.Errorf("failed to read payload: %w", err)
+		}
+		name := hdr.Name
+		if strings.HasPrefix(name, "./") {
+			name = name[1:]
+		}
+		entries.names = append(entries.names, name)
+		entries.digests[name] = fmt.Sprintf("%x", h.Sum(nil))
+	}
+}
+
+// diffOrder returns a description of the first position at which the
+// ordering of `a` and `b` differs
+func diffOrder(kind string, a, b []string) string {
+	for i := range min(len(a), len(b)) {
+		if a[i] != b[i] {
+			return fmt.Sprintf("%s: the order of the files differs at position %d: %s != %s", kind, i, a[i], b[i])
+		}
+	}
+	return ""
+}
+
+// sortedUnion returns the sorted union of the keys of `a` and `b`
+func sortedUnion[V any](a, b map[string]V) []string {
+	keys := make([]string, 0, len(a)+len(b))
+	for k := range a {
+		keys = append(keys, k)
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	slices.Sort(keys)
+	return keys
+}
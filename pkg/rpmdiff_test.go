@@ -0,0 +1,54 @@
+package roci
+
+import (
+	"archive/tar"
+	"bytes"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/rpmpack"
+)
+
+func TestRpmDiff(t *testing.T) {
+	mtime := time.Unix(1700000000, 0)
+	writeRpm := func(buildTime time.Time, fooMTime time.Time, bar string) *bytes.Reader {
+		layer := makeLayer(t,
+			tarEntry{hdr: tar.Header{Name: "usr/bin/foo", Typeflag: tar.TypeReg, Mode: 0o755, ModTime: fooMTime}, body: "foo"},
+			tarEntry{hdr: tar.Header{Name: "usr/bin/bar", Typeflag: tar.TypeReg, Mode: 0o755, ModTime: mtime}, body: bar},
+		)
+		rpm := rpmFromLayer(t, layer.Bytes(), rpmpack.RPMMetaData{Name: "foo", Version: "1", BuildTime: buildTime})
+		buf := &bytes.Buffer{}
+		if err := rpm.Write(buf); err != nil {
+			t.Fatalf("failed to write rpm: %v", err)
+		}
+		return bytes.NewReader(buf.Bytes())
+	}
+
+	diffs, err := RpmDiff(writeRpm(mtime, mtime, "bar"), writeRpm(mtime, mtime, "bar"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(diffs) != 0 {
+		t.Errorf("expected no differences, got %v", diffs)
+	}
+
+	diffs, err = RpmDiff(writeRpm(mtime, mtime, "bar"), writeRpm(mtime.Add(time.Hour), mtime.Add(time.Second), "baz"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, expected := range []string{
+		"header: tag BuildTime differs: [1700000000] != [1700003600]",
+		`file /usr/bin/foo: mtime differs: "1700000000" != "1700000001"`,
+		"payload: the contents of /usr/bin/bar differ",
+	} {
+		if !slices.Contains(diffs, expected) {
+			t.Errorf("expected %q in the differences %v", expected, diffs)
+		}
+	}
+	for _, d := range diffs {
+		if d == "payload: the contents of /usr/bin/foo differ" {
+			t.Errorf("unexpected difference %q", d)
+		}
+	}
+}
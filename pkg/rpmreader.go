@@ -0,0 +1,231 @@
+package roci
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+// RpmReader reads a rpm package
+type RpmReader struct {
+	// Source is true for source rpms
+	Source    bool
+	Signature *Header
+	Header    *Header
+
+	r            io.Reader
+	decompressor io.ReadCloser
+}
+
+// NewRpmReader reads the lead, the signature and the header of the rpm in `r`.
+// The payload can be read afterwards via Payload.
+func NewRpmReader(r io.Reader) (*RpmReader, error) {
+	l := make([]byte, 96)
+	if _, err := io.ReadFull(r, l); err != nil {
+		return nil, fmt.Errorf("failed to read lead: %w", err)
+	}
+	if !bytes.Equal(l[:4], []byte{0xed, 0xab, 0xee, 0xdb}) {
+		return nil, errNoRpm
+	}
+
+	sig, err := ReadHeader(r, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signature: %w", err)
+	}
+	hdr, err := ReadHeader(r, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RpmReader{
+		Source:    l[7] == 1,
+		Signature: sig,
+		Header:    hdr,
+		r:         r,
+	}, nil
+}
+
+// Payload returns a reader of the decompressed cpio archive of the package.
+// It can only be called once.
+func (p *RpmReader) Payload() (*CpioReader, error) {
+	if p.decompressor != nil {
+		return nil, fmt.Errorf("payload has already been read")
+	}
+
+	compressor, _ := p.Header.Get(TagPayloadCompressor)
+	d, err := newPayloadDecompressor(compressor.String(), p.r)
+	if err != nil {
+		return nil, err
+	}
+	p.decompressor = d
+
+	c := NewCpioReader(d)
+	c.stripped = p.strippedHeaders()
+	return c, nil
+}
+
+// strippedHeaders returns a function resolving the file indexes of a stripped
+// cpio archive to the headers of the files in the rpm header
+func (p *RpmReader) strippedHeaders() func(fx uint32) (*CpioHeader, error) {
+	get := func(tag int) HeaderEntry {
+		e, _ := p.Header.Get(tag)
+		return e
+	}
+	basenames := get(TagBaseNames).Strings()
+	dirnames := get(TagDirNames).Strings()
+	dirindexes := get(TagDirIndexes).Uints()
+	modes := get(TagFileModes).Uints()
+	mtimes := get(TagFileMTimes).Uints()
+	inodes := get(TagFileINodes).Uints()
+	rdevs := get(TagFileRDevs).Uints()
+	linktos := get(TagFileLinkTos).Strings()
+	sizes := get(TagLongFileSizes).Uints()
+	if sizes == nil {
+		sizes = get(TagFileSizes).Uints()
+	}
+
+	nlinks := make(map[uint64]uint32)
+	for _, inode := range inodes {
+		nlinks[inode]++
+	}
+	// the contents of a hardlink set are stored with its last file
+	seen := make(map[uint64]uint32)
+
+	n := len(basenames)
+	consistent := len(linktos) == n
+	for _, values := range [][]uint64{dirindexes, modes, mtimes, inodes, rdevs, sizes} {
+		consistent = consistent && len(values) == n
+	}
+	for _, idx := range dirindexes {
+		consistent = consistent && idx < uint64(len(dirnames))
+	}
+
+	return func(fx uint32) (*CpioHeader, error) {
+		if !consistent {
+			return nil, errors.New("the file tags of the header are inconsistent")
+		}
+		i := int(fx)
+		if i >= n {
+			return nil, fmt.Errorf("cpio: file index %d is out of range", fx)
+		}
+
+		hdr := &CpioHeader{
+			Name:      payloadName(dirnames[dirindexes[i]] + basenames[i]),
+			Inode:     uint32(inodes[i]),
+			Mode:      uint32(modes[i]),
+			Nlink:     nlinks[inodes[i]],
+			MTime:     uint32(mtimes[i]),
+			RDevMajor: devMajor(uint32(rdevs[i])),
+			RDevMinor: devMinor(uint32(rdevs[i])),
+		}
+		switch hdr.Mode & modeTypeMask {
+		case modeReg:
+			seen[inodes[i]]++
+			if seen[inodes[i]] == hdr.Nlink {
+				hdr.Size = int64(sizes[i])
+			}
+		case modeSymlink:
+			hdr.Size = int64(len(linktos[i]))
+		}
+		return hdr, nil
+	}
+}
+
+// FileNames returns the names of all files in the order of the header
+func (p *RpmReader) FileNames() []string {
+	return fileNames(p.Header)
+}
+
+// Changelog returns the %changelog of the package, newest entry first
+func (p *RpmReader) Changelog() []ChangelogEntry {
+	timesEntry, _ := p.Header.Get(TagChangelogTime)
+	namesEntry, _ := p.Header.Get(TagChangelogName)
+	textsEntry, _ := p.Header.Get(TagChangelogText)
+
+	times, names, texts := timesEntry.Uints(), namesEntry.Strings(), textsEntry.Strings()
+	var changelog []ChangelogEntry
+	for i := 0; i < len(times) && i < len(names) && i < len(texts); i++ {
+		changelog = append(changelog, ChangelogEntry{
+			Time: time.Unix(int64(times[i]), 0).UTC(),
+			Name: names[i],
+			Text: texts[i],
+		})
+	}
+	return changelog
+}
+
+func fileNames(h *Header) []string {
+	basenames, _ := h.Get(TagBaseNames)
+	dirnames, _ := h.Get(TagDirNames)
+	dirindexes, _ := h.Get(TagDirIndexes)
+
+	var names []string
+	dirs, indexes := dirnames.Strings(), dirindexes.Uints()
+	for i, base := range basenames.Strings() {
+		name := base
+		if i < len(indexes) && indexes[i] < uint64(len(dirs)) {
+			name = dirs[indexes[i]] + base
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// Extract writes the directories and regular files of the payload into `dir`,
+// which is how source rpms are unpacked. All paths are confined to `dir`. Like
+// Payload, it can only be called once.
+func (p *RpmReader) Extract(dir string) error {
+	payload, err := p.Payload()
+	if err != nil {
+		return err
+	}
+
+	for {
+		hdr, err := payload.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read payload: %w", err)
+		}
+
+		dest := filepath.Join(dir, filepath.FromSlash(path.Clean("/"+hdr.Name)))
+		switch {
+		case hdr.Mode&modeTypeMask == modeDir:
+			if err := os.MkdirAll(dest, 0o755); err != nil {
+				return err
+			}
+		case hdr.Mode&modeTypeMask == modeReg && hdr.Nlink == 1:
+			if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, fs.FileMode(hdr.Mode&0o777))
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(f, payload)
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				return fmt.Errorf("failed to extract %s: %w", hdr.Name, err)
+			}
+		default:
+			return fmt.Errorf("cannot extract %s, only directories and regular files are supported", hdr.Name)
+		}
+	}
+}
+
+// Close releases the resources of the payload decompressor
+func (p *RpmReader) Close() error {
+	if p.decompressor != nil {
+		return p.decompressor.Close()
+	}
+	return nil
+}
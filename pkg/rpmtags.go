@@ -0,0 +1,213 @@
+package roci
+
+// RPM header tags that roci writes.
+// See https://github.com/rpm-software-management/rpm/blob/master/include/rpm/rpmtag.h
+const (
+	TagHeaderI18NTable = 100
+
+	TagName              = 1000
+	TagVersion           = 1001
+	TagRelease           = 1002
+	TagEpoch             = 1003
+	TagSummary           = 1004
+	TagDescription       = 1005
+	TagBuildTime         = 1006
+	TagBuildHost         = 1007
+	TagSize              = 1009
+	TagDistribution      = 1010
+	TagVendor            = 1011
+	TagLicense           = 1014
+	TagPackager          = 1015
+	TagGroup             = 1016
+	TagSource            = 1018
+	TagURL               = 1020
+	TagOS                = 1021
+	TagArch              = 1022
+	TagPrein             = 1023
+	TagPostin            = 1024
+	TagPreun             = 1025
+	TagPostun            = 1026
+	TagFileSizes         = 1028
+	TagFileModes         = 1030
+	TagFileRDevs         = 1033
+	TagFileMTimes        = 1034
+	TagFileDigests       = 1035
+	TagFileLinkTos       = 1036
+	TagFileFlags         = 1037
+	TagFileUserName      = 1039
+	TagFileGroupName     = 1040
+	TagSourceRPM         = 1044
+	TagFileVerifyFlags   = 1045
+	TagProvideName       = 1047
+	TagRequireFlags      = 1048
+	TagRequireName       = 1049
+	TagRequireVersion    = 1050
+	TagConflictFlags     = 1053
+	TagConflictName      = 1054
+	TagConflictVersion   = 1055
+	TagVerifyScript      = 1079
+	TagChangelogTime     = 1080
+	TagChangelogName     = 1081
+	TagChangelogText     = 1082
+	TagPreinProg         = 1085
+	TagPostinProg        = 1086
+	TagPreunProg         = 1087
+	TagPostunProg        = 1088
+	TagObsoleteName      = 1090
+	TagVerifyScriptProg  = 1091
+	TagFileDevices       = 1095
+	TagFileINodes        = 1096
+	TagFileLangs         = 1097
+	TagPrefixes          = 1098
+	TagSourcePackage     = 1106
+	TagProvideFlags      = 1112
+	TagProvideVersion    = 1113
+	TagObsoleteFlags     = 1114
+	TagObsoleteVersion   = 1115
+	TagDirIndexes        = 1116
+	TagBaseNames         = 1117
+	TagDirNames          = 1118
+	TagPayloadFormat     = 1124
+	TagPayloadCompressor = 1125
+	TagPayloadFlags      = 1126
+	TagPretrans          = 1151
+	TagPosttrans         = 1152
+	TagPretransProg      = 1153
+	TagPosttransProg     = 1154
+
+	TagLongFileSizes     = 5008
+	TagLongSize          = 5009
+	TagFileDigestAlgo    = 5011
+	TagOrderName         = 5035
+	TagOrderVersion      = 5036
+	TagOrderFlags        = 5037
+	TagRecommendName     = 5046
+	TagRecommendVersion  = 5047
+	TagRecommendFlags    = 5048
+	TagSuggestName       = 5049
+	TagSuggestVersion    = 5050
+	TagSuggestFlags      = 5051
+	TagSupplementName    = 5052
+	TagSupplementVersion = 5053
+	TagSupplementFlags   = 5054
+	TagEnhanceName       = 5055
+	TagEnhanceVersion    = 5056
+	TagEnhanceFlags      = 5057
+	TagPayloadDigest     = 5092
+	TagPayloadDigestAlgo = 5093
+)
+
+// tags of the signature header
+const (
+	SigTagLongSize        = 270
+	SigTagLongArchiveSize = 271
+	SigTagSHA256          = 273
+	SigTagSize            = 1000
+	SigTagPayloadSize     = 1007
+)
+
+// names of the header tags for reports
+var tagNames = map[int]string{
+	TagHeaderI18NTable:   "HeaderI18NTable",
+	TagName:              "Name",
+	TagVersion:           "Version",
+	TagRelease:           "Release",
+	TagEpoch:             "Epoch",
+	TagSummary:           "Summary",
+	TagDescription:       "Description",
+	TagBuildTime:         "BuildTime",
+	TagBuildHost:         "BuildHost",
+	TagSize:              "Size",
+	TagDistribution:      "Distribution",
+	TagVendor:            "Vendor",
+	TagLicense:           "License",
+	TagPackager:          "Packager",
+	TagGroup:             "Group",
+	TagSource:            "Source",
+	TagURL:               "URL",
+	TagOS:                "OS",
+	TagArch:              "Arch",
+	TagPrein:             "Prein",
+	TagPostin:            "Postin",
+	TagPreun:             "Preun",
+	TagPostun:            "Postun",
+	TagFileSizes:         "FileSizes",
+	TagFileModes:         "FileModes",
+	TagFileRDevs:         "FileRDevs",
+	TagFileMTimes:        "FileMTimes",
+	TagFileDigests:       "FileDigests",
+	TagFileLinkTos:       "FileLinkTos",
+	TagFileFlags:         "FileFlags",
+	TagFileUserName:      "FileUserName",
+	TagFileGroupName:     "FileGroupName",
+	TagSourceRPM:         "SourceRPM",
+	TagFileVerifyFlags:   "FileVerifyFlags",
+	TagProvideName:       "ProvideName",
+	TagRequireFlags:      "RequireFlags",
+	TagRequireName:       "RequireName",
+	TagRequireVersion:    "RequireVersion",
+	TagConflictFlags:     "ConflictFlags",
+	TagConflictName:      "ConflictName",
+	TagConflictVersion:   "ConflictVersion",
+	TagVerifyScript:      "VerifyScript",
+	TagChangelogTime:     "ChangelogTime",
+	TagChangelogName:     "ChangelogName",
+	TagChangelogText:     "ChangelogText",
+	TagPreinProg:         "PreinProg",
+	TagPostinProg:        "PostinProg",
+	TagPreunProg:         "PreunProg",
+	TagPostunProg:        "PostunProg",
+	TagObsoleteName:      "ObsoleteName",
+	TagVerifyScriptProg:  "VerifyScriptProg",
+	TagFileDevices:       "FileDevices",
+	TagFileINodes:        "FileINodes",
+	TagFileLangs:         "FileLangs",
+	TagPrefixes:          "Prefixes",
+	TagSourcePackage:     "SourcePackage",
+	TagProvideFlags:      "ProvideFlags",
+	TagProvideVersion:    "ProvideVersion",
+	TagObsoleteFlags:     "ObsoleteFlags",
+	TagObsoleteVersion:   "ObsoleteVersion",
+	TagDirIndexes:        "DirIndexes",
+	TagBaseNames:         "BaseNames",
+	TagDirNames:          "DirNames",
+	TagPayloadFormat:     "PayloadFormat",
+	TagPayloadCompressor: "PayloadCompressor",
+	TagPayloadFlags:      "PayloadFlags",
+	TagPretrans:          "Pretrans",
+	TagPosttrans:         "Posttrans",
+	TagPretransProg:      "PretransProg",
+	TagPosttransProg:     "PosttransProg",
+	TagLongFileSizes:     "LongFileSizes",
+	TagLongSize:          "LongSize",
+	TagFileDigestAlgo:    "FileDigestAlgo",
+	TagOrderName:         "OrderName",
+	TagOrderVersion:      "OrderVersion",
+	TagOrderFlags:        "OrderFlags",
+	TagRecommendName:     "RecommendName",
+	TagRecommendVersion:  "RecommendVersion",
+	TagRecommendFlags:    "RecommendFlags",
+	TagSuggestName:       "SuggestName",
+	TagSuggestVersion:    "SuggestVersion",
+	TagSuggestFlags:      "SuggestFlags",
+	TagSupplementName:    "SupplementName",
+	TagSupplementVersion: "SupplementVersion",
+	TagSupplementFlags:   "SupplementFlags",
+	TagEnhanceName:       "EnhanceName",
+	TagEnhanceVersion:    "EnhanceVersion",
+	TagEnhanceFlags:      "EnhanceFlags",
+	TagPayloadDigest:     "PayloadDigest",
+	TagPayloadDigestAlgo: "PayloadDigestAlgo",
+}
+
+// names of the signature tags for reports
+var sigTagNames = map[int]string{
+	SigTagLongSize:        "LongSize",
+	SigTagLongArchiveSize: "LongArchiveSize",
+	SigTagSHA256:          "SHA256",
+	SigTagSize:            "Size",
+	SigTagPayloadSize:     "PayloadSize",
+}
+
+// hash algorithm of file and payload digests, see rpmpgp.h
+const hashAlgoSHA256 = 8
@@ -0,0 +1,192 @@
+package roci
+
+import (
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+)
+
+// fieldDescriptions are the descriptions of all keys of the config file in
+// the JSON schema
+var fieldDescriptions = map[string]string{
+	"Name":                  "Name of the package, in subpackages a name starting with '-' is appended to the main package's name",
+	"Version":               "Version of the package",
+	"Release":               "Release of the package, e.g. $AUTORELEASE",
+	"Epoch":                 "Epoch of the package",
+	"License":               "SPDX license expression of the package's contents",
+	"SourceLicense":         "License of the source rpm, defaults to License",
+	"Group":                 "Group of the package",
+	"Summary":               "One line summary of the package",
+	"Icon":                  "Icon of the package, not used by roci",
+	"URL":                   "Upstream URL of the package",
+	"BugURL":                "URL of the bug tracker, not used by roci",
+	"DistTag":               "Dist tag of the package, not used by roci",
+	"VCS":                   "Version control system of the package's sources, not used by roci",
+	"Distribution":          "Distribution that the package belongs to, defaults to the one of the distribution profile",
+	"Vendor":                "Vendor of the package, defaults to the one of the distribution profile",
+	"Packager":              "Person or organization that built the package",
+	"Requires":              "Dependencies of the package",
+	"Requires(pre)":         "Dependencies that are required by the Prein scriptlet",
+	"Requires(post)":        "Dependencies that are required by the Postin scriptlet",
+	"Requires(preun)":       "Dependencies that are required by the Preun scriptlet",
+	"Requires(postun)":      "Dependencies that are required by the Postun scriptlet",
+	"Requires(pretrans)":    "Dependencies that are required by the Pretrans scriptlet",
+	"Requires(posttrans)":   "Dependencies that are required by the Posttrans scriptlet",
+	"Requires(preuntrans)":  "Dependencies that are required by the Preuntrans scriptlet",
+	"Requires(postuntrans)": "Dependencies that are required by the Postuntrans scriptlet",
+	"Requires(verify)":      "Dependencies that are required by the VerifyScript scriptlet",
+	"Requires(interp)":      "Interpreters of the scriptlets, they are added automatically",
+	"Requires(meta)":        "Dependencies that do not affect the installation order",
+	"Provides":              "Capabilities that the package provides",
+	"Conflicts":             "Packages that cannot be installed together with the package",
+	"Obsoletes":             "Packages that the package replaces",
+	"Recommends":            "Weak dependencies that are installed by default",
+	"Suggests":              "Weak dependencies that are not installed by default",
+	"Supplements":           "Reverse weak dependencies that are installed by default",
+	"Enhances":              "Reverse weak dependencies that are not installed by default",
+	"OrderWithRequires":     "Packages that are installed before the package if they are part of the same transaction",
+	"ExcludeArch":           "Architectures that the package cannot be built on, not used by roci",
+	"ExclusiveArch":         "The only architectures that the package can be built on, not used by roci",
+	"ExcludeOS":             "Operating systems that the package cannot be built on, not used by roci",
+	"ExclusiveOS":           "The only operating systems that the package can be built on, not used by roci",
+	"BuildArch":             "Architecture of the package, not used by roci",
+	"Prefixes":              "Prefixes of a relocatable package, not used by roci",
+	"DocDir":                "Directory of the package's documentation, not used by roci",
+	"Description":           "Multi line description of the package",
+	"Pretrans":              "Scriptlet that runs before the transaction",
+	"Prein":                 "Scriptlet that runs before the package is installed",
+	"Postin":                "Scriptlet that runs after the package is installed",
+	"Preun":                 "Scriptlet that runs before the package is removed",
+	"Postun":                "Scriptlet that runs after the package is removed",
+	"Posttrans":             "Scriptlet that runs after the transaction",
+	"VerifyScript":          "Scriptlet that runs when the package is verified",
+	"Options":               "Options of the scriptlet, e.g. [\"-p\", \"<lua>\"] to select the interpreter",
+	"Script":                "The script, it is run by /bin/sh unless -p is set in Options",
+	"package":               "Subpackages, keyed by the name of the Containerfile stage that they are built from",
+	"Variables":             "User defined variables for the expansion of the config file",
+	"Changelog":             "Changelog of all packages in the format of a spec file's %changelog",
+	"Overrides":             "Fields of the config that are overridden for the releases matching the key, e.g. fedora* or el9",
+	"Conditionals":          "Build conditionals like %bcond in a spec file, mapped to whether they are enabled by default",
+	"Condition":             "Conditional that must be enabled to build the subpackage, or disabled if it starts with '!'",
+}
+
+// combinedRequiresDescription is the description of the `Requires(…)` keys
+// with several qualifiers
+const combinedRequiresDescription = "Dependencies that are required by several scriptlets, e.g. Requires(post,postun)"
+
+// scalar is the schema of the values that yaml can decode into a string
+var scalar = map[string]any{"type": []string{"string", "number", "boolean"}}
+
+// ConfigSchema returns the JSON schema of the config file. It is generated from
+// the yaml tags of Config, so that it always matches what LoadConfig accepts.
+func ConfigSchema() (map[string]any, error) {
+	g := &schemaGenerator{defs: make(map[string]any)}
+	root, err := g.structSchema(reflect.TypeOf(Config{}))
+	if err != nil {
+		return nil, err
+	}
+
+	schema := map[string]any{
+		"$schema": "https://json-schema.org/draft/2020-12/schema",
+		"title":   "roci configuration file",
+		"$defs":   g.defs,
+	}
+	for k, v := range root {
+		schema[k] = v
+	}
+	return schema, nil
+}
+
+type schemaGenerator struct {
+	defs map[string]any
+}
+
+// typeSchema returns the schema of values of the type `t`
+func (g *schemaGenerator) typeSchema(t reflect.Type) (map[string]any, error) {
+	switch t.Kind() {
+	case reflect.String:
+		return scalar, nil
+	case reflect.Int, reflect.Int32, reflect.Int64, reflect.Uint32:
+		return map[string]any{"type": "integer"}, nil
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}, nil
+	case reflect.Slice:
+		items, err := g.typeSchema(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{"type": "array", "items": items}, nil
+	case reflect.Map:
+		values, err := g.typeSchema(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{"type": "object", "additionalProperties": values}, nil
+	case reflect.Struct:
+		// structs are shared via $defs, as the subpackages use the same
+		// ones as the main package
+		if _, ok := g.defs[t.Name()]; !ok {
+			g.defs[t.Name()] = nil
+			s, err := g.structSchema(t)
+			if err != nil {
+				return nil, err
+			}
+			// scriptlets can be written as plain strings
+			if t == reflect.TypeOf(RpmScriptlet{}) {
+				s = map[string]any{"anyOf": []any{scalar, s}}
+			}
+			g.defs[t.Name()] = s
+		}
+		return map[string]any{"$ref": "#/$defs/" + t.Name()}, nil
+	}
+	return nil, fmt.Errorf("cannot generate a schema for %s", t)
+}
+
+// structSchema returns the schema of the struct `t` with all of its keys
+func (g *schemaGenerator) structSchema(t reflect.Type) (map[string]any, error) {
+	keys, combined := structKeys(t)
+	properties := make(map[string]any)
+	for _, key := range slices.Sorted(maps.Keys(keys)) {
+		s, err := g.typeSchema(keys[key])
+		if err != nil {
+			return nil, err
+		}
+		description, ok := fieldDescriptions[key]
+		if !ok {
+			return nil, fmt.Errorf("no description of %s.%s", t.Name(), key)
+		}
+		properties[key] = withDescription(s, description)
+	}
+
+	schema := map[string]any{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+	if combined {
+		deps, err := g.typeSchema(reflect.TypeOf([]string{}))
+		if err != nil {
+			return nil, err
+		}
+		schema["patternProperties"] = map[string]any{
+			combinedRequiresKey.String(): withDescription(deps, combinedRequiresDescription),
+		}
+	}
+	return schema, nil
+}
+
+// withDescription returns a copy of the schema `s` with the description
+// `description`. References cannot have siblings in older drafts, so they
+// are wrapped.
+func withDescription(s map[string]any, description string) map[string]any {
+	res := map[string]any{"description": description}
+	if _, ok := s["$ref"]; ok && len(s) == 1 {
+		res["allOf"] = []any{s}
+		return res
+	}
+	for k, v := range s {
+		res[k] = v
+	}
+	return res
+}
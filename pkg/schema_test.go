@@ -0,0 +1,52 @@
+package roci
+
+import (
+	"encoding/json"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func TestConfigSchema(t *testing.T) {
+	schema, err := ConfigSchema()
+	if err != nil {
+		t.Fatalf("failed to generate the schema: %v", err)
+	}
+	if _, err := json.Marshal(schema); err != nil {
+		t.Fatalf("failed to serialize the schema: %v", err)
+	}
+
+	properties := schema["properties"].(map[string]any)
+	for _, key := range []string{"Name", "Requires(post)", "Postin", "package", "Variables", "Changelog"} {
+		if _, ok := properties[key]; !ok {
+			t.Errorf("expected the key %q in the schema", key)
+		}
+	}
+
+	pkg := schema["$defs"].(map[string]any)["RpmPackage"].(map[string]any)
+	pkgProperties := pkg["properties"].(map[string]any)
+	if _, ok := pkgProperties["package"]; ok {
+		t.Error("expected subpackages to not have subpackages")
+	}
+	if _, ok := pkg["patternProperties"]; !ok {
+		t.Error("expected the Requires with several qualifiers in the subpackages")
+	}
+}
+
+// every description must belong to a key, so that none are left behind when
+// fields are removed
+func TestConfigSchemaDescriptions(t *testing.T) {
+	var keys []string
+	for _, typ := range []reflect.Type{reflect.TypeOf(Config{}), reflect.TypeOf(RpmScriptlet{})} {
+		k, _ := structKeys(typ)
+		for key := range k {
+			keys = append(keys, key)
+		}
+	}
+
+	for key := range fieldDescriptions {
+		if !slices.Contains(keys, key) {
+			t.Errorf("description of the unknown key %q", key)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package roci
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/rpmpack"
+)
+
+type scriptletInfo struct {
+	name      string
+	scriptlet RpmScriptlet
+	tag       int
+	progTag   int
+	// sense flag of the dependency on the interpreter
+	sense uint32
+}
+
+func scriptlets(pkg RpmPackage) []scriptletInfo {
+	return []scriptletInfo{
+		{"Pretrans", pkg.Pretrans, TagPretrans, TagPretransProg, SensePreTrans},
+		{"Prein", pkg.Prein, TagPrein, TagPreinProg, SenseScriptPre},
+		{"Postin", pkg.Postin, TagPostin, TagPostinProg, SenseScriptPost},
+		{"Preun", pkg.Preun, TagPreun, TagPreunProg, SenseScriptPreUn},
+		{"Postun", pkg.Postun, TagPostun, TagPostunProg, SenseScriptPostUn},
+		{"Posttrans", pkg.Posttrans, TagPosttrans, TagPosttransProg, SensePostTrans},
+		{"VerifyScript", pkg.VerifyScript, TagVerifyScript, TagVerifyScriptProg, SenseScriptVerify},
+	}
+}
+
+// AddScriptlets writes all scriptlets of `pkg` together with their interpreter
+// into the header of `rpm`.
+func AddScriptlets(rpm *Rpm, pkg RpmPackage) error {
+	for _, s := range scriptlets(pkg) {
+		if s.scriptlet.IsEmpty() {
+			continue
+		}
+
+		prog, err := s.scriptlet.Interpreter()
+		if err != nil {
+			return fmt.Errorf("invalid %s scriptlet: %w", s.name, err)
+		}
+
+		// `-p <program>` without a script just executes the program
+		if s.scriptlet.Script != "" {
+			rpm.SetTag(s.tag, EntryString(s.scriptlet.Script))
+		}
+		// rpm stores a single program as a string and only uses an
+		// array if there are arguments
+		if len(prog) == 1 {
+			rpm.SetTag(s.progTag, EntryString(prog[0]))
+		} else {
+			rpm.SetTag(s.progTag, EntryStringSlice(prog))
+		}
+	}
+
+	return nil
+}
+
+// ScriptletRequires returns the dependencies on the interpreters of the
+// scriptlets of `pkg`, like rpmbuild adds them, e.g. `Requires(interp,post):
+// /bin/sh` for a %post scriptlet.
+// Lua scriptlets require rpm's builtin lua interpreter instead.
+func ScriptletRequires(pkg RpmPackage) ([]*rpmpack.Relation, error) {
+	var requires []*rpmpack.Relation
+	for _, s := range scriptlets(pkg) {
+		if s.scriptlet.IsEmpty() {
+			continue
+		}
+
+		prog, err := s.scriptlet.Interpreter()
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s scriptlet: %w", s.name, err)
+		}
+
+		var r *rpmpack.Relation
+		switch {
+		case prog[0] == "<lua>":
+			r, err = NewRelationWithSense("rpmlib(BuiltinLuaScripts) <= 4.2.2-1", SenseRpmlib|SenseInterp|s.sense)
+		case strings.HasPrefix(prog[0], "/"):
+			r, err = NewRelationWithSense(prog[0], SenseInterp|s.sense)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		requires = append(requires, r)
+	}
+
+	return requires, nil
+}
@@ -0,0 +1,72 @@
+package roci
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/rpmpack"
+)
+
+// Dependency sense flags that rpmpack does not define.
+// See https://github.com/rpm-software-management/rpm/blob/master/include/rpm/rpmds.h
+const (
+	SensePostTrans    uint32 = 1 << 5
+	SensePreTrans     uint32 = 1 << 7
+	SenseInterp       uint32 = 1 << 8
+	SenseScriptPre    uint32 = 1 << 9
+	SenseScriptPost   uint32 = 1 << 10
+	SenseScriptPreUn  uint32 = 1 << 11
+	SenseScriptPostUn uint32 = 1 << 12
+	SenseScriptVerify uint32 = 1 << 13
+	SensePreUnTrans   uint32 = 1 << 20
+	SensePostUnTrans  uint32 = 1 << 21
+	SenseRpmlib       uint32 = 1 << 24
+	SenseMeta         uint32 = 1 << 29
+)
+
+// requiresQualifiers maps the qualifiers of Requires(…) to their sense flags
+var requiresQualifiers = map[string]uint32{
+	"pre":         SenseScriptPre,
+	"post":        SenseScriptPost,
+	"preun":       SenseScriptPreUn,
+	"postun":      SenseScriptPostUn,
+	"pretrans":    SensePreTrans,
+	"posttrans":   SensePostTrans,
+	"preuntrans":  SensePreUnTrans,
+	"postuntrans": SensePostUnTrans,
+	"verify":      SenseScriptVerify,
+	"interp":      SenseInterp,
+	"meta":        SenseMeta,
+}
+
+// RequiresQualifierSense converts the comma separated qualifiers of a
+// `Requires(…)` dependency (e.g. "post,postun") into the corresponding sense
+// flags.
+func RequiresQualifierSense(qualifiers string) (uint32, error) {
+	var sense uint32
+	for q := range strings.SplitSeq(qualifiers, ",") {
+		flag, ok := requiresQualifiers[strings.TrimSpace(q)]
+		if !ok {
+			return 0, fmt.Errorf("unknown Requires qualifier %q", strings.TrimSpace(q))
+		}
+		sense |= flag
+	}
+	return sense, nil
+}
+
+// NewRelationWithSense parses `dep` via rpmpack.NewRelation and adds the sense
+// flags `sense` to the resulting relation.
+func NewRelationWithSense(dep string, sense uint32) (*rpmpack.Relation, error) {
+	r, err := rpmpack.NewRelation(dep)
+	if err != nil {
+		return nil, err
+	}
+	addSense(&r.Sense, sense)
+	return r, nil
+}
+
+// addSense sets `flags` on a relation's sense. rpmpack does not export the type
+// of the sense, so we let the compiler infer it.
+func addSense[T ~uint32](s *T, flags uint32) {
+	*s |= T(flags)
+}
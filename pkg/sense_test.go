@@ -0,0 +1,87 @@
+package roci
+
+import (
+	"testing"
+
+	"github.com/google/rpmpack"
+)
+
+func TestRequiresQualifierSense(t *testing.T) {
+	tests := []struct {
+		qualifiers string
+		expected   uint32
+		wantErr    bool
+	}{
+		{"pre", SenseScriptPre, false},
+		{"post,postun", SenseScriptPost | SenseScriptPostUn, false},
+		{"pre, preun", SenseScriptPre | SenseScriptPreUn, false},
+		{"meta", SenseMeta, false},
+		{"interp,posttrans", SenseInterp | SensePostTrans, false},
+		{"post,", 0, true},
+		{"install", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.qualifiers, func(t *testing.T) {
+			sense, err := RequiresQualifierSense(tt.qualifiers)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("expected an error, got %#x", sense)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if sense != tt.expected {
+				t.Errorf("expected %#x, got %#x", tt.expected, sense)
+			}
+		})
+	}
+}
+
+func TestNewRelationWithSense(t *testing.T) {
+	r, err := NewRelationWithSense("foo >= 1.0", SenseScriptPost|SenseScriptPostUn)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if r.Name != "foo" || r.Version != "1.0" {
+		t.Errorf("unexpected relation %s %s", r.Name, r.Version)
+	}
+
+	expected := uint32(rpmpack.SenseGreater|rpmpack.SenseEqual) | SenseScriptPost | SenseScriptPostUn
+	if uint32(r.Sense) != expected {
+		t.Errorf("expected sense %#x, got %#x", expected, uint32(r.Sense))
+	}
+}
+
+func TestScriptletRequires(t *testing.T) {
+	pkg := RpmPackage{
+		Prein:  RpmScriptlet{Script: "useradd foo"},
+		Postin: RpmScriptlet{Options: []string{"-p", "<lua>"}, Script: "print(1)"},
+		Postun: RpmScriptlet{Options: []string{"-p", "/sbin/ldconfig"}},
+	}
+
+	requires, err := ScriptletRequires(pkg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []struct {
+		name  string
+		sense uint32
+	}{
+		{"/bin/sh", SenseInterp | SenseScriptPre},
+		{"rpmlib(BuiltinLuaScripts)", uint32(rpmpack.SenseLess|rpmpack.SenseEqual) | SenseRpmlib | SenseInterp | SenseScriptPost},
+		{"/sbin/ldconfig", SenseInterp | SenseScriptPostUn},
+	}
+	if len(requires) != len(expected) {
+		t.Fatalf("expected %d requires, got %d", len(expected), len(requires))
+	}
+	for i, e := range expected {
+		if requires[i].Name != e.name || uint32(requires[i].Sense) != e.sense {
+			t.Errorf("expected %s with sense %#x, got %s with %#x", e.name, e.sense, requires[i].Name, uint32(requires[i].Sense))
+		}
+	}
+}
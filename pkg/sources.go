@@ -0,0 +1,163 @@
+package roci
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/moby/patternmatcher"
+	"github.com/openshift/imagebuilder"
+)
+
+// copyCollector is an imagebuilder executor that only records the copies
+// from the build context
+type copyCollector struct {
+	copies []imagebuilder.Copy
+}
+
+func (c *copyCollector) Preserve(path string) error            { return nil }
+func (c *copyCollector) EnsureContainerPath(path string) error { return nil }
+func (c *copyCollector) EnsureContainerPathAs(path, user string, mode *os.FileMode) error {
+	return nil
+}
+func (c *copyCollector) Run(run imagebuilder.Run, config docker.Config) error { return nil }
+func (c *copyCollector) UnrecognizedInstruction(step *imagebuilder.Step) error {
+	return nil
+}
+
+func (c *copyCollector) Copy(excludes []string, copies ...imagebuilder.Copy) error {
+	for _, cp := range copies {
+		// copies from other stages, images or the network are not part
+		// of the build context
+		if cp.From == "" && !cp.FromFS {
+			c.copies = append(c.copies, cp)
+		}
+	}
+	return nil
+}
+
+// StageSources returns the files of the build context `contextDir` that the
+// stage `stage` of the Containerfile `containerfile` copies into the image,
+// sorted and relative to `contextDir`. Build arguments are expanded with
+// `args` and the defaults from the Containerfile. Directories are resolved to
+// all files in them.
+//
+// Files matching the patterns `excludes` of the .containerignore file are
+// skipped like in the build context, and so is the .git directory.
+func StageSources(containerfile, contextDir, stage string, args map[string]string, excludes []string) ([]string, error) {
+	ignored, err := patternmatcher.New(append([]string{".git"}, excludes...))
+	if err != nil {
+		return nil, err
+	}
+
+	node, err := imagebuilder.ParseFile(containerfile)
+	if err != nil {
+		return nil, err
+	}
+	stages, err := imagebuilder.NewStages(node, imagebuilder.NewBuilder(args))
+	if err != nil {
+		return nil, err
+	}
+	s, ok := stages.ByName(stage)
+	if !ok {
+		return nil, fmt.Errorf("%s has no stage %s", containerfile, stage)
+	}
+
+	collector := &copyCollector{}
+	for _, child := range s.Node.Children {
+		step := s.Builder.Step()
+		if err := step.Resolve(child); err != nil {
+			return nil, err
+		}
+		if err := s.Builder.Run(step, collector, false); err != nil {
+			return nil, err
+		}
+	}
+
+	var sources []string
+	for _, cp := range collector.copies {
+		for _, src := range cp.Src {
+			// heredocs are inlined into the Containerfile
+			if strings.HasPrefix(src, "<<") {
+				continue
+			}
+			if cp.Download && (strings.Contains(src, "://") || strings.HasPrefix(src, "git@")) {
+				continue
+			}
+
+			files, err := contextFiles(contextDir, src, ignored)
+			if err != nil {
+				return nil, err
+			}
+			sources = append(sources, files...)
+		}
+	}
+
+	slices.Sort(sources)
+	return slices.Compact(sources), nil
+}
+
+// StageBaseImage returns the image that the stage `stage` of the Containerfile
+// `containerfile` uses as FROM, expanded with the build arguments `args`
+func StageBaseImage(containerfile, stage string, args map[string]string) (string, error) {
+	node, err := imagebuilder.ParseFile(containerfile)
+	if err != nil {
+		return "", err
+	}
+	stages, err := imagebuilder.NewStages(node, imagebuilder.NewBuilder(args))
+	if err != nil {
+		return "", err
+	}
+	s, ok := stages.ByName(stage)
+	if !ok {
+		return "", fmt.Errorf("%s has no stage %s", containerfile, stage)
+	}
+	return s.Builder.From(s.Node)
+}
+
+// contextFiles returns all files of the build context `contextDir` matching
+// the source `src` of a COPY or ADD instruction that are not `ignored`
+func contextFiles(contextDir, src string, ignored *patternmatcher.PatternMatcher) ([]string, error) {
+	matches, err := filepath.Glob(filepath.Join(contextDir, filepath.Clean("/"+src)))
+	if err != nil {
+		return nil, err
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("%s does not exist in %s", src, contextDir)
+	}
+
+	var files []string
+	for _, m := range matches {
+		err := filepath.WalkDir(m, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(contextDir, path)
+			if err != nil {
+				return err
+			}
+			rel = filepath.ToSlash(rel)
+			skip, err := ignored.MatchesOrParentMatches(rel)
+			switch {
+			case err != nil:
+				return err
+			// exceptions like !dir/keep can include files of an
+			// ignored directory
+			case skip && d.IsDir() && !ignored.Exclusions():
+				return filepath.SkipDir
+			case skip || d.IsDir():
+				return nil
+			}
+			files = append(files, rel)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
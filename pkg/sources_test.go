@@ -0,0 +1,136 @@
+package roci
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestStageSources(t *testing.T) {
+	dir := t.TempDir()
+	for name, contents := range map[string]string{
+		"Containerfile": `ARG VERSION=4.2
+ARG DIST
+FROM fedora-rpm-buildroot:${DIST} as buildrequires
+ARG VERSION
+
+WORKDIR /src/
+COPY poke-${VERSION}.tar.gz poke-${VERSION}.tar.gz.sig .
+COPY patches/ /src/patches/
+ADD https://example.com/poke.keyring .
+COPY --from=docker.io/library/alpine /etc/os-release .
+COPY <<EOF /src/inline
+not a source
+EOF
+
+RUN dnf -y install gcc
+
+FROM localhost/poke-buildrequires as build
+COPY build.sh .
+`,
+		"poke-4.3.tar.gz":         "tarball",
+		"poke-4.3.tar.gz.sig":     "signature",
+		"poke-4.2.tar.gz":         "old tarball",
+		"patches/0001-fix.patch":  "patch",
+		"patches/more/0002.patch": "patch",
+		"build.sh":                "build",
+		"poke.yaml":               "Name: poke",
+	} {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sources, err := StageSources(filepath.Join(dir, "Containerfile"), dir, "buildrequires", map[string]string{"VERSION": "4.3", "DIST": "44"}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []string{"patches/0001-fix.patch", "patches/more/0002.patch", "poke-4.3.tar.gz", "poke-4.3.tar.gz.sig"}
+	if !slices.Equal(sources, expected) {
+		t.Errorf("expected %v, got %v", expected, sources)
+	}
+
+	if _, err := StageSources(filepath.Join(dir, "Containerfile"), dir, "buildrequires", map[string]string{"VERSION": "5.0"}, nil); err == nil {
+		t.Error("expected an error for a missing source")
+	}
+	if _, err := StageSources(filepath.Join(dir, "Containerfile"), dir, "check", nil, nil); err == nil {
+		t.Error("expected an error for a missing stage")
+	}
+}
+
+func TestStageSourcesExcludes(t *testing.T) {
+	dir := t.TempDir()
+	for name, contents := range map[string]string{
+		"Containerfile": `FROM fedora-rpm-buildroot:41 as buildrequires
+COPY . /src/
+`,
+		"poke.yaml":           "Name: poke",
+		"poke-4.3.tar.gz":     "tarball",
+		".git/HEAD":           "ref: refs/heads/main",
+		"poke.rpm":            "earlier build",
+		"poke-4.3-1.src.rpm":  "earlier build",
+		"logs/build.log":      "log",
+		"logs/keep.log":       "log",
+		"rpms/poke-devel.rpm": "earlier build",
+		"patches/0001.patch":  "patch",
+		"patches/0001.patch~": "backup",
+	} {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the patterns of a .containerignore and the output directories
+	excludes := []string{"logs", "!logs/keep.log", "**/*~", "*.rpm", "rpms"}
+	sources, err := StageSources(filepath.Join(dir, "Containerfile"), dir, "buildrequires", nil, excludes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"Containerfile", "logs/keep.log", "patches/0001.patch", "poke-4.3.tar.gz", "poke.yaml"}
+	if !slices.Equal(sources, expected) {
+		t.Errorf("expected %v, got %v", expected, sources)
+	}
+}
+
+func TestStageBaseImage(t *testing.T) {
+	containerfile := filepath.Join(t.TempDir(), "Containerfile")
+	err := os.WriteFile(containerfile, []byte(`ARG BUILDROOT=fedora-rpm-buildroot:rawhide
+FROM ${BUILDROOT} as buildrequires
+RUN dnf -y install gcc
+
+FROM localhost/poke-buildrequires as build
+`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		stage    string
+		args     map[string]string
+		expected string
+	}{
+		{"buildrequires", nil, "fedora-rpm-buildroot:rawhide"},
+		{"buildrequires", map[string]string{"BUILDROOT": "el-rpm-buildroot:9"}, "el-rpm-buildroot:9"},
+		{"build", nil, "localhost/poke-buildrequires"},
+	} {
+		img, err := StageBaseImage(containerfile, tc.stage, tc.args)
+		if err != nil {
+			t.Errorf("%s: %v", tc.stage, err)
+		} else if img != tc.expected {
+			t.Errorf("%s: expected %s, got %s", tc.stage, tc.expected, img)
+		}
+	}
+
+	if _, err := StageBaseImage(containerfile, "check", nil); err == nil {
+		t.Error("expected an error for a missing stage")
+	}
+}
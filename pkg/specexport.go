@@ -0,0 +1,409 @@
+package roci
+
+import (
+	"archive/tar"
+	"cmp"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/rpmpack"
+)
+
+// SpecPackage is a package of an exported spec file
+type SpecPackage struct {
+	// Config of the package after the preamble was inherited and the
+	// variables were expanded
+	Config RpmPackage
+	// Rpm is the package as roci assembles it, with the metadata from the
+	// image labels and the files of the stage
+	Rpm *Rpm
+	// Payload is the file name of the tarball with the files of the
+	// package, see WritePayloadTarball
+	Payload string
+}
+
+// ExportSpec writes a spec file that builds the same packages as roci into
+// `w`. The first package is the main package.
+//
+// The spec file does not build anything, its %install unpacks the payload
+// tarballs of the packages into the buildroot and the %files list every file
+// with its attributes. rpm's post processing of the buildroot is disabled, so
+// that the files end up in the packages as they are, and so is its dependency
+// generator, the spec file lists the dependencies of the packages instead.
+func ExportSpec(w io.Writer, pkgs []SpecPackage) error {
+	if len(pkgs) == 0 {
+		return fmt.Errorf("no packages to export")
+	}
+	main := pkgs[0]
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# generated by roci export-spec\n\n")
+	fmt.Fprintf(&b, "# the payload is installed as it was built, without stripping or\n# compressing anything\n")
+	fmt.Fprintf(&b, "%%global __os_install_post %%{nil}\n")
+	fmt.Fprintf(&b, "%%global debug_package %%{nil}\n")
+	fmt.Fprintf(&b, "%%global _build_id_links none\n\n")
+
+	if err := writeSpecPreamble(&b, main, nil); err != nil {
+		return fmt.Errorf("%s: %w", main.Rpm.Name, err)
+	}
+	for i, pkg := range pkgs {
+		fmt.Fprintf(&b, "Source%d: %s\n", i, specEscape(pkg.Payload))
+	}
+	fmt.Fprintf(&b, "\n%%description\n%s\n", specDescription(main.Rpm))
+
+	for _, pkg := range pkgs[1:] {
+		fmt.Fprintf(&b, "\n%%package -n %s\n", pkg.Rpm.Name)
+		if err := writeSpecPreamble(&b, pkg, main.Rpm); err != nil {
+			return fmt.Errorf("%s: %w", pkg.Rpm.Name, err)
+		}
+		fmt.Fprintf(&b, "\n%%description -n %s\n%s\n", pkg.Rpm.Name, specDescription(pkg.Rpm))
+	}
+
+	fmt.Fprintf(&b, "\n%%install\nmkdir -p %%{buildroot}\n")
+	for i := range pkgs {
+		fmt.Fprintf(&b, "tar -xzf %%{SOURCE%d} -C %%{buildroot}\n", i)
+	}
+
+	for i, pkg := range pkgs {
+		suffix := ""
+		if i > 0 {
+			suffix = " -n " + pkg.Rpm.Name
+		}
+		if err := writeSpecScriptlets(&b, pkg.Config, suffix); err != nil {
+			return fmt.Errorf("%s: %w", pkg.Rpm.Name, err)
+		}
+	}
+
+	for i, pkg := range pkgs {
+		suffix := ""
+		if i > 0 {
+			suffix = " -n " + pkg.Rpm.Name
+		}
+		fmt.Fprintf(&b, "\n%%files%s\n", suffix)
+		for _, name := range slices.Sorted(maps.Keys(pkg.Rpm.files)) {
+			fmt.Fprintf(&b, "%s\n", specFileEntry(pkg.Rpm.files[name]))
+		}
+	}
+
+	if len(main.Rpm.Changelog) > 0 {
+		fmt.Fprintf(&b, "\n%%changelog\n")
+		for i, e := range main.Rpm.Changelog {
+			if i > 0 {
+				b.WriteString("\n")
+			}
+			fmt.Fprintf(&b, "* %s %s\n%s\n", e.Time.UTC().Format("Mon Jan 02 2006"), specEscape(e.Name), specEscape(strings.TrimRight(e.Text, "\n")))
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeSpecPreamble writes the tags of the package `pkg`. Tags of subpackages
+// that have the same value as in the main package `main` are omitted.
+func writeSpecPreamble(b *strings.Builder, pkg SpecPackage, main *Rpm) error {
+	r := pkg.Rpm
+	tag := func(name, value, mainValue string) {
+		if value != "" && (main == nil || value != mainValue) {
+			fmt.Fprintf(b, "%s: %s\n", name, specEscape(value))
+		}
+	}
+
+	if main == nil {
+		tag("Name", r.Name, "")
+		tag("Version", r.Version, "")
+		tag("Release", r.Release, "")
+		if r.Epoch != 0 && r.Epoch != rpmpack.NoEpoch {
+			fmt.Fprintf(b, "Epoch: %d\n", r.Epoch)
+		}
+	}
+	tag("Summary", r.Summary, "")
+	var mainLicense, mainURL, mainGroup, mainVendor, mainPackager string
+	if main != nil {
+		mainLicense, mainURL, mainGroup, mainVendor, mainPackager = main.Licence, main.URL, main.Group, main.Vendor, main.Packager
+	}
+	tag("License", r.Licence, mainLicense)
+	tag("URL", r.URL, mainURL)
+	tag("Group", r.Group, mainGroup)
+	tag("Vendor", r.Vendor, mainVendor)
+	tag("Packager", r.Packager, mainPackager)
+	if pkg.Config.BuildArch != "" {
+		tag("BuildArch", pkg.Config.BuildArch, "")
+	}
+	// the dependencies are the ones that roci determined, see below
+	fmt.Fprintf(b, "AutoReqProv: no\n")
+
+	type specDeps struct {
+		tag  string
+		deps []string
+	}
+	deps := []specDeps{
+		{"Requires", pkg.Config.Requires},
+		{"Requires(pre)", pkg.Config.RequiresPre},
+		{"Requires(post)", pkg.Config.RequiresPost},
+		{"Requires(preun)", pkg.Config.RequiresPreUn},
+		{"Requires(postun)", pkg.Config.RequiresPostUn},
+		{"Requires(pretrans)", pkg.Config.RequiresPreTrans},
+		{"Requires(posttrans)", pkg.Config.RequiresPostTrans},
+		{"Requires(preuntrans)", pkg.Config.RequiresPreUnTrans},
+		{"Requires(postuntrans)", pkg.Config.RequiresPostUnTrans},
+		{"Requires(verify)", pkg.Config.RequiresVerify},
+		{"Requires(interp)", pkg.Config.RequiresInterp},
+		{"Requires(meta)", pkg.Config.RequiresMeta},
+	}
+	for _, q := range slices.Sorted(maps.Keys(pkg.Config.RequiresCombined.Requires)) {
+		deps = append(deps, specDeps{"Requires(" + q + ")", pkg.Config.RequiresCombined.Requires[q]})
+	}
+	deps = append(deps, []specDeps{
+		{"Provides", pkg.Config.Provides},
+		{"Conflicts", pkg.Config.Conflicts},
+		{"Obsoletes", pkg.Config.Obsoletes},
+		{"Recommends", pkg.Config.Recommends},
+		{"Suggests", pkg.Config.Suggests},
+		{"Supplements", pkg.Config.Supplements},
+		{"Enhances", pkg.Config.Enhances},
+		{"OrderWithRequires", pkg.Config.OrderWithRequires},
+	}...)
+	for _, d := range deps {
+		for _, dep := range d.deps {
+			fmt.Fprintf(b, "%s: %s\n", d.tag, specEscape(dep))
+		}
+	}
+
+	// rpm must not generate the dependencies itself, so the ones that roci
+	// detected in the files are added to those of the config
+	configDeps, err := RpmDependenciesFromConfig(pkg.Config)
+	if err != nil {
+		return err
+	}
+	type specRelations struct {
+		tag            string
+		rels, inConfig rpmpack.Relations
+	}
+	for _, d := range []specRelations{
+		{"Requires", r.Dependencies.Requires, configDeps.Requires},
+		{"Provides", r.Dependencies.Provides, configDeps.Provides},
+		{"Conflicts", r.Dependencies.Conflicts, configDeps.Conflicts},
+		{"Obsoletes", r.Dependencies.Obsoletes, configDeps.Obsoletes},
+		{"Recommends", r.Dependencies.Recommends, configDeps.Recommends},
+		{"Suggests", r.Dependencies.Suggests, configDeps.Suggests},
+		{"Supplements", r.Dependencies.Supplements, configDeps.Supplements},
+		{"Enhances", r.Dependencies.Enhances, configDeps.Enhances},
+		{"OrderWithRequires", r.Dependencies.OrderWithRequires, configDeps.OrderWithRequires},
+	} {
+		for _, rel := range d.rels {
+			if !slices.ContainsFunc(d.inConfig, rel.Equal) {
+				fmt.Fprintf(b, "%s: %s\n", d.tag, specEscape(specRelation(rel)))
+			}
+		}
+	}
+	return nil
+}
+
+// specRelation returns the dependency `rel` as it is written in a spec file
+func specRelation(rel *rpmpack.Relation) string {
+	op := rel.Sense & (rpmpack.SenseLess | rpmpack.SenseGreater | rpmpack.SenseEqual)
+	if op == rpmpack.SenseAny {
+		return rel.Name
+	}
+	return fmt.Sprintf("%s %s %s", rel.Name, op, rel.Version)
+}
+
+// specDescription returns the description of `r`, rpm requires one for every
+// package
+func specDescription(r *Rpm) string {
+	return specEscape(strings.TrimRight(cmp.Or(r.Description, r.Summary), "\n"))
+}
+
+// writeSpecScriptlets writes the scriptlets of `pkg`, `suffix` selects the
+// subpackage, e.g. " -n foo-devel"
+func writeSpecScriptlets(b *strings.Builder, pkg RpmPackage, suffix string) error {
+	sections := map[string]string{
+		"Pretrans":     "pretrans",
+		"Prein":        "pre",
+		"Postin":       "post",
+		"Preun":        "preun",
+		"Postun":       "postun",
+		"Posttrans":    "posttrans",
+		"VerifyScript": "verifyscript",
+	}
+	for _, s := range scriptlets(pkg) {
+		if s.scriptlet.IsEmpty() {
+			continue
+		}
+		prog, err := s.scriptlet.Interpreter()
+		if err != nil {
+			return fmt.Errorf("invalid %s scriptlet: %w", s.name, err)
+		}
+
+		fmt.Fprintf(b, "\n%%%s%s", sections[s.name], suffix)
+		if len(s.scriptlet.Options) > 0 {
+			fmt.Fprintf(b, " -p %s", strings.Join(prog, " "))
+		}
+		fmt.Fprintf(b, "\n")
+		if s.scriptlet.Script != "" {
+			fmt.Fprintf(b, "%s\n", specEscape(strings.TrimRight(s.scriptlet.Script, "\n")))
+		}
+	}
+	return nil
+}
+
+// specFileEntry returns the line of %files for `f`
+func specFileEntry(f RpmFile) string {
+	var directives []string
+	switch {
+	case f.Flags&rpmpack.NoReplaceFile != 0:
+		directives = append(directives, "%config(noreplace)")
+	case f.Flags&rpmpack.MissingOkFile != 0:
+		directives = append(directives, "%config(missingok)")
+	case f.Flags&rpmpack.ConfigFile != 0:
+		directives = append(directives, "%config")
+	}
+	for _, d := range []struct {
+		flag      rpmpack.FileType
+		directive string
+	}{
+		{rpmpack.DocFile, "%doc"},
+		{rpmpack.LicenceFile, "%license"},
+		{rpmpack.GhostFile, "%ghost"},
+	} {
+		if f.Flags&d.flag != 0 {
+			directives = append(directives, d.directive)
+		}
+	}
+
+	mode := fmt.Sprintf("%04o", f.Mode&^modeTypeMask)
+	switch f.Mode & modeTypeMask {
+	case modeDir:
+		directives = append(directives, "%dir")
+	case modeSymlink:
+		// the permissions of symbolic links cannot be set
+		mode = "-"
+	case modeChar, modeBlock:
+		kind := "c"
+		if f.Mode&modeTypeMask == modeBlock {
+			kind = "b"
+		}
+		directives = append(directives, fmt.Sprintf("%%dev(%s, %d, %d)", kind, f.RDevMajor, f.RDevMinor))
+	}
+	directives = append(directives, fmt.Sprintf("%%attr(%s, %s, %s)", mode, f.Owner, f.Group))
+
+	// rpm globs the paths, so the glob characters have to be escaped
+	name := specEscape(f.Name)
+	for _, c := range []string{"\\", "*", "?", "[", "]", "{", "}"} {
+		name = strings.ReplaceAll(name, c, "\\"+c)
+	}
+	if strings.ContainsAny(name, " \t\"") {
+		name = `"` + strings.ReplaceAll(name, `"`, `\"`) + `"`
+	}
+	return strings.Join(directives, " ") + " " + name
+}
+
+// specEscape escapes the macros in `s`
+func specEscape(s string) string {
+	return strings.ReplaceAll(s, "%", "%%")
+}
+
+// WritePayloadTarball writes the files of `r` as a gzip compressed tarball into
+// `w`, from which the spec file of ExportSpec installs them. Device nodes are
+// omitted, as they cannot be created without privileges, the spec file creates
+// them via %dev.
+func (r *Rpm) WritePayloadTarball(w io.Writer) error {
+	gz, _, _, err := newPayloadCompressor("gzip", w)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(gz)
+
+	header := func(f RpmFile) *tar.Header {
+		return &tar.Header{
+			Name:    "." + f.Name,
+			Mode:    int64(f.Mode &^ modeTypeMask),
+			Uname:   f.Owner,
+			Gname:   f.Group,
+			ModTime: time.Unix(int64(f.MTime), 0),
+			Format:  tar.FormatPAX,
+		}
+	}
+	names := slices.Sorted(maps.Keys(r.files))
+	write := func(filter func(f RpmFile) bool, typeflag byte, fill func(hdr *tar.Header, f RpmFile)) error {
+		for _, name := range names {
+			f := r.files[name]
+			if !filter(f) {
+				continue
+			}
+			hdr := header(f)
+			hdr.Typeflag = typeflag
+			if fill != nil {
+				fill(hdr, f)
+			}
+			if err := tw.WriteHeader(hdr); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	fileType := func(t uint32) func(f RpmFile) bool {
+		return func(f RpmFile) bool { return f.Mode&modeTypeMask == t && f.HardlinkTo == "" }
+	}
+
+	// the contents of regular files are streamed in the order of Contents
+	written := make(map[string]bool)
+	if r.Contents != nil {
+		err := r.Contents(func(name string, contents io.Reader) error {
+			f, ok := r.files[name]
+			if !ok || f.HardlinkTo != "" || written[name] {
+				return nil
+			}
+			hdr := header(f)
+			hdr.Typeflag = tar.TypeReg
+			hdr.Size = f.Size
+			if err := tw.WriteHeader(hdr); err != nil {
+				return err
+			}
+			if _, err := io.Copy(tw, contents); err != nil {
+				return fmt.Errorf("failed to write %s: %w", name, err)
+			}
+			written[name] = true
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for _, name := range names {
+		if f := r.files[name]; f.Mode&modeTypeMask == modeReg && f.HardlinkTo == "" && f.Flags&rpmpack.GhostFile == 0 && !written[name] {
+			return fmt.Errorf("the contents of %s are missing", name)
+		}
+	}
+
+	if err := write(fileType(modeSymlink), tar.TypeSymlink, func(hdr *tar.Header, f RpmFile) { hdr.Linkname = f.LinkTo }); err != nil {
+		return err
+	}
+	if err := write(fileType(modeFifo), tar.TypeFifo, nil); err != nil {
+		return err
+	}
+	err = write(func(f RpmFile) bool { return f.HardlinkTo != "" }, tar.TypeLink, func(hdr *tar.Header, f RpmFile) {
+		hdr.Linkname = "." + f.HardlinkTo
+	})
+	if err != nil {
+		return err
+	}
+
+	// directories come last and the subdirectories before their parents,
+	// so that their attributes are not those of the implicitly created ones
+	// and read only directories can still be filled
+	slices.Reverse(names)
+	if err := write(fileType(modeDir), tar.TypeDir, nil); err != nil {
+		return err
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
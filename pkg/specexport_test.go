@@ -0,0 +1,145 @@
+package roci
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/rpmpack"
+)
+
+func TestExportSpec(t *testing.T) {
+	mtime := time.Unix(1700000000, 0)
+	layer := makeLayer(t,
+		tarEntry{hdr: tar.Header{Name: "usr/bin/", Typeflag: tar.TypeDir, Mode: 0o755, ModTime: mtime}},
+		tarEntry{hdr: tar.Header{Name: "usr/bin/poke", Typeflag: tar.TypeReg, Mode: 0o755, ModTime: mtime}, body: "#!/bin/sh\necho 100%\n"},
+		tarEntry{hdr: tar.Header{Name: "usr/bin/apoke", Typeflag: tar.TypeLink, Linkname: "usr/bin/poke", Mode: 0o755, ModTime: mtime}},
+		tarEntry{hdr: tar.Header{Name: "usr/bin/pk", Typeflag: tar.TypeSymlink, Linkname: "poke", Mode: 0o777, ModTime: mtime}},
+		tarEntry{hdr: tar.Header{Name: "usr/share/poke/a b*", Typeflag: tar.TypeReg, Mode: 0o640, Uname: "poke", Gname: "users", ModTime: mtime}, body: "data"},
+		tarEntry{hdr: tar.Header{Name: "dev/null", Typeflag: tar.TypeChar, Mode: 0o666, Devmajor: 1, Devminor: 3, ModTime: mtime}},
+	)
+	main := rpmFromLayer(t, layer.Bytes(), rpmpack.RPMMetaData{
+		Name: "poke", Version: "4.3", Release: "2.fc41", Epoch: 1,
+		Summary: "Binary editor", Description: "GNU poke edits 100% of binary data.\n",
+		Licence: "GPL-3.0-or-later", URL: "https://www.jemarch.net/poke",
+	})
+	main.Changelog = []ChangelogEntry{
+		{Time: time.Date(2024, 3, 4, 12, 0, 0, 0, time.UTC), Name: "Joe <joe@example.com> - 4.3-2", Text: "- Fix the build"},
+		{Time: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC), Name: "Joe <joe@example.com> - 4.3-1", Text: "- Initial import"},
+	}
+
+	devel := rpmFromLayer(t, makeLayer(t,
+		tarEntry{hdr: tar.Header{Name: "usr/include/poke.h", Typeflag: tar.TypeReg, Mode: 0o644, ModTime: mtime}, body: "int poke;"},
+	).Bytes(), rpmpack.RPMMetaData{
+		Name: "poke-devel", Version: "4.3", Release: "2.fc41",
+		Summary: "Development files", Licence: "GPL-3.0-or-later", URL: "https://www.jemarch.net/poke",
+	})
+
+	// the dependencies of the config and the ones that rpmdeps found
+	main.Dependencies.Append(RpmDependencies{
+		Requires: rpmpack.Relations{
+			{Name: "poke-data", Version: "4.3", Sense: rpmpack.SenseEqual},
+			{Name: "libc.so.6()(64bit)"},
+		},
+		Provides: rpmpack.Relations{{Name: "poke(x86-64)", Version: "1:4.3-2.fc41", Sense: rpmpack.SenseEqual}},
+	})
+
+	var mainCfg, develCfg RpmPackage
+	mainCfg.Requires = []string{"poke-data = 4.3"}
+	mainCfg.RequiresCombined.Requires = map[string][]string{"post,postun": {"glibc"}}
+	mainCfg.Postin = RpmScriptlet{Options: []string{"-p", "/sbin/ldconfig"}}
+	mainCfg.Preun = RpmScriptlet{Script: "rm -f /tmp/poke\n"}
+	develCfg.Requires = []string{"poke = 1:4.3-2.fc41"}
+	develCfg.Postin = RpmScriptlet{Options: []string{"-p", "<lua>"}, Script: "print(\"hi\")\n"}
+
+	var spec strings.Builder
+	err := ExportSpec(&spec, []SpecPackage{
+		{Config: mainCfg, Rpm: main, Payload: "poke-payload.tar.gz"},
+		{Config: develCfg, Rpm: devel, Payload: "poke-devel-payload.tar.gz"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, s := range []string{
+		"%global __os_install_post %{nil}\n",
+		"Name: poke\nVersion: 4.3\nRelease: 2.fc41\nEpoch: 1\nSummary: Binary editor\nLicense: GPL-3.0-or-later\nURL: https://www.jemarch.net/poke\n",
+		"AutoReqProv: no\nRequires: poke-data = 4.3\nRequires(post,postun): glibc\nRequires: libc.so.6()(64bit)\nProvides: poke(x86-64) = 1:4.3-2.fc41\nSource0:",
+		"Source0: poke-payload.tar.gz\nSource1: poke-devel-payload.tar.gz\n",
+		"%description\nGNU poke edits 100%% of binary data.\n",
+		"%package -n poke-devel\nSummary: Development files\nAutoReqProv: no\nRequires: poke = 1:4.3-2.fc41\n\n%description -n poke-devel\nDevelopment files\n",
+		"tar -xzf %{SOURCE0} -C %{buildroot}\ntar -xzf %{SOURCE1} -C %{buildroot}\n",
+		"%post -p /sbin/ldconfig\n\n%preun\nrm -f /tmp/poke\n",
+		"%post -n poke-devel -p <lua>\nprint(\"hi\")\n",
+		"%files\n%dev(c, 1, 3) %attr(0666, root, root) /dev/null\n%dir %attr(0755, root, root) /usr/bin\n",
+		"%attr(0755, root, root) /usr/bin/apoke\n",
+		"%attr(-, root, root) /usr/bin/pk\n",
+		"%attr(0640, poke, users) \"/usr/share/poke/a b\\*\"\n",
+		"%files -n poke-devel\n%attr(0644, root, root) /usr/include/poke.h\n",
+		"%changelog\n* Mon Mar 04 2024 Joe <joe@example.com> - 4.3-2\n- Fix the build\n\n* Fri Mar 01 2024 Joe <joe@example.com> - 4.3-1\n- Initial import\n",
+	} {
+		if !strings.Contains(spec.String(), s) {
+			t.Errorf("%q is not in the spec file:\n%s", s, spec.String())
+		}
+	}
+	// the subpackage inherits these from the main package
+	if _, devel, _ := strings.Cut(spec.String(), "%package -n poke-devel"); strings.Contains(strings.Split(devel, "%description")[0], "License:") {
+		t.Errorf("the subpackage repeats the License:\n%s", spec.String())
+	}
+}
+
+func TestWritePayloadTarball(t *testing.T) {
+	mtime := time.Unix(1700000000, 0)
+	layer := makeLayer(t,
+		tarEntry{hdr: tar.Header{Name: "usr/", Typeflag: tar.TypeDir, Mode: 0o755, ModTime: mtime}},
+		tarEntry{hdr: tar.Header{Name: "usr/bin/", Typeflag: tar.TypeDir, Mode: 0o555, ModTime: mtime}},
+		tarEntry{hdr: tar.Header{Name: "usr/bin/poke", Typeflag: tar.TypeReg, Mode: 0o755, ModTime: mtime}, body: "poke"},
+		tarEntry{hdr: tar.Header{Name: "usr/bin/apoke", Typeflag: tar.TypeLink, Linkname: "usr/bin/poke", Mode: 0o755, ModTime: mtime}},
+		tarEntry{hdr: tar.Header{Name: "usr/bin/pk", Typeflag: tar.TypeSymlink, Linkname: "poke", Mode: 0o777, ModTime: mtime}},
+		tarEntry{hdr: tar.Header{Name: "dev/null", Typeflag: tar.TypeChar, Mode: 0o666, Devmajor: 1, Devminor: 3, ModTime: mtime}},
+	)
+	rpm := rpmFromLayer(t, layer.Bytes(), rpmpack.RPMMetaData{Name: "poke", Version: "4.3"})
+
+	var buf bytes.Buffer
+	if err := rpm.WritePayloadTarball(&buf); err != nil {
+		t.Fatal(err)
+	}
+	gz, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var entries []string
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(tr)
+		entries = append(entries, string(hdr.Typeflag)+" "+hdr.Name+" "+hdr.Linkname+" "+string(body))
+		if !hdr.ModTime.Equal(mtime) {
+			t.Errorf("%s has the mtime %v", hdr.Name, hdr.ModTime)
+		}
+	}
+
+	// the hardlinks come after their target and the directories after
+	// their contents, device nodes are created by rpm
+	expected := []string{
+		string(tar.TypeReg) + " ./usr/bin/poke  poke",
+		string(tar.TypeSymlink) + " ./usr/bin/pk poke ",
+		string(tar.TypeLink) + " ./usr/bin/apoke ./usr/bin/poke ",
+		string(tar.TypeDir) + " ./usr/bin  ",
+		string(tar.TypeDir) + " ./usr  ",
+	}
+	if strings.Join(entries, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected the entries\n%s\ngot\n%s", strings.Join(expected, "\n"), strings.Join(entries, "\n"))
+	}
+}
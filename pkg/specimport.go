@@ -0,0 +1,857 @@
+package roci
+
+import (
+	"bufio"
+	"cmp"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// SpecImport is a spec file translated into a config file and a Containerfile
+type SpecImport struct {
+	// Name of the main package with the spec file's macros expanded, the
+	// config file must be called Name.yaml
+	Name          string
+	Config        *Config
+	Containerfile string
+	// Report lists everything that could not be translated and needs to be
+	// done by hand
+	Report []string
+}
+
+// specPathMacros are the values of rpm's path macros on Fedora. They are
+// replaced in the Containerfile and defined as variables in the config, if it
+// uses them.
+var specPathMacros = builtinDistro("fedora").Macros
+
+// specBuildroot is the directory in the build stage into which %install
+// installs the files, i.e. %{buildroot}
+const specBuildroot = "/buildroot"
+
+// specScriptlets maps the scriptlet sections to the keys of the config
+var specScriptlets = map[string]string{
+	"pretrans":     "Pretrans",
+	"pre":          "Prein",
+	"post":         "Postin",
+	"preun":        "Preun",
+	"postun":       "Postun",
+	"posttrans":    "Posttrans",
+	"verifyscript": "VerifyScript",
+}
+
+// specBuildSections are the sections that are translated into the build
+// stage, in the order in which rpmbuild runs them
+var specBuildSections = []string{"prep", "conf", "build", "install", "check"}
+
+// specUnsupportedSections are sections that are recognized, but not imported
+var specUnsupportedSections = []string{
+	"clean", "preuntrans", "postuntrans", "generate_buildrequires",
+	"sourcelist", "patchlist", "trigger", "triggerin", "triggerun",
+	"triggerpostun", "triggerprein", "filetriggerin", "filetriggerun",
+	"filetriggerpostun", "transfiletriggerin", "transfiletriggerun",
+	"transfiletriggerpostun",
+}
+
+var (
+	specSectionRe = regexp.MustCompile(`^%([a-z_]+)(?:\s+(.*))?$`)
+	specTagRe     = regexp.MustCompile(`^([A-Za-z][A-Za-z0-9]*)\s*(?:\(([^)]*)\))?\s*:\s*(.*)$`)
+	specMacroRe   = regexp.MustCompile(`%\{([!?]*)([A-Za-z_][A-Za-z0-9_]*)(?::[^}]*)?\}|%([A-Za-z_][A-Za-z0-9_]*)`)
+	specDirective = regexp.MustCompile(`^%([a-z]+)(\([^)]*\))?\s*`)
+	// specDistReleaseRe matches the usual Release: 1%{?dist}
+	specDistReleaseRe = regexp.MustCompile(`^[0-9][0-9.]*%\{\??dist\}$`)
+)
+
+// specSection is the section of the spec file that is currently parsed
+type specSection struct {
+	name string
+	// pkg is the full name of the package that the section belongs to,
+	// "" for the main package
+	pkg string
+	// args of the section header, e.g. the interpreter of a scriptlet
+	args  map[string]string
+	line  int
+	lines []string
+	// numbers are the line numbers of lines in the spec file
+	numbers []int
+}
+
+// specReport is an entry of the report, line is 0 if it concerns the whole
+// spec file
+type specReport struct {
+	line int
+	msg  string
+}
+
+// specFileLine is a line of a %files section
+type specFileLine struct {
+	line int
+	text string
+}
+
+type specImporter struct {
+	cfg    Config
+	report []specReport
+	macros map[string]string
+
+	sources       []string
+	patches       []string
+	buildRequires []string
+	buildScripts  []*specSection
+	// subpackages in the order of the spec file
+	subpackages []string
+	packages    map[string]*RpmPackage
+	files       map[string][]specFileLine
+	// buildDir is the directory that %setup extracts the sources into
+	buildDir string
+}
+
+// ImportSpec translates the spec file `r` into a config file and a
+// Containerfile with the stages `buildrequires`, `build` and one stage per
+// package.
+//
+// rpm's macros cannot be evaluated without rpm, so conditionals always use
+// their first branch and macros that are not defined in the spec file are
+// only replaced if they are well known. Everything else ends up in the
+// report.
+func ImportSpec(r io.Reader) (*SpecImport, error) {
+	s := &specImporter{
+		macros:   make(map[string]string),
+		packages: make(map[string]*RpmPackage),
+		files:    make(map[string][]specFileLine),
+	}
+	s.packages[""] = &s.cfg.RpmPackage
+
+	// every condition is a stack entry that is true while its lines are
+	// imported
+	var conditions []bool
+	active := func() bool { return !slices.Contains(conditions, false) }
+
+	// a macro definition that continues on the next line
+	var definition string
+	definitionLine := 0
+
+	current := &specSection{name: "preamble"}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		directive, rest, _ := strings.Cut(strings.Join(strings.Fields(trimmed), " "), " ")
+
+		if definition != "" {
+			definition += "\n" + line
+			if specDefinitionComplete(definition) {
+				s.defineMacro(definitionLine, definition)
+				definition = ""
+			}
+			continue
+		}
+
+		switch {
+		case slices.Contains([]string{"%if", "%ifarch", "%ifnarch", "%ifos", "%ifnos"}, directive):
+			if active() {
+				s.reportf(n, "the condition %q cannot be evaluated, only its first branch is imported", trimmed)
+			}
+			conditions = append(conditions, true)
+			continue
+		case slices.Contains([]string{"%elif", "%elifarch", "%elifos", "%else"}, directive):
+			if len(conditions) > 0 {
+				conditions[len(conditions)-1] = false
+			}
+			continue
+		case directive == "%endif":
+			if len(conditions) > 0 {
+				conditions = conditions[:len(conditions)-1]
+			}
+			continue
+		case !active():
+			continue
+		case directive == "%global" || directive == "%define":
+			if !specDefinitionComplete(rest) {
+				definition, definitionLine = rest, n
+				continue
+			}
+			s.defineMacro(n, rest)
+			continue
+		}
+
+		if m := specSectionRe.FindStringSubmatch(trimmed); m != nil && s.isSection(m[1]) {
+			s.finishSection(current)
+			current = s.startSection(n, m[1], m[2])
+			continue
+		}
+		current.lines = append(current.lines, line)
+		current.numbers = append(current.numbers, n)
+		if current.name == "preamble" || current.name == "package" {
+			s.preambleLine(n, current.pkg, trimmed)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if definition != "" {
+		s.defineMacro(definitionLine, definition)
+	}
+	s.finishSection(current)
+
+	if s.cfg.Name == "" {
+		return nil, fmt.Errorf("the spec file has no Name")
+	}
+
+	containerfile := s.containerfile()
+	s.finishConfig()
+
+	return &SpecImport{
+		Name:          s.expand(s.cfg.Name),
+		Config:        &s.cfg,
+		Containerfile: containerfile,
+		Report:        s.sortedReport(),
+	}, nil
+}
+
+func (s *specImporter) reportf(line int, format string, args ...any) {
+	s.report = append(s.report, specReport{line, fmt.Sprintf(format, args...)})
+}
+
+// sortedReport returns the report sorted by line, followed by the entries
+// that concern the whole spec file
+func (s *specImporter) sortedReport() []string {
+	slices.SortStableFunc(s.report, func(a, b specReport) int {
+		return cmp.Compare(cmp.Or(a.line, math.MaxInt), cmp.Or(b.line, math.MaxInt))
+	})
+	report := make([]string, len(s.report))
+	for i, r := range s.report {
+		report[i] = r.msg
+		if r.line > 0 {
+			report[i] = fmt.Sprintf("line %d: %s", r.line, r.msg)
+		}
+	}
+	return report
+}
+
+func (s *specImporter) isSection(name string) bool {
+	_, scriptlet := specScriptlets[name]
+	return scriptlet || slices.Contains(specBuildSections, name) || slices.Contains(specUnsupportedSections, name) ||
+		slices.Contains([]string{"package", "description", "files", "changelog"}, name)
+}
+
+// specDefinitionComplete returns whether the macro definition `definition`
+// ends in its current line, i.e. it neither ends with a backslash nor has
+// unclosed braces
+func specDefinitionComplete(definition string) bool {
+	return !strings.HasSuffix(strings.TrimSpace(definition), "\\") &&
+		strings.Count(definition, "{") <= strings.Count(definition, "}")
+}
+
+// defineMacro handles `%global name value`
+func (s *specImporter) defineMacro(line int, definition string) {
+	name, value, _ := strings.Cut(strings.TrimSpace(definition), " ")
+	value = strings.TrimSpace(value)
+	switch {
+	case strings.Contains(name, "("):
+		s.reportf(line, "the parametric macro %s is not supported", name)
+	case strings.Contains(value, "\n"):
+		s.reportf(line, "the multi line macro %s is not supported", name)
+	case slices.Contains(BuiltinVariables, strings.ToUpper(name)):
+		s.reportf(line, "the macro %s cannot be redefined", name)
+	default:
+		s.macros[name] = value
+	}
+}
+
+// startSection parses the header of the section `name` with the arguments
+// `args`, e.g. `%package -n foo` or `%post devel -p /sbin/ldconfig`
+func (s *specImporter) startSection(line int, name, args string) *specSection {
+	section := &specSection{name: name, line: line, args: make(map[string]string)}
+	if slices.Contains(specUnsupportedSections, name) {
+		s.reportf(line, "%%%s is not supported and ignored", name)
+		section.name = "ignored"
+		return section
+	}
+
+	fields := strings.Fields(args)
+	for i := 0; i < len(fields); i++ {
+		switch f := fields[i]; {
+		case f == "-n" && i+1 < len(fields):
+			i++
+			section.pkg = s.expand(fields[i])
+			section.args["name"] = fields[i]
+		case f == "-p" && i+1 < len(fields):
+			// the interpreter may have arguments
+			section.args["interpreter"] = strings.Join(fields[i+1:], " ")
+			i = len(fields)
+		case f == "-f" && i+1 < len(fields):
+			i++
+			s.reportf(line, "the file list %s of %%files is not supported", fields[i])
+		case strings.HasPrefix(f, "-"):
+			s.reportf(line, "the option %s of %%%s is not supported", f, name)
+		default:
+			section.pkg = s.expand(s.cfg.Name) + "-" + s.expand(f)
+			section.args["name"] = "-" + f
+		}
+	}
+
+	if name == "package" && section.pkg != "" {
+		if _, ok := s.packages[section.pkg]; !ok {
+			s.packages[section.pkg] = &RpmPackage{}
+			s.subpackages = append(s.subpackages, section.pkg)
+		}
+		// shorthand names are kept, so that the relation to the main
+		// package is visible
+		if strings.HasPrefix(section.args["name"], "-") {
+			s.packages[section.pkg].Name = section.args["name"]
+		}
+	}
+	if _, ok := s.packages[section.pkg]; !ok && name != "package" && !slices.Contains(specBuildSections, name) && name != "changelog" {
+		s.reportf(line, "%%%s of the undeclared package %s is ignored", name, section.pkg)
+		section.name = "ignored"
+	}
+	return section
+}
+
+// finishSection stores the contents of the section
+func (s *specImporter) finishSection(section *specSection) {
+	text := strings.Trim(strings.Join(section.lines, "\n"), "\n")
+	pkg := s.packages[section.pkg]
+
+	switch section.name {
+	case "description":
+		pkg.Description = s.configValue(strings.TrimSpace(text)) + "\n"
+	case "changelog":
+		if strings.TrimSpace(text) != "%autochangelog" {
+			s.cfg.Changelog = strings.TrimSpace(text) + "\n"
+		}
+	case "files":
+		for i, l := range section.lines {
+			s.files[section.pkg] = append(s.files[section.pkg], specFileLine{section.numbers[i], l})
+		}
+		if _, ok := s.files[section.pkg]; !ok {
+			s.files[section.pkg] = nil
+		}
+	default:
+		if key, ok := specScriptlets[section.name]; ok {
+			scriptlet := RpmScriptlet{Script: strings.TrimSpace(text)}
+			if scriptlet.Script != "" {
+				scriptlet.Script += "\n"
+			}
+			if interp := section.args["interpreter"]; interp != "" {
+				scriptlet.Options = []string{"-p", interp}
+			}
+			s.setScriptlet(pkg, key, scriptlet)
+		} else if slices.Contains(specBuildSections, section.name) {
+			s.buildScripts = append(s.buildScripts, section)
+		}
+	}
+}
+
+func (s *specImporter) setScriptlet(pkg *RpmPackage, key string, scriptlet RpmScriptlet) {
+	switch key {
+	case "Pretrans":
+		pkg.Pretrans = scriptlet
+	case "Prein":
+		pkg.Prein = scriptlet
+	case "Postin":
+		pkg.Postin = scriptlet
+	case "Preun":
+		pkg.Preun = scriptlet
+	case "Postun":
+		pkg.Postun = scriptlet
+	case "Posttrans":
+		pkg.Posttrans = scriptlet
+	case "VerifyScript":
+		pkg.VerifyScript = scriptlet
+	}
+}
+
+// preambleLine imports a `Tag: value` line of the package `name`
+func (s *specImporter) preambleLine(line int, name, text string) {
+	if text == "" || strings.HasPrefix(text, "#") {
+		return
+	}
+	m := specTagRe.FindStringSubmatch(text)
+	if m == nil {
+		s.reportf(line, "cannot parse %q", text)
+		return
+	}
+	tag, qualifiers, value := strings.ToLower(m[1]), strings.ToLower(strings.ReplaceAll(m[2], " ", "")), strings.TrimSpace(m[3])
+	pkg := s.packages[name]
+	v := s.configValue(value)
+
+	switch tag {
+	case "name":
+		pkg.Name = v
+	case "version":
+		pkg.Version = v
+	case "release":
+		// %{?dist} is the release of the distribution in roci, the
+		// dist tag is part of $AUTORELEASE
+		if specDistReleaseRe.MatchString(v) {
+			s.reportf(line, "Release %s is replaced by $AUTORELEASE, the release number from the git history with the dist tag", value)
+			v = "$AUTORELEASE"
+		}
+		pkg.Release = v
+	case "epoch":
+		epoch, err := strconv.Atoi(value)
+		if err != nil {
+			s.reportf(line, "invalid Epoch %q", value)
+		}
+		pkg.Epoch = epoch
+	case "summary":
+		pkg.Summary = v
+	case "license":
+		pkg.License = v
+	case "sourcelicense":
+		pkg.SourceLicense = v
+	case "group":
+		pkg.Group = v
+	case "url":
+		pkg.URL = v
+	case "bugurl":
+		pkg.BugURL = v
+	case "vcs":
+		pkg.VCS = v
+	case "vendor":
+		pkg.Vendor = v
+	case "packager":
+		pkg.Packager = v
+	case "distribution":
+		pkg.Distribution = v
+	case "disttag":
+		pkg.DistTag = v
+	case "icon":
+		pkg.Icon = v
+	case "buildarch", "buildarchitectures":
+		pkg.BuildArch = v
+	case "docdir":
+		pkg.DocDir = v
+	case "excludearch":
+		pkg.ExcludeArch = append(pkg.ExcludeArch, strings.Fields(v)...)
+	case "exclusivearch":
+		pkg.ExclusiveArch = append(pkg.ExclusiveArch, strings.Fields(v)...)
+	case "excludeos":
+		pkg.ExcludeOS = append(pkg.ExcludeOS, strings.Fields(v)...)
+	case "exclusiveos":
+		pkg.ExclusiveOS = append(pkg.ExclusiveOS, strings.Fields(v)...)
+	case "prefix", "prefixes":
+		pkg.Prefixes = append(pkg.Prefixes, strings.Fields(v)...)
+	case "requires":
+		s.addRequires(line, pkg, qualifiers, splitSpecDependencies(v))
+	case "provides":
+		pkg.Provides = append(pkg.Provides, splitSpecDependencies(v)...)
+	case "conflicts":
+		pkg.Conflicts = append(pkg.Conflicts, splitSpecDependencies(v)...)
+	case "obsoletes":
+		pkg.Obsoletes = append(pkg.Obsoletes, splitSpecDependencies(v)...)
+	case "recommends":
+		pkg.Recommends = append(pkg.Recommends, splitSpecDependencies(v)...)
+	case "suggests":
+		pkg.Suggests = append(pkg.Suggests, splitSpecDependencies(v)...)
+	case "supplements":
+		pkg.Supplements = append(pkg.Supplements, splitSpecDependencies(v)...)
+	case "enhances":
+		pkg.Enhances = append(pkg.Enhances, splitSpecDependencies(v)...)
+	case "orderwithrequires":
+		pkg.OrderWithRequires = append(pkg.OrderWithRequires, splitSpecDependencies(v)...)
+	case "buildrequires":
+		s.buildRequires = append(s.buildRequires, splitSpecDependencies(s.expand(value))...)
+	default:
+		switch {
+		case strings.HasPrefix(tag, "source"):
+			s.sources = append(s.sources, s.sourceFile(line, value))
+		case strings.HasPrefix(tag, "patch"):
+			s.patches = append(s.patches, s.sourceFile(line, value))
+		default:
+			s.reportf(line, "%s is not supported", m[1])
+		}
+	}
+}
+
+// addRequires adds the dependencies of `Requires(qualifiers)`
+func (s *specImporter) addRequires(line int, pkg *RpmPackage, qualifiers string, deps []string) {
+	fields := map[string]*[]string{
+		"":            &pkg.Requires,
+		"pre":         &pkg.RequiresPre,
+		"post":        &pkg.RequiresPost,
+		"preun":       &pkg.RequiresPreUn,
+		"postun":      &pkg.RequiresPostUn,
+		"pretrans":    &pkg.RequiresPreTrans,
+		"posttrans":   &pkg.RequiresPostTrans,
+		"preuntrans":  &pkg.RequiresPreUnTrans,
+		"postuntrans": &pkg.RequiresPostUnTrans,
+		"verify":      &pkg.RequiresVerify,
+		"interp":      &pkg.RequiresInterp,
+		"meta":        &pkg.RequiresMeta,
+	}
+	if field, ok := fields[qualifiers]; ok {
+		*field = append(*field, deps...)
+		return
+	}
+	if _, err := RequiresQualifierSense(qualifiers); err != nil {
+		s.reportf(line, "%v", err)
+		return
+	}
+	if pkg.RequiresCombined.Requires == nil {
+		pkg.RequiresCombined.Requires = make(map[string][]string)
+	}
+	pkg.RequiresCombined.Requires[qualifiers] = append(pkg.RequiresCombined.Requires[qualifiers], deps...)
+}
+
+// splitSpecDependencies splits the value of a dependency tag, which may
+// contain several dependencies separated by whitespace or commas, e.g.
+// "foo >= 1.0, bar"
+func splitSpecDependencies(value string) []string {
+	value = strings.TrimSpace(value)
+	// rich dependencies are kept as they are
+	if strings.HasPrefix(value, "(") {
+		return []string{value}
+	}
+
+	var deps []string
+	tokens := strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' })
+	for i := 0; i < len(tokens); i++ {
+		if slices.Contains([]string{"<", ">", "=", "<=", ">=", "=="}, tokens[i]) && len(deps) > 0 && i+1 < len(tokens) {
+			deps[len(deps)-1] += " " + tokens[i] + " " + tokens[i+1]
+			i++
+			continue
+		}
+		deps = append(deps, tokens[i])
+	}
+	return deps
+}
+
+// sourceFile returns the name of the file of a Source or Patch tag in the
+// dist-git directory
+func (s *specImporter) sourceFile(line int, value string) string {
+	name := value
+	if strings.Contains(value, "://") {
+		s.reportf(line, "%s must be downloaded into the dist-git directory", value)
+		// a fragment can set the file name, e.g. url#/foo.tar.gz
+		if _, fragment, ok := strings.Cut(value, "#"); ok {
+			name = fragment
+		}
+	}
+	return s.containerfileValue(path.Base(name))
+}
+
+// expand replaces the macros in `value` that are defined in the spec file
+func (s *specImporter) expand(value string) string {
+	macros := maps.Clone(s.macros)
+	for _, m := range []struct{ name, value string }{
+		{"name", s.cfg.Name}, {"version", s.cfg.Version}, {"release", s.cfg.Release},
+	} {
+		if m.value != "" {
+			macros[m.name] = m.value
+		}
+	}
+
+	// macros may reference other macros
+	for range 10 {
+		expanded := specMacroRe.ReplaceAllStringFunc(value, func(macro string) string {
+			m := specMacroRe.FindStringSubmatch(macro)
+			name := m[2] + m[3]
+			if v, ok := macros[name]; ok && !strings.Contains(m[1], "!") && !strings.Contains(macro, ":") {
+				return v
+			}
+			return macro
+		})
+		if expanded == value {
+			break
+		}
+		value = expanded
+	}
+	return value
+}
+
+// configValue converts the spec value `value` into a value of the config:
+// macros are written as %{name}, %autorelease becomes $AUTORELEASE and $ is
+// escaped
+func (s *specImporter) configValue(value string) string {
+	value = strings.ReplaceAll(value, "$", "$$")
+	value = specMacroRe.ReplaceAllStringFunc(value, func(macro string) string {
+		m := specMacroRe.FindStringSubmatch(macro)
+		switch {
+		case m[2] == "autorelease" || m[3] == "autorelease":
+			return "$AUTORELEASE"
+		case m[3] != "":
+			return "%{" + m[3] + "}"
+		}
+		return macro
+	})
+	return value
+}
+
+// containerfileValue converts the spec value `value` for the Containerfile:
+// name, version and release are the build arguments, well known macros are
+// replaced by their values
+func (s *specImporter) containerfileValue(value string) string {
+	for range 10 {
+		expanded := specMacroRe.ReplaceAllStringFunc(value, func(macro string) string {
+			m := specMacroRe.FindStringSubmatch(macro)
+			name := m[2] + m[3]
+			if strings.Contains(macro, ":") || strings.Contains(m[1], "!") {
+				return macro
+			}
+			switch name {
+			case "name", "version", "release":
+				return "${" + strings.ToUpper(name) + "}"
+			case "buildroot":
+				return specBuildroot
+			case "_smp_mflags":
+				return "-j$(nproc)"
+			case "__make":
+				return "make"
+			case "make_build":
+				return "make -j$(nproc) V=1 VERBOSE=1"
+			case "make_install":
+				return "make install DESTDIR=" + specBuildroot + ` INSTALL="install -p"`
+			}
+			if v, ok := specPathMacros[name]; ok {
+				return v
+			}
+			if v, ok := s.macros[name]; ok {
+				return v
+			}
+			return macro
+		})
+		if expanded == value {
+			break
+		}
+		value = expanded
+	}
+	return strings.ReplaceAll(strings.ReplaceAll(value, "${RPM_BUILD_ROOT}", specBuildroot), "$RPM_BUILD_ROOT", specBuildroot)
+}
+
+// reportMacros reports the macros that remain in the line `text` of the
+// Containerfile
+func (s *specImporter) reportMacros(line int, text string) {
+	for _, m := range specMacroRe.FindAllStringSubmatch(strings.ReplaceAll(text, "%%", ""), -1) {
+		if strings.Contains(m[1], "?") {
+			continue
+		}
+		s.reportf(line, "the rpm macro %s cannot be translated", m[0])
+	}
+}
+
+// containerfile generates the Containerfile
+func (s *specImporter) containerfile() string {
+	var b strings.Builder
+	name := s.expand(s.cfg.Name)
+	fmt.Fprintf(&b, "# generated by roci import-spec, see its report for what has to be done by hand\n")
+	fmt.Fprintf(&b, "ARG BUILDROOT\n")
+	fmt.Fprintf(&b, "FROM ${BUILDROOT} as buildrequires\n")
+	fmt.Fprintf(&b, "ARG NAME\nARG VERSION\nARG RELEASE\n\n")
+	fmt.Fprintf(&b, "WORKDIR /src/\n")
+	for _, src := range append(slices.Clone(s.sources), s.patches...) {
+		fmt.Fprintf(&b, "COPY %s .\n", src)
+	}
+	if len(s.buildRequires) > 0 {
+		quoted := make([]string, len(s.buildRequires))
+		for i, dep := range s.buildRequires {
+			quoted[i] = "'" + s.containerfileValue(dep) + "'"
+		}
+		fmt.Fprintf(&b, "\nRUN dnf -y install %s\n", strings.Join(quoted, " "))
+	}
+
+	fmt.Fprintf(&b, "\nFROM localhost/%s-buildrequires as build\n", name)
+	fmt.Fprintf(&b, "ARG NAME\nARG VERSION\nARG RELEASE\n")
+	workdir := "/src"
+	for _, section := range s.buildScripts {
+		lines := s.buildScript(section)
+		if section.name == "prep" && s.buildDir != "" {
+			workdir = path.Join("/src", s.buildDir)
+		}
+		if len(lines) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "\n# %%%s\n", section.name)
+		if section.name == "install" {
+			fmt.Fprintf(&b, "RUN mkdir -p %s\n", specBuildroot)
+		}
+		if section.name != "prep" {
+			fmt.Fprintf(&b, "WORKDIR %s\n", workdir)
+		}
+		fmt.Fprintf(&b, "RUN <<'ROCI_EOF'\nset -ex\n%s\nROCI_EOF\n", strings.Join(lines, "\n"))
+	}
+
+	for _, pkg := range append([]string{""}, s.subpackages...) {
+		if _, ok := s.files[pkg]; !ok && pkg != "" {
+			s.reportf(0, "the package %s has no %%files and is not imported, as rpm would not build it", pkg)
+			delete(s.packages, pkg)
+			continue
+		}
+		stage := pkg
+		if stage == "" {
+			stage = name
+		}
+		fmt.Fprintf(&b, "\nFROM ${BUILDROOT} as %s\n", stage)
+		fmt.Fprintf(&b, "ARG NAME\nARG VERSION\nARG RELEASE\n")
+		for _, l := range s.files[pkg] {
+			for _, instr := range s.fileInstructions(stage, workdir, l) {
+				fmt.Fprintf(&b, "%s\n", instr)
+			}
+		}
+	}
+	return b.String()
+}
+
+// buildScript translates the lines of a build section for the Containerfile
+func (s *specImporter) buildScript(section *specSection) []string {
+	var lines []string
+	for i, l := range section.lines {
+		n := section.numbers[i]
+		fields := strings.Fields(l)
+		if len(fields) > 0 && (fields[0] == "%setup" || fields[0] == "%autosetup") {
+			lines = append(lines, s.setup(n, fields)...)
+			continue
+		}
+		l = s.containerfileValue(l)
+		s.reportMacros(n, l)
+		lines = append(lines, strings.ReplaceAll(l, "%%", "%"))
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	return lines
+}
+
+// setup translates %setup and %autosetup into extracting the first source and
+// for %autosetup applying all patches
+func (s *specImporter) setup(line int, fields []string) []string {
+	s.buildDir = "${NAME}-${VERSION}"
+	patchLevel := ""
+	for i := 1; i < len(fields); i++ {
+		switch f := fields[i]; {
+		case f == "-q":
+		case f == "-n" && i+1 < len(fields):
+			i++
+			s.buildDir = s.containerfileValue(fields[i])
+		case strings.HasPrefix(f, "-p") && fields[0] == "%autosetup":
+			patchLevel = f
+		default:
+			s.reportf(line, "the option %s of %s is not supported", f, fields[0])
+		}
+	}
+	if len(s.sources) == 0 {
+		s.reportf(line, "%s without a Source", fields[0])
+		return nil
+	}
+
+	lines := []string{"tar -xf /src/" + s.sources[0]}
+	if fields[0] == "%autosetup" {
+		for _, p := range s.patches {
+			lines = append(lines, fmt.Sprintf("patch -d %s %s < /src/%s", s.buildDir, cmp.Or(patchLevel, "-p1"), p))
+		}
+	}
+	return lines
+}
+
+// fileInstructions translates a line of %files of the package `pkg` into
+// Containerfile instructions
+func (s *specImporter) fileInstructions(pkg, buildDir string, l specFileLine) []string {
+	text := strings.TrimSpace(l.text)
+	if text == "" || strings.HasPrefix(text, "#") {
+		return nil
+	}
+
+	directives := make(map[string]bool)
+	for {
+		m := specDirective.FindStringSubmatch(text)
+		if m == nil {
+			break
+		}
+		directives[m[1]] = true
+		text = text[len(m[0]):]
+	}
+	delete(directives, "defattr")
+	for _, d := range slices.Sorted(maps.Keys(directives)) {
+		switch d {
+		case "dir", "doc", "license":
+		case "exclude":
+			s.reportf(l.line, "%%exclude is not supported, %s is not excluded", text)
+			return nil
+		default:
+			s.reportf(l.line, "%%%s is not supported, %s is copied as it is", d, text)
+		}
+	}
+
+	var instructions []string
+	for _, p := range strings.Fields(text) {
+		p = s.containerfileValue(p)
+		s.reportMacros(l.line, p)
+		switch {
+		case directives["dir"]:
+			instructions = append(instructions, "RUN mkdir -p "+p)
+		case !strings.HasPrefix(p, "/") && (directives["doc"] || directives["license"]):
+			// relative documentation is copied from the build directory
+			dest := path.Join(specPathMacros["_docdir"], pkg) + "/"
+			if directives["license"] {
+				dest = path.Join(specPathMacros["_licensedir"], pkg) + "/"
+			}
+			instructions = append(instructions, fmt.Sprintf("COPY --from=build %s %s", path.Join(buildDir, p), dest))
+		case !strings.HasPrefix(p, "/"):
+			s.reportf(l.line, "the relative path %s cannot be translated", p)
+		default:
+			dest := p
+			if strings.ContainsAny(p, "*?[") {
+				dest = path.Dir(p) + "/"
+			}
+			instructions = append(instructions, fmt.Sprintf("COPY --from=build %s%s %s", specBuildroot, p, dest))
+		}
+	}
+	return instructions
+}
+
+// finishConfig adds the subpackages and the variables to the config
+func (s *specImporter) finishConfig() {
+	for _, name := range s.subpackages {
+		if pkg, ok := s.packages[name]; ok {
+			if s.cfg.Package == nil {
+				s.cfg.Package = make(map[string]RpmPackage)
+			}
+			s.cfg.Package[name] = *pkg
+		}
+	}
+
+	for name, value := range s.macros {
+		if s.cfg.Variables == nil {
+			s.cfg.Variables = make(map[string]string)
+		}
+		s.cfg.Variables[name] = s.configValue(value)
+	}
+
+	// define the well known macros that the config uses and report the
+	// unknown ones
+	data, err := MarshalConfig(&s.cfg)
+	if err != nil {
+		return
+	}
+	reported := make(map[string]bool)
+	for _, m := range specMacroRe.FindAllStringSubmatch(strings.ReplaceAll(string(data), "%%", ""), -1) {
+		name := m[2]
+		switch {
+		case name == "" || reported[name]:
+		case name == "dist":
+			s.reportf(0, "%%{%sdist} is the release of the distribution in roci, e.g. 41, and not the dist tag like .fc41, use $AUTORELEASE for a release with the dist tag", m[1])
+		case strings.Contains(m[1], "?") || slices.Contains(BuiltinVariables, strings.ToUpper(name)):
+		case s.cfg.Variables[name] != "":
+		case specPathMacros[name] != "":
+			if s.cfg.Variables == nil {
+				s.cfg.Variables = make(map[string]string)
+			}
+			s.cfg.Variables[name] = specPathMacros[name]
+		default:
+			s.reportf(0, "the macro %%{%s} is not defined, it has to be added to Variables", name)
+		}
+		reported[name] = true
+	}
+}
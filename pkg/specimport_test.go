@@ -0,0 +1,238 @@
+package roci
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+const testSpec = `%global srcname poke
+%global _description %{expand:
+GNU poke is an interactive, extensible editor for binary data.}
+
+Name:           %{srcname}
+Version:        4.3
+Release:        %autorelease
+Summary:        Extensible editor for structured binary data
+License:        GPL-3.0-or-later
+URL:            https://www.jemarch.net/poke
+Source0:        https://ftp.gnu.org/gnu/poke/poke-%{version}.tar.gz
+Patch0:         poke-gcc14.patch
+
+BuildRequires:  gcc, make >= 4
+BuildRequires:  gc-devel
+Requires:       poke-data = %{version}-%{release}
+Requires(post,postun): /sbin/ldconfig
+Requires(pre):  shadow-utils
+Recommends:     (emacs if emacs-filesystem)
+
+%description
+GNU poke is an interactive, extensible editor for binary data.
+
+%package devel
+Summary:        Development files for poke
+Requires:       %{name}%{?_isa} = %{version}-%{release}
+
+%description devel
+Development files for poke.
+
+%package -n libpoke
+Summary:        Library of poke
+
+%description -n libpoke
+The poke library.
+
+%package unused
+Summary:        Not built
+
+%prep
+%autosetup -p1
+
+%build
+%if 0%{?fedora}
+%configure --disable-static
+%else
+%configure
+%endif
+%make_build
+
+%install
+%make_install
+rm -f %{buildroot}%{_libdir}/*.la
+
+%check
+make check %{?_smp_mflags}
+
+%post -p /sbin/ldconfig
+
+%postun
+/sbin/ldconfig
+
+%files
+%license COPYING
+%doc README
+%{_bindir}/poke
+%attr(0644,root,root) %{_mandir}/man1/poke.1*
+
+%files devel
+%{_includedir}/libpoke.h
+%dir %{_datadir}/poke
+
+%files -n libpoke
+%{_libdir}/libpoke.so.*
+
+%triggerin -- emacs
+echo foo
+
+%changelog
+%autochangelog
+`
+
+func TestImportSpec(t *testing.T) {
+	imp, err := ImportSpec(strings.NewReader(testSpec))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := imp.Config
+
+	if cfg.Name != "%{srcname}" || cfg.Version != "4.3" || cfg.Release != "$AUTORELEASE" {
+		t.Errorf("wrong NVR %s-%s-%s", cfg.Name, cfg.Version, cfg.Release)
+	}
+	if cfg.Variables["srcname"] != "poke" {
+		t.Errorf("srcname not imported: %v", cfg.Variables)
+	}
+	if !slices.Equal(cfg.Requires, []string{"poke-data = %{version}-%{release}"}) {
+		t.Errorf("wrong Requires %v", cfg.Requires)
+	}
+	if !slices.Equal(cfg.RequiresPre, []string{"shadow-utils"}) {
+		t.Errorf("wrong Requires(pre) %v", cfg.RequiresPre)
+	}
+	if !slices.Equal(cfg.RequiresCombined.Requires["post,postun"], []string{"/sbin/ldconfig"}) {
+		t.Errorf("wrong Requires(post,postun) %v", cfg.RequiresCombined.Requires)
+	}
+	if !slices.Equal(cfg.Recommends, []string{"(emacs if emacs-filesystem)"}) {
+		t.Errorf("wrong Recommends %v", cfg.Recommends)
+	}
+	if !slices.Equal(cfg.Postin.Options, []string{"-p", "/sbin/ldconfig"}) || cfg.Postun.Script != "/sbin/ldconfig\n" {
+		t.Errorf("wrong scriptlets %+v %+v", cfg.Postin, cfg.Postun)
+	}
+	if cfg.Changelog != "" {
+		t.Errorf("%%autochangelog must not be imported, got %q", cfg.Changelog)
+	}
+
+	if devel := cfg.Package["poke-devel"]; devel.Name != "-devel" || devel.Summary != "Development files for poke" || devel.Description != "Development files for poke.\n" {
+		t.Errorf("wrong poke-devel %+v", devel)
+	}
+	if lib, ok := cfg.Package["libpoke"]; !ok || lib.Name != "" || lib.Description != "The poke library.\n" {
+		t.Errorf("wrong libpoke %+v", lib)
+	}
+	if _, ok := cfg.Package["poke-unused"]; ok {
+		t.Errorf("a package without %%files must not be imported")
+	}
+
+	for _, instr := range []string{
+		"ARG BUILDROOT\nFROM ${BUILDROOT} as buildrequires\n",
+		"COPY poke-${VERSION}.tar.gz .",
+		"COPY poke-gcc14.patch .",
+		"RUN dnf -y install 'gcc' 'make >= 4' 'gc-devel'",
+		"FROM localhost/poke-buildrequires as build",
+		"tar -xf /src/poke-${VERSION}.tar.gz\npatch -d ${NAME}-${VERSION} -p1 < /src/poke-gcc14.patch\n",
+		"WORKDIR /src/${NAME}-${VERSION}",
+		"make install DESTDIR=/buildroot INSTALL=\"install -p\"\nrm -f /buildroot/usr/lib64/*.la\n",
+		"make check -j$(nproc)\n",
+		"FROM ${BUILDROOT} as poke\n",
+		"COPY --from=build /src/${NAME}-${VERSION}/COPYING /usr/share/licenses/poke/",
+		"COPY --from=build /buildroot/usr/bin/poke /usr/bin/poke",
+		"COPY --from=build /buildroot/usr/share/man/man1/poke.1* /usr/share/man/man1/",
+		"FROM ${BUILDROOT} as poke-devel\n",
+		"RUN mkdir -p /usr/share/poke",
+		"FROM ${BUILDROOT} as libpoke\n",
+		"COPY --from=build /buildroot/usr/lib64/libpoke.so.* /usr/lib64/",
+	} {
+		if !strings.Contains(imp.Containerfile, instr) {
+			t.Errorf("Containerfile does not contain %q:\n%s", instr, imp.Containerfile)
+		}
+	}
+	if strings.Contains(imp.Containerfile, "poke-unused") || strings.Contains(imp.Containerfile, "--disable-static\n%configure") {
+		t.Errorf("Containerfile contains skipped parts:\n%s", imp.Containerfile)
+	}
+
+	expected := []string{
+		"line 2: the multi line macro _description is not supported",
+		"line 11: https://ftp.gnu.org/gnu/poke/poke-%{version}.tar.gz must be downloaded into the dist-git directory",
+		`line 44: the condition "%if 0%{?fedora}" cannot be evaluated, only its first branch is imported`,
+		"line 45: the rpm macro %configure cannot be translated",
+		"line 67: %attr is not supported, %{_mandir}/man1/poke.1* is copied as it is",
+		"line 76: %triggerin is not supported and ignored",
+		"the package poke-unused has no %files and is not imported, as rpm would not build it",
+	}
+	if !slices.Equal(imp.Report, expected) {
+		t.Errorf("expected the report\n%s\ngot\n%s", strings.Join(expected, "\n"), strings.Join(imp.Report, "\n"))
+	}
+}
+
+func TestImportSpecConfigLoads(t *testing.T) {
+	imp, err := ImportSpec(strings.NewReader(testSpec))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := MarshalConfig(imp.Config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := writeConfig(t, string(data))
+	problems, err := LintConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range problems {
+		t.Errorf("%v", p)
+	}
+
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Expand(map[string]string{"DIST": "41", "AUTORELEASE": "1.fc41"}); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Name != "poke" || cfg.Package["poke-devel"].Requires[0] != "poke = 4.3-1.fc41" {
+		t.Errorf("wrong expansion %s %v", cfg.Name, cfg.Package["poke-devel"].Requires)
+	}
+}
+
+func TestImportSpecMacros(t *testing.T) {
+	imp, err := ImportSpec(strings.NewReader(`Name: foo
+Version: 1
+Release: 1%{?dist}
+Summary: Costs $5
+License: MIT
+Provides: foo-compat = 1%{?dist}
+
+%description
+Installs into %{_datadir}/foo.
+
+%files
+%{_datadir}/foo
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imp.Config.Release != "$AUTORELEASE" {
+		t.Errorf("expected the Release $AUTORELEASE, got %q", imp.Config.Release)
+	}
+	replaced := func(r string) bool { return strings.HasPrefix(r, "line 3: Release 1%{?dist} is replaced") }
+	if !slices.ContainsFunc(imp.Report, replaced) {
+		t.Errorf("the Release is not reported: %v", imp.Report)
+	}
+	if imp.Config.Summary != "Costs $$5" {
+		t.Errorf("$ is not escaped: %q", imp.Config.Summary)
+	}
+	if imp.Config.Variables["_datadir"] != "/usr/share" {
+		t.Errorf("_datadir is not defined: %v", imp.Config.Variables)
+	}
+	if !slices.ContainsFunc(imp.Report, func(r string) bool { return strings.HasPrefix(r, "%{?dist}") }) {
+		t.Errorf("%%{?dist} is not reported: %v", imp.Report)
+	}
+}